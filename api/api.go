@@ -2,9 +2,14 @@ package api
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/internal/mailer"
+	"github.com/chaeanthony/go-pos/internal/payments"
 	"github.com/charmbracelet/log"
 )
 
@@ -12,13 +17,25 @@ var ErrAuthorizeUser = errors.New("couldn't authorize user")
 var ErrAuthorizeUserRole = errors.New("couldn't authorize user role")
 
 type APIConfig struct {
-	DB             *database.Client
-	Port           string
-	JWTSecret      string
-	Logger         *log.Logger
-	Hub            *Hub
-	CookieSecure   bool
-	CookieSameSite http.SameSite
+	DB               *database.Client
+	Port             string
+	JWTKeys          *auth.KeySet
+	Logger           *log.Logger
+	Hub              *Hub
+	Denylist         *auth.Denylist
+	Mailer           mailer.Mailer
+	Payments         payments.Gateway // holds and captures cards for tabs
+	FrontendOrigin   string           // base URL for links in emails
+	MFARequiredRoles map[string]bool  // roles that must complete two-factor to log in
+	// order statuses in which line items can no longer be changed, besides voided and refunded
+	OrderEditLockedStatuses []string
+	CookieSecure            bool
+	CookieSameSite          http.SameSite
+	// takeout orders are released to the kitchen this long before their pickup time
+	PrepLeadTime time.Duration
+	// station tickets open longer than this are flagged late; zero turns late flags off
+	KitchenTargetPrepTime time.Duration
+	Scheduler             *Scheduler
 }
 
 func (cfg *APIConfig) HandlerReadiness(w http.ResponseWriter, r *http.Request) {
@@ -27,3 +44,12 @@ func (cfg *APIConfig) HandlerReadiness(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
+
+// clientIP returns the remote IP of r without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+// HandlerAPIKeysCreate issues a new API key. The key itself is only ever returned in this response.
+func (cfg *APIConfig) HandlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name        string     `json:"name"`
+		Scopes      []string   `json:"scopes"`
+		IPAllowlist []string   `json:"ip_allowlist"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+	}
+	if params.ExpiresAt != nil && params.ExpiresAt.Before(time.Now()) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Expiry must be in the future", nil)
+		return
+	}
+	allowlist, err := auth.ParseIPAllowlist(params.IPAllowlist)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	apiKey, err := cfg.DB.CreateAPIKey(database.CreateAPIKeyParams{
+		Name:        params.Name,
+		Prefix:      prefix,
+		KeyHash:     auth.HashToken(key),
+		UserID:      claims.UserID,
+		Scopes:      params.Scopes,
+		IPAllowlist: allowlist,
+		ExpiresAt:   params.ExpiresAt,
+	})
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+func (cfg *APIConfig) HandlerAPIKeysGet(w http.ResponseWriter, r *http.Request) {
+	keys, err := cfg.DB.GetAPIKeys()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, keys)
+}
+
+func (cfg *APIConfig) HandlerAPIKeysDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	apiKey, err := cfg.DB.GetAPIKey(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if apiKey.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find API key", nil)
+		return
+	}
+
+	err = cfg.DB.RevokeAPIKey(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
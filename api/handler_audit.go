@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+const defaultAuditLimit = 100
+
+// audit appends entry to the audit log, filling in the actor and IP from the request. Failures are
+// logged rather than failing the action that was already performed.
+func (cfg *APIConfig) audit(r *http.Request, entry database.CreateAuditEntryParams) {
+	if entry.ActorUserID == uuid.Nil && entry.ActorAPIKeyID == uuid.Nil {
+		if claims, ok := cfg.auditActor(r); ok {
+			entry.ActorUserID = claims.UserID
+			entry.ActorAPIKeyID = claims.APIKeyID
+		}
+	}
+	if entry.IPAddress == "" {
+		entry.IPAddress = clientIP(r)
+	}
+
+	if _, err := cfg.DB.AppendAuditEntry(entry); err != nil {
+		cfg.Logger.Errorf("couldn't write audit entry %s for %s %s: %v", entry.Action, entry.EntityType, entry.EntityID, err)
+	}
+}
+
+// auditActor identifies who made the request. Routes behind AuthMiddleware have claims in the
+// context; on public routes a valid access token is still used if one was sent.
+func (cfg *APIConfig) auditActor(r *http.Request) (auth.AccessClaims, bool) {
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		return claims, true
+	}
+
+	token, err := auth.GetBearerToken(r, auth.AccessToken)
+	if err != nil || auth.IsAPIKey(token) {
+		return auth.AccessClaims{}, false
+	}
+	claims, err := auth.ValidateJWTClaims(token, cfg.JWTKeys)
+	if err != nil {
+		return auth.AccessClaims{}, false
+	}
+	if cfg.Denylist != nil && cfg.Denylist.Contains(claims.SessionID) {
+		return auth.AccessClaims{}, false
+	}
+	return claims, true
+}
+
+// HandlerAuditGet lists audit entries, newest first. Filters: ?action=, ?actor=, ?entity_type=,
+// ?entity_id=, ?since= and ?until= (RFC 3339), ?before= (entry ID cursor) and ?limit=.
+func (cfg *APIConfig) HandlerAuditGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := database.AuditFilter{
+		Action:     q.Get("action"),
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+		Limit:      defaultAuditLimit,
+	}
+
+	if actor := q.Get("actor"); actor != "" {
+		id, err := uuid.Parse(actor)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid actor", err)
+			return
+		}
+		filter.ActorUserID = id
+	}
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid "+name, err)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if before := q.Get("before"); before != "" {
+		n, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid before", err)
+			return
+		}
+		filter.BeforeID = n
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		filter.Limit = min(n, 1000)
+	}
+
+	entries, err := cfg.DB.GetAuditEntries(filter)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get audit log", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, entries)
+}
+
+// HandlerAuditVerify recomputes the hash chain and reports the first entry that was tampered with.
+func (cfg *APIConfig) HandlerAuditVerify(w http.ResponseWriter, r *http.Request) {
+	result, err := cfg.DB.VerifyAuditLog()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't verify audit log", err)
+		return
+	}
+
+	if !result.Valid {
+		cfg.Logger.Errorf("audit log verification failed at entry %d: %s", result.BrokenAt, result.Reason)
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, result)
+}
@@ -2,29 +2,29 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/internal/database"
 	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
 )
 
 const (
-	JWT_EXPIRATION = 15 * time.Minute
+	JWT_EXPIRATION           = 15 * time.Minute
+	REFRESH_TOKEN_EXPIRATION = 60 * 24 * time.Hour
 )
 
 func (cfg *APIConfig) HandlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password   string `json:"password"`
+		Email      string `json:"email"`
+		DeviceName string `json:"device_name"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
-
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -33,59 +33,162 @@ func (cfg *APIConfig) HandlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.GetUserByEmail(params.Email)
+	attempt := database.CreateLoginAttemptParams{
+		Email:     database.NormalizeEmail(params.Email),
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	retryAfter, err := cfg.loginRetryAfter(attempt.Email, attempt.IPAddress)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if retryAfter > 0 {
+		attempt.Reason = database.LoginReasonThrottled
+		cfg.recordLoginAttempt(attempt)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		utils.RespondError(w, cfg.Logger, http.StatusTooManyRequests, "Too many login attempts. Try again later", nil)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(attempt.Email)
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.Password)
+	// unknown emails still pay for a bcrypt comparison so they can't be told apart by timing
+	err = auth.CheckPasswordHashOrDummy(params.Password, user.Password)
 	if err != nil {
+		attempt.Reason = database.LoginReasonUnknownUser
+		if user.ID != uuid.Nil {
+			attempt.UserID = &user.ID
+			attempt.Reason = database.LoginReasonInvalidPassword
+		}
+		cfg.recordLoginAttempt(attempt)
 		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
+	mfa, err := cfg.DB.GetMFA(user.ID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	// the login only succeeds, and resets the throttle, once the second factor passes
+	if mfa.EnabledAt != nil || cfg.MFARequiredRoles[user.Role] {
+		cfg.respondMFAChallenge(w, user, params.DeviceName, mfa.EnabledAt == nil)
+		return
+	}
+
+	attempt.UserID = &user.ID
+	attempt.Success = true
+	attempt.Reason = database.LoginReasonSuccess
+	cfg.recordLoginAttempt(attempt)
+
+	cfg.respondLogin(w, r, user, params.DeviceName, nil)
+}
+
+type loginResponse struct {
+	database.User
+	Token         string   `json:"token"`
+	RefreshToken  string   `json:"refresh_token"`
+	CSRFToken     string   `json:"csrf_token"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // only when two-factor enrollment was just completed
+}
+
+// respondLogin starts a new session for a fully authenticated user and responds with its tokens.
+func (cfg *APIConfig) respondLogin(w http.ResponseWriter, r *http.Request, user database.User, deviceName string, recoveryCodes []string) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	rt, err := cfg.DB.CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:     user.ID,
+		Token:      refreshToken,
+		FamilyID:   uuid.New(), // new login starts a new token family
+		ExpiresAt:  time.Now().UTC().Add(REFRESH_TOKEN_EXPIRATION),
+		DeviceName: deviceName,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+	})
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		return
+	}
+
 	jwtRole := user.Role
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.JWTSecret,
+		cfg.JWTKeys,
 		JWT_EXPIRATION,
 		jwtRole,
+		rt.FamilyID,
 	)
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	csrfToken, err := cfg.setCSRFCookie(w)
 	if err != nil {
-		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create CSRF token", err)
 		return
 	}
 
-	_, err = cfg.DB.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+	cfg.audit(r, database.CreateAuditEntryParams{
+		ActorUserID: user.ID,
+		Action:      database.AuditLogin,
+		EntityType:  "user",
+		EntityID:    user.ID.String(),
+		Details:     map[string]string{"session_id": rt.FamilyID.String(), "device_name": deviceName},
 	})
-	if err != nil {
-		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
-	}
 
 	auth.SetTokenCookie(w, accessToken, auth.AccessToken, "/", JWT_EXPIRATION, cfg.CookieSameSite, cfg.CookieSecure)
-	auth.SetTokenCookie(w, refreshToken, auth.RefreshToken, "/", JWT_EXPIRATION, cfg.CookieSameSite, cfg.CookieSecure)
-	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
+	auth.SetTokenCookie(w, refreshToken, auth.RefreshToken, "/", time.Until(rt.ExpiresAt), cfg.CookieSameSite, cfg.CookieSecure)
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, loginResponse{
+		User:          user,
+		Token:         accessToken,
+		RefreshToken:  refreshToken,
+		CSRFToken:     csrfToken,
+		RecoveryCodes: recoveryCodes,
 	})
 }
 
+// loginRetryAfter returns how long the account or IP must wait before trying to log in again.
+func (cfg *APIConfig) loginRetryAfter(email, ip string) (time.Duration, error) {
+	now := time.Now().UTC()
+
+	byEmail, err := cfg.DB.GetLoginFailuresByEmail(email, now.Add(-auth.AccountLoginThrottle.Window))
+	if err != nil {
+		return 0, err
+	}
+	byIP, err := cfg.DB.GetLoginFailuresByIP(ip, now.Add(-auth.IPLoginThrottle.Window))
+	if err != nil {
+		return 0, err
+	}
+
+	return max(
+		auth.AccountLoginThrottle.RetryAfter(byEmail.Count, byEmail.LastAt, now),
+		auth.IPLoginThrottle.RetryAfter(byIP.Count, byIP.LastAt, now),
+	), nil
+}
+
+func (cfg *APIConfig) recordLoginAttempt(attempt database.CreateLoginAttemptParams) {
+	if err := cfg.DB.CreateLoginAttempt(attempt); err != nil {
+		cfg.Logger.Errorf("couldn't record login attempt for %s: %v", attempt.Email, err)
+	}
+}
+
 func (cfg *APIConfig) HandlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		CSRFToken    string `json:"csrf_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r, auth.RefreshToken)
@@ -94,45 +197,129 @@ func (cfg *APIConfig) HandlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rt, err := cfg.DB.GetRefreshToken(refreshToken)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	if rt.ReplacedBy != nil {
+		// token was already rotated, so someone is replaying it. End the whole session.
+		cfg.revokeTokenFamily(rt)
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid refresh token", database.ErrRefreshTokenReused)
+		return
+	}
+
 	user, err := cfg.DB.GetUserByRefreshToken(refreshToken)
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Refresh token expired or revoked", nil)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	newRT, err := cfg.DB.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		UserID:     user.ID,
+		Token:      newRefreshToken,
+		FamilyID:   rt.FamilyID,
+		ExpiresAt:  time.Now().UTC().Add(REFRESH_TOKEN_EXPIRATION),
+		DeviceName: rt.DeviceName,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+	})
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		// lost a race with another refresh using the same token
+		cfg.revokeTokenFamily(rt)
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
 
 	jwtRole := user.Role
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.JWTSecret,
+		cfg.JWTKeys,
 		JWT_EXPIRATION,
 		jwtRole,
+		newRT.FamilyID,
 	)
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't validate token for refresh", err)
 		return
 	}
 
-	auth.SetTokenCookie(w, accessToken, auth.AccessToken, "/", JWT_EXPIRATION, http.SameSiteLaxMode, false)
+	csrfToken, err := cfg.ensureCSRFCookie(w, r)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create CSRF token", err)
+		return
+	}
+
+	auth.SetTokenCookie(w, accessToken, auth.AccessToken, "/", JWT_EXPIRATION, cfg.CookieSameSite, cfg.CookieSecure)
+	auth.SetTokenCookie(w, newRefreshToken, auth.RefreshToken, "/", time.Until(newRT.ExpiresAt), cfg.CookieSameSite, cfg.CookieSecure)
 	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
+		CSRFToken:    csrfToken,
 	})
 }
 
+// revokeTokenFamily ends the session rt belongs to after refresh token reuse is detected.
+func (cfg *APIConfig) revokeTokenFamily(rt database.RefreshToken) {
+	cfg.Logger.Warnf("Refresh token reuse detected for user %s, revoking family %s", rt.UserID, rt.FamilyID)
+	if err := cfg.revokeSession(rt.FamilyID); err != nil {
+		cfg.Logger.Errorf("couldn't revoke refresh token family %s: %v", rt.FamilyID, err)
+	}
+}
+
+// revokeSession revokes a refresh token family and denies access tokens already issued for it.
+func (cfg *APIConfig) revokeSession(sessionID uuid.UUID) error {
+	if cfg.Denylist != nil {
+		cfg.Denylist.Add(sessionID, JWT_EXPIRATION)
+	}
+	return cfg.DB.RevokeRefreshTokenFamily(sessionID)
+}
+
 func (cfg *APIConfig) HandlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r, auth.RefreshToken)
-	if err != nil {
+	if err != nil && !errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
 		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't find token", err)
 		return
 	}
 
-	err = cfg.DB.RevokeRefreshToken(refreshToken)
-	if err != nil {
-		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't revoke session", err)
-		return
+	// logging out without a session just clears whatever cookies are left
+	var rt database.RefreshToken
+	if refreshToken != "" {
+		rt, err = cfg.DB.GetRefreshToken(refreshToken)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get refresh token", err)
+			return
+		}
+	}
+	if rt.Token != "" {
+		err = cfg.revokeSession(rt.FamilyID)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't revoke session", err)
+			return
+		}
 	}
 
 	auth.ClearTokenCookie(w, auth.RefreshToken, "/", http.SameSiteLaxMode, false)
+	auth.ClearTokenCookie(w, auth.CSRFToken, "/", cfg.CookieSameSite, cfg.CookieSecure)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -143,12 +330,21 @@ func (cfg *APIConfig) HandlerSession(w http.ResponseWriter, r *http.Request) {
 		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't find token", err)
 		return
 	}
-	cfg.Logger.Printf("Checking token: %s", token)
-	_, _, err = auth.ValidateJWT(token, cfg.JWTSecret)
+	claims, err := auth.ValidateJWTClaims(token, cfg.JWTKeys)
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid session. Couldn't validate token", err)
 		return
 	}
+	if cfg.Denylist != nil && cfg.Denylist.Contains(claims.SessionID) {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid session. Session has been revoked", nil)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// HandlerJWKS publishes the public keys used to sign access tokens so other services can verify them.
+func (cfg *APIConfig) HandlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, cfg.JWTKeys.JWKS())
+}
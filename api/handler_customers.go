@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+const defaultCustomersLimit = 100
+
+// HandlerCustomersGet lists customers, optionally filtered by ?search= on name, email or phone.
+func (cfg *APIConfig) HandlerCustomersGet(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	limit := defaultCustomersLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	customers, err := cfg.DB.GetCustomers(search, limit)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get customers", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, customers)
+}
+
+func (cfg *APIConfig) HandlerCustomerGet(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("customerID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid customer ID", err)
+		return
+	}
+
+	customer, err := cfg.DB.GetCustomer(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get customer", err)
+		return
+	}
+	if customer.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find customer", nil)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, customer)
+}
+
+// HandlerCustomersCreate adds a customer, or updates the existing one with the same email.
+func (cfg *APIConfig) HandlerCustomersCreate(w http.ResponseWriter, r *http.Request) {
+	params := database.CreateCustomerParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if strings.TrimSpace(params.Email) == "" && strings.TrimSpace(params.Phone) == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Email or phone is required", nil)
+		return
+	}
+
+	customer, err := cfg.DB.UpsertCustomer(params)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't save customer", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, customer)
+}
+
+// HandlerMeOrders lists the logged-in customer's past and current orders. Accounts are linked to
+// orders placed under the same email once that email is verified.
+func (cfg *APIConfig) HandlerMeOrders(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+
+	customer, err := cfg.DB.GetCustomerByUser(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get customer", err)
+		return
+	}
+
+	if customer.ID == uuid.Nil {
+		user, err := cfg.DB.GetUserById(claims.UserID)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user.EmailVerifiedAt == nil {
+			utils.RespondError(w, cfg.Logger, http.StatusForbidden, "Verify your email to see your orders", nil)
+			return
+		}
+
+		customer, err = cfg.DB.LinkCustomerUser(user)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't link customer", err)
+			return
+		}
+		if customer.UserID == nil || *customer.UserID != user.ID {
+			// the customer with this email already belongs to another account
+			utils.RespondError(w, cfg.Logger, http.StatusConflict, "Couldn't link orders to this account", nil)
+			return
+		}
+	}
+
+	orders, err := cfg.DB.GetOrdersByCustomer(customer.ID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get orders", err)
+		return
+	}
+
+	hideInternalNotes(orders)
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, orders)
+}
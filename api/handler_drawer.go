@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+// HandlerDrawerOpen records a cash drawer open and tells the register connected over the websocket to
+// kick the drawer. A reason is required for opens that aren't part of a sale.
+func (cfg *APIConfig) HandlerDrawerOpen(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		OrderID int    `json:"order_id"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.OrderID == 0 && params.Reason == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "A reason is required when opening the drawer without an order", nil)
+		return
+	}
+
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditDrawerOpened,
+		EntityType: "drawer",
+		Details:    params,
+	})
+
+	msg, err := json.Marshal(struct {
+		Type string `json:"type"`
+	}{
+		Type: "open_drawer",
+	})
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't open drawer", err)
+		return
+	}
+	cfg.Hub.Broadcast(msg)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/internal/giftcard"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const giftCardLedgerLimit = 20
+
+func (cfg *APIConfig) HandlerGiftCardsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Amount     decimal.Decimal `json:"amount"`
+		PIN        string          `json:"pin"`
+		CustomerID *uuid.UUID      `json:"customer_id"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validAmount(params.Amount) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Amount must be positive and in whole cents", nil)
+		return
+	}
+
+	pinHash := ""
+	if params.PIN != "" {
+		if !validPIN(params.PIN) {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "PIN must be 4 to 8 digits", nil)
+			return
+		}
+		hash, err := auth.HashPassword(params.PIN)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't hash PIN", err)
+			return
+		}
+		pinHash = hash
+	}
+
+	card, err := cfg.DB.CreateGiftCard(database.CreateGiftCardParams{
+		Kind:        database.GiftCardKindGiftCard,
+		PINHash:     pinHash,
+		CustomerID:  params.CustomerID,
+		AmountCents: utils.DecimalToInt(params.Amount),
+	})
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create gift card", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, card)
+}
+
+// HandlerGiftCardBalance looks a card up by number, and PIN if it has one. The number is sent in the
+// body rather than the URL so it doesn't end up in access logs.
+func (cfg *APIConfig) HandlerGiftCardBalance(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Number string `json:"number"`
+		PIN    string `json:"pin"`
+	}
+	type response struct {
+		database.GiftCard
+		Entries []database.GiftCardEntry `json:"entries"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	card, ok := cfg.unlockGiftCard(w, params.Number, params.PIN)
+	if !ok {
+		return
+	}
+
+	entries, err := cfg.DB.GetGiftCardLedger(card.ID, giftCardLedgerLimit)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get gift card history", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{GiftCard: card, Entries: entries})
+}
+
+// HandlerGiftCardReload adds money to a gift card. No PIN is needed to add funds.
+func (cfg *APIConfig) HandlerGiftCardReload(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Number string          `json:"number"`
+		Amount decimal.Decimal `json:"amount"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validAmount(params.Amount) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Amount must be positive and in whole cents", nil)
+		return
+	}
+
+	card, ok := cfg.findGiftCard(w, params.Number)
+	if !ok {
+		return
+	}
+
+	card, err := cfg.DB.ReloadGiftCard(card.ID, utils.DecimalToInt(params.Amount))
+	if errors.Is(err, database.ErrGiftCardNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Store credit can't be reloaded", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't reload gift card", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, card)
+}
+
+// HandlerGiftCardUnlock clears a card's wrong PINs so it can be used again, once a manager has
+// checked who is holding it.
+func (cfg *APIConfig) HandlerGiftCardUnlock(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Number string `json:"number"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	card, ok := cfg.findGiftCard(w, params.Number)
+	if !ok {
+		return
+	}
+	if err := cfg.DB.ResetGiftCardPINFailures(card.ID); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't unlock gift card", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditGiftCardUnlocked,
+		EntityType: "gift_card",
+		EntityID:   card.ID.String(),
+		Details:    map[string]int{"pin_failures": card.PINFailures},
+	})
+
+	card.PINFailures = 0
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, card)
+}
+
+// HandlerOrderPaymentsCreate records a payment towards an order. Gift card and store credit tenders
+// pay as much as the card's balance covers; the response says what is still due.
+func (cfg *APIConfig) HandlerOrderPaymentsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tender         string          `json:"tender"`
+		Amount         decimal.Decimal `json:"amount"`
+		GiftCardNumber string          `json:"gift_card_number"`
+		PIN            string          `json:"pin"`
+		Reference      string          `json:"reference"`
+	}
+	type response struct {
+		Payment database.Payment        `json:"payment"`
+		Summary database.PaymentSummary `json:"summary"`
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !database.ValidTender(params.Tender) || !validAmount(params.Amount) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid tender or amount", nil)
+		return
+	}
+
+	payment := database.CreatePaymentParams{
+		OrderID:     orderID,
+		Tender:      params.Tender,
+		AmountCents: utils.DecimalToInt(params.Amount),
+		Reference:   params.Reference,
+	}
+	if params.Tender == database.TenderGiftCard || params.Tender == database.TenderStoreCredit {
+		card, ok := cfg.unlockGiftCard(w, params.GiftCardNumber, params.PIN)
+		if !ok {
+			return
+		}
+		payment.GiftCardID = &card.ID
+	}
+
+	created, err := cfg.DB.CreatePayment(payment)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", err)
+		case errors.Is(err, database.ErrOrderAlreadyPaid),
+			errors.Is(err, database.ErrOrderNotPayable),
+			errors.Is(err, database.ErrGiftCardInsufficient):
+			utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+		case errors.Is(err, database.ErrInvalidTender):
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Card doesn't match the tender", err)
+		default:
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't record payment", err)
+		}
+		return
+	}
+
+	summary, err := cfg.DB.GetPaymentSummary(orderID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get payments", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, response{Payment: created, Summary: summary})
+	cfg.broadcastRefreshOrders()
+}
+
+func (cfg *APIConfig) HandlerOrderPaymentsGet(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	summary, err := cfg.DB.GetPaymentSummary(orderID)
+	if errors.Is(err, database.ErrOrderNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get payments", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, summary)
+}
+
+// HandlerPaymentsGet lists the payments of every order, oldest first, for exports such as accounting
+// syncs. Filters: ?since= and ?until= (RFC 3339, on when the payment was taken), ?cursor= and ?limit=.
+// The next page's cursor is sent in the X-Next-Cursor header.
+func (cfg *APIConfig) HandlerPaymentsGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := database.PaymentFilter{Limit: defaultOrdersLimit}
+
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid "+name, err)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		filter.AfterID = id
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		filter.Limit = min(n, 1000)
+	}
+
+	payments, err := cfg.DB.GetAllPayments(filter)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get payments", err)
+		return
+	}
+
+	if len(payments) == filter.Limit {
+		w.Header().Set("X-Next-Cursor", strconv.Itoa(payments[len(payments)-1].ID))
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, payments)
+}
+
+// HandlerOrdersRefund refunds an order. Gift card payments go back onto their cards; with
+// store_credit the rest is issued as store credit instead of being refunded to the original tender.
+// Only managers may refund.
+func (cfg *APIConfig) HandlerOrdersRefund(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StoreCredit bool `json:"store_credit"`
+	}
+	type response struct {
+		StoreCredit *database.GiftCard `json:"store_credit"`
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if claims.Role != auth.RoleManager {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "You are not authorized to void or refund orders", nil)
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	credit, err := cfg.DB.RefundOrder(database.RefundOrderParams{OrderID: orderID, StoreCredit: params.StoreCredit})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", err)
+		case errors.Is(err, database.ErrOrderNotPayable):
+			utils.RespondError(w, cfg.Logger, http.StatusConflict, "Order was already voided or refunded", err)
+		case errors.Is(err, database.ErrNothingToCredit):
+			utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+		default:
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't refund order", err)
+		}
+		return
+	}
+
+	resp := response{}
+	details := map[string]interface{}{"store_credit": params.StoreCredit}
+	if credit.ID != uuid.Nil {
+		resp.StoreCredit = &credit
+		details["store_credit_cents"] = credit.BalanceCents
+		details["store_credit_id"] = credit.ID
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditOrderRefunded,
+		EntityType: "order",
+		EntityID:   strconv.Itoa(orderID),
+		Details:    details,
+	})
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, resp)
+	cfg.broadcastRefreshOrders()
+}
+
+// findGiftCard looks a card up by number. It responds with an error and returns false if there is none.
+func (cfg *APIConfig) findGiftCard(w http.ResponseWriter, number string) (database.GiftCard, bool) {
+	number, err := giftcard.Normalize(number)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid gift card number", err)
+		return database.GiftCard{}, false
+	}
+
+	card, err := cfg.DB.GetGiftCardByNumber(number)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get gift card", err)
+		return database.GiftCard{}, false
+	}
+	if card.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find gift card", nil)
+		return database.GiftCard{}, false
+	}
+
+	return card, true
+}
+
+// unlockGiftCard looks a card up and checks its PIN, locking the card after too many wrong PINs.
+// It responds with an error and returns false if the card can't be used.
+func (cfg *APIConfig) unlockGiftCard(w http.ResponseWriter, number, pin string) (database.GiftCard, bool) {
+	card, ok := cfg.findGiftCard(w, number)
+	if !ok {
+		return database.GiftCard{}, false
+	}
+	if !card.HasPIN() {
+		return card, true
+	}
+
+	// the attempt counts as wrong until the PIN checks out, so parallel guesses can't go over the limit
+	err := cfg.DB.CountGiftCardPINAttempt(card.ID)
+	if errors.Is(err, database.ErrGiftCardLocked) {
+		utils.RespondError(w, cfg.Logger, http.StatusLocked, "Gift card is locked", err)
+		return database.GiftCard{}, false
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't check gift card PIN", err)
+		return database.GiftCard{}, false
+	}
+	if err := auth.CheckPasswordHash(pin, card.PINHash); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Incorrect gift card PIN", nil)
+		return database.GiftCard{}, false
+	}
+	if err := cfg.DB.ResetGiftCardPINFailures(card.ID); err != nil {
+		cfg.Logger.Errorf("couldn't reset PIN failures for gift card %s: %v", card.ID, err)
+	}
+	card.PINFailures = 0
+
+	return card, true
+}
+
+// validAmount reports whether amount can be charged: positive and without fractions of a cent, which
+// would otherwise be dropped when converted to cents.
+func validAmount(amount decimal.Decimal) bool {
+	return amount.IsPositive() && amount.Equal(amount.Truncate(2))
+}
+
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
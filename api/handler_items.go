@@ -19,6 +19,8 @@ func (cfg *APIConfig) HandlerItemsGet(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		Cost        string `json:"cost"`
+		Category    string `json:"category"`
+		Station     string `json:"station"`
 		CreatedAt   string `json:"created_at"`
 		UpdatedAt   string `json:"updated_at"`
 	}
@@ -38,6 +40,8 @@ func (cfg *APIConfig) HandlerItemsGet(w http.ResponseWriter, r *http.Request) {
 			Name:        item.Name,
 			Description: item.Description,
 			Cost:        fmt.Sprintf("%.2f", costDecimal), // format with 2 decimals as string
+			Category:    item.Category,
+			Station:     item.Station,
 			CreatedAt:   item.CreatedAt,
 			UpdatedAt:   item.UpdatedAt,
 		}
@@ -70,6 +74,8 @@ func (cfg *APIConfig) HandlerItemsCreate(w http.ResponseWriter, r *http.Request)
 		Name        string          `json:"name"`
 		Description string          `json:"description"`
 		Cost        decimal.Decimal `json:"cost"`
+		Category    string          `json:"category"`
+		Station     string          `json:"station"`
 	}
 
 	params := parameters{}
@@ -78,11 +84,17 @@ func (cfg *APIConfig) HandlerItemsCreate(w http.ResponseWriter, r *http.Request)
 		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
+	if params.Station != "" && !database.ValidStation(params.Station) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid station", nil)
+		return
+	}
 
 	itemID, err := cfg.DB.CreateItem(database.CreateItemParams{
 		Name:        params.Name,
 		Description: params.Description,
 		Cost:        int(params.Cost.Mul(decimal.NewFromInt(100)).IntPart()),
+		Category:    params.Category,
+		Station:     params.Station,
 	})
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create item", err)
@@ -98,6 +110,8 @@ func (cfg *APIConfig) HandlerItemsUpdate(w http.ResponseWriter, r *http.Request)
 		Name        string          `json:"name"`
 		Description string          `json:"description"`
 		Cost        decimal.Decimal `json:"cost"`
+		Category    string          `json:"category"`
+		Station     string          `json:"station"`
 	}
 
 	params := parameters{}
@@ -106,15 +120,40 @@ func (cfg *APIConfig) HandlerItemsUpdate(w http.ResponseWriter, r *http.Request)
 		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
+	if params.Station != "" && !database.ValidStation(params.Station) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid station", nil)
+		return
+	}
 
+	item, err := cfg.DB.GetItemByID(params.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find item", err)
+		} else {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get item", err)
+		}
+		return
+	}
+
+	cost := int(params.Cost.Mul(decimal.NewFromInt(100)).IntPart())
 	err = cfg.DB.UpdateItem(database.UpdateItemParams{
-		ID: params.ID, Name: params.Name, Description: params.Description, Cost: int(params.Cost.Mul(decimal.NewFromInt(100)).IntPart()),
+		ID: params.ID, Name: params.Name, Description: params.Description, Cost: cost,
+		Category: params.Category, Station: params.Station,
 	})
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't update item", err)
 		return
 	}
 
+	if cost != item.Cost {
+		cfg.audit(r, database.CreateAuditEntryParams{
+			Action:     database.AuditItemPriceEdited,
+			EntityType: "item",
+			EntityID:   item.ID,
+			Details:    map[string]int{"old_cost": item.Cost, "new_cost": cost},
+		})
+	}
+
 	utils.RespondJSON(w, cfg.Logger, http.StatusOK, map[string]string{"status": "updated", "id": params.ID})
 }
 
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+const defaultStationTicketsLimit = 100
+
+// HandlerKDSGet returns a station's tickets, each with only the station's items. ?status=bumped lists
+// recently bumped tickets to recall instead of the open ones.
+func (cfg *APIConfig) HandlerKDSGet(w http.ResponseWriter, r *http.Request) {
+	station := r.PathValue("station")
+	if !database.ValidStation(station) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find station", nil)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.StationTicketOpen
+	case database.StationTicketOpen, database.StationTicketBumped:
+	default:
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid status", nil)
+		return
+	}
+
+	limit := defaultStationTicketsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	tickets, err := cfg.DB.GetStationTickets(station, status, limit)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tickets", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tickets)
+}
+
+// HandlerKDSBump marks a station's ticket done. Once every station has bumped its ticket the order is
+// ready and the front of house is told.
+func (cfg *APIConfig) HandlerKDSBump(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.stationTicketPath(w, r)
+	if !ok {
+		return
+	}
+
+	ticket, ready, err := cfg.DB.BumpStationTicket(id)
+	if errors.Is(err, database.ErrStationTicketNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't bump ticket", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, ticket)
+	cfg.sendStationTicket("kds_ticket", ticket)
+	if ready {
+		cfg.broadcastOrder("order_ready", ticket.Order.ID)
+		cfg.broadcastRefreshOrders()
+	}
+}
+
+// HandlerKDSStart records that a station started making a ticket, for prep time reports.
+func (cfg *APIConfig) HandlerKDSStart(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.stationTicketPath(w, r)
+	if !ok {
+		return
+	}
+
+	ticket, err := cfg.DB.StartStationTicket(id)
+	if errors.Is(err, database.ErrStationTicketNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't start ticket", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, ticket)
+	cfg.sendStationTicket("kds_ticket", ticket)
+}
+
+// HandlerKDSRecall puts a bumped ticket back on its station's screen.
+func (cfg *APIConfig) HandlerKDSRecall(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.stationTicketPath(w, r)
+	if !ok {
+		return
+	}
+
+	ticket, err := cfg.DB.RecallStationTicket(id)
+	if errors.Is(err, database.ErrStationTicketNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't recall ticket", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, ticket)
+	cfg.sendStationTicket("kds_ticket", ticket)
+	cfg.broadcastRefreshOrders()
+	cfg.Scheduler.Wake()
+}
+
+func (cfg *APIConfig) HandlerStationRoutesGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Stations       []string                `json:"stations"`
+		DefaultStation string                  `json:"default_station"`
+		Routes         []database.StationRoute `json:"routes"`
+	}
+
+	routes, err := cfg.DB.GetStationRoutes()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get station routes", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{
+		Stations:       database.Stations,
+		DefaultStation: database.DefaultStation,
+		Routes:         routes,
+	})
+}
+
+// HandlerStationRoutesUpdate sends a category of items to a station. Items with a station of their
+// own keep it.
+func (cfg *APIConfig) HandlerStationRoutesUpdate(w http.ResponseWriter, r *http.Request) {
+	params := database.StationRoute{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	err := cfg.DB.SetStationRoute(params)
+	if errors.Is(err, database.ErrInvalidStation) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid category or station", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't update station route", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, params)
+}
+
+func (cfg *APIConfig) HandlerStationRoutesDelete(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.DB.DeleteStationRoute(r.PathValue("category")); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't delete station route", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerKitchenReport returns prep times by station and item for the business days ?from to ?to,
+// both defaulting to today.
+func (cfg *APIConfig) HandlerKitchenReport(w http.ResponseWriter, r *http.Request) {
+	params := database.KitchenReportParams{
+		From:   r.URL.Query().Get("from"),
+		To:     r.URL.Query().Get("to"),
+		Target: cfg.KitchenTargetPrepTime,
+	}
+	if params.From == "" {
+		params.From = cfg.DB.Today()
+	}
+	if params.To == "" {
+		params.To = cfg.DB.Today()
+	}
+
+	report, err := cfg.DB.GetKitchenReport(params)
+	if errors.Is(err, database.ErrInvalidKitchenReport) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get kitchen report", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, report)
+}
+
+func (cfg *APIConfig) stationTicketPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("ticketID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid ticket ID", err)
+		return 0, false
+	}
+	return id, true
+}
+
+// broadcastStationTickets sends each station its current ticket for an order. Stations whose ticket
+// is gone, e.g. because the order was voided or their last item removed, are told to drop it; pass
+// stations that may have lost their ticket in also. The scheduler is woken to flag newly opened
+// tickets late on time.
+func (cfg *APIConfig) broadcastStationTickets(orderID int, also ...string) {
+	tickets, err := cfg.DB.GetOrderStationTickets(orderID)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get station tickets of order %d: %v", orderID, err)
+		return
+	}
+
+	sent := []string{}
+	for _, ticket := range tickets {
+		switch ticket.Order.Status {
+		case database.OrderStatusScheduled, database.OrderStatusVoided, database.OrderStatusRefunded:
+			cfg.sendStationTicket("kds_ticket_removed", ticket)
+		default:
+			cfg.sendStationTicket("kds_ticket", ticket)
+		}
+		sent = append(sent, ticket.Station)
+	}
+
+	for _, station := range also {
+		if station != "" && !slices.Contains(sent, station) {
+			cfg.sendStationTicket("kds_ticket_removed", database.StationTicket{Station: station, Order: database.Order{ID: orderID}})
+		}
+	}
+	cfg.Scheduler.Wake()
+}
+
+// sendStationTicket sends a ticket to its station's screens. Internal notes and customer details are
+// left out, like in broadcastOrder.
+func (cfg *APIConfig) sendStationTicket(eventType string, ticket database.StationTicket) {
+	hideCustomerDetails(&ticket.Order)
+
+	msg, err := json.Marshal(struct {
+		Type   string                 `json:"type"`
+		Ticket database.StationTicket `json:"ticket"`
+	}{
+		Type:   eventType,
+		Ticket: ticket,
+	})
+	if err != nil {
+		cfg.Logger.Errorf("couldn't marshal %s message: %v", eventType, err)
+		return
+	}
+	cfg.Hub.BroadcastStation(ticket.Station, msg)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+const defaultLoginAttemptsLimit = 100
+
+// HandlerLoginAttemptsGet lists recent failed logins, optionally filtered by ?email=.
+func (cfg *APIConfig) HandlerLoginAttemptsGet(w http.ResponseWriter, r *http.Request) {
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+
+	limit := defaultLoginAttemptsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	attempts, err := cfg.DB.GetFailedLoginAttempts(email, limit)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get login attempts", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, attempts)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+const loyaltyLedgerLimit = 20
+
+func (cfg *APIConfig) HandlerLoyaltyRulesGet(w http.ResponseWriter, r *http.Request) {
+	rules, err := cfg.DB.GetLoyaltyRules()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get loyalty rules", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, rules)
+}
+
+func (cfg *APIConfig) HandlerLoyaltyRulesCreate(w http.ResponseWriter, r *http.Request) {
+	params := database.CreateLoyaltyRuleParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := params.Validate(); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	rule, err := cfg.DB.CreateLoyaltyRule(params)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create loyalty rule", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, rule)
+}
+
+func (cfg *APIConfig) HandlerLoyaltyRulesDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("ruleID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid rule ID", err)
+		return
+	}
+
+	rule, err := cfg.DB.GetLoyaltyRule(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get loyalty rule", err)
+		return
+	}
+	if rule.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find loyalty rule", nil)
+		return
+	}
+
+	if err := cfg.DB.DeactivateLoyaltyRule(id); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't delete loyalty rule", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerLoyaltyLookup finds a customer by ?email= or ?phone= and returns their points balance with
+// recent ledger entries.
+func (cfg *APIConfig) HandlerLoyaltyLookup(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Customer database.Customer       `json:"customer"`
+		Balance  int                     `json:"balance"`
+		Entries  []database.LoyaltyEntry `json:"entries"`
+	}
+
+	var customer database.Customer
+	var err error
+	switch {
+	case r.URL.Query().Get("email") != "":
+		customer, err = cfg.DB.GetCustomerByEmail(r.URL.Query().Get("email"))
+	case r.URL.Query().Get("phone") != "":
+		customer, err = cfg.DB.GetCustomerByPhone(r.URL.Query().Get("phone"))
+	default:
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Email or phone is required", nil)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get customer", err)
+		return
+	}
+	if customer.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find customer", nil)
+		return
+	}
+
+	balance, err := cfg.DB.GetLoyaltyBalance(customer.ID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get loyalty balance", err)
+		return
+	}
+	entries, err := cfg.DB.GetLoyaltyLedger(customer.ID, loyaltyLedgerLimit)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get loyalty ledger", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{
+		Customer: customer,
+		Balance:  balance,
+		Entries:  entries,
+	})
+}
+
+// respondLoyaltyError responds to the loyalty errors an order can fail with. Returns false for other errors.
+func (cfg *APIConfig) respondLoyaltyError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, database.ErrLoyaltyInsufficient):
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Not enough loyalty points", err)
+	case errors.Is(err, database.ErrLoyaltyRuleInvalid),
+		errors.Is(err, database.ErrLoyaltyNoCustomer),
+		errors.Is(err, database.ErrLoyaltyRewardNotInOrder):
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,386 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	MFA_ISSUER               = "Go POS"
+	MFA_CHALLENGE_EXPIRATION = 10 * time.Minute
+	MFA_RECOVERY_CODE_COUNT  = 10
+)
+
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+type mfaEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// respondMFAChallenge answers a correct password with a challenge token instead of a session.
+// If enrollment is true the user has to set up two-factor before completing the challenge.
+func (cfg *APIConfig) respondMFAChallenge(w http.ResponseWriter, user database.User, deviceName string, enrollment bool) {
+	type response struct {
+		MFARequired        bool   `json:"mfa_required"`
+		EnrollmentRequired bool   `json:"enrollment_required"`
+		MFAToken           string `json:"mfa_token"`
+	}
+
+	token, err := auth.MakeRandomToken()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create two-factor challenge", err)
+		return
+	}
+
+	err = cfg.DB.CreateMFAChallenge(database.CreateMFAChallengeParams{
+		TokenHash:  auth.HashToken(token),
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		Enrollment: enrollment,
+		ExpiresAt:  time.Now().UTC().Add(MFA_CHALLENGE_EXPIRATION),
+	})
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create two-factor challenge", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{
+		MFARequired:        true,
+		EnrollmentRequired: enrollment,
+		MFAToken:           token,
+	})
+}
+
+// HandlerLoginMFA completes a login challenge with a TOTP or recovery code. For enrollment challenges
+// the code confirms the new secret and the response includes the user's recovery codes.
+func (cfg *APIConfig) HandlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tokenHash := auth.HashToken(params.MFAToken)
+	challenge, err := cfg.DB.GetMFAChallenge(tokenHash)
+	if errors.Is(err, database.ErrMFAChallengeInvalid) {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Two-factor challenge is invalid or expired. Log in again", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor challenge", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserById(challenge.UserID)
+	if err != nil || user.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find user", err)
+		return
+	}
+
+	// codes are throttled with the account's passwords, so a known password doesn't allow unlimited guesses
+	attempt := database.CreateLoginAttemptParams{
+		Email:     database.NormalizeEmail(user.Email),
+		UserID:    &user.ID,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	retryAfter, err := cfg.loginRetryAfter(attempt.Email, attempt.IPAddress)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if retryAfter > 0 {
+		attempt.Reason = database.LoginReasonThrottled
+		cfg.recordLoginAttempt(attempt)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		utils.RespondError(w, cfg.Logger, http.StatusTooManyRequests, "Too many login attempts. Try again later", nil)
+		return
+	}
+
+	mfa, err := cfg.DB.GetMFA(challenge.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+
+	var recoveryCodes []string
+	if challenge.Enrollment && mfa.EnabledAt == nil {
+		if mfa.Secret == "" {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+			return
+		}
+		err = cfg.verifyTOTP(challenge.UserID, mfa.Secret, params.Code)
+		if err == nil {
+			recoveryCodes, err = cfg.enableMFA(challenge.UserID)
+			if err != nil {
+				utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't enable two-factor", err)
+				return
+			}
+		}
+	} else {
+		err = cfg.verifySecondFactor(challenge.UserID, mfa, params.Code, params.RecoveryCode)
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		if err := cfg.DB.RecordMFAChallengeFailure(tokenHash); err != nil {
+			cfg.Logger.Errorf("couldn't record two-factor failure: %v", err)
+		}
+		attempt.Reason = database.LoginReasonInvalidMFA
+		cfg.recordLoginAttempt(attempt)
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid two-factor code", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't verify two-factor code", err)
+		return
+	}
+
+	// a concurrent request may have completed the same challenge
+	err = cfg.DB.ConsumeMFAChallenge(tokenHash)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Two-factor challenge is invalid or expired. Log in again", err)
+		return
+	}
+
+	attempt.Success = true
+	attempt.Reason = database.LoginReasonSuccess
+	cfg.recordLoginAttempt(attempt)
+
+	cfg.respondLogin(w, r, user, challenge.DeviceName, recoveryCodes)
+}
+
+// HandlerLoginMFAEnroll starts two-factor setup for a user whose role requires it but who hasn't enrolled yet.
+func (cfg *APIConfig) HandlerLoginMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	challenge, err := cfg.DB.GetMFAChallenge(auth.HashToken(params.MFAToken))
+	if errors.Is(err, database.ErrMFAChallengeInvalid) || (err == nil && !challenge.Enrollment) {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Two-factor challenge is invalid or expired. Log in again", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor challenge", err)
+		return
+	}
+
+	cfg.respondMFAEnroll(w, challenge.UserID)
+}
+
+// HandlerMFAEnroll starts two-factor setup for the logged in user. Confirm it with HandlerMFAConfirm.
+func (cfg *APIConfig) HandlerMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	cfg.respondMFAEnroll(w, claims.UserID)
+}
+
+func (cfg *APIConfig) respondMFAEnroll(w http.ResponseWriter, userID uuid.UUID) {
+	user, err := cfg.DB.GetUserById(userID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	mfa, err := cfg.DB.GetMFA(userID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if mfa.EnabledAt != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Two-factor is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't generate two-factor secret", err)
+		return
+	}
+
+	err = cfg.DB.SetPendingMFASecret(userID, secret)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't save two-factor secret", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, mfaEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, MFA_ISSUER, user.Email),
+	})
+}
+
+// HandlerMFAConfirm enables two-factor once the user proves their authenticator app produces valid codes.
+func (cfg *APIConfig) HandlerMFAConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mfa, err := cfg.DB.GetMFA(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if mfa.EnabledAt != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Two-factor is already enabled", nil)
+		return
+	}
+	if mfa.Secret == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+		return
+	}
+
+	err = cfg.verifyTOTP(claims.UserID, mfa.Secret, params.Code)
+	if errors.Is(err, errInvalidMFACode) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid two-factor code", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't verify two-factor code", err)
+		return
+	}
+
+	codes, err := cfg.enableMFA(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't enable two-factor", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+// HandlerMFADisable turns off two-factor after checking a current code. Not allowed for roles that require it.
+func (cfg *APIConfig) HandlerMFADisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+	if cfg.MFARequiredRoles[claims.Role] {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "Two-factor is required for your role", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mfa, err := cfg.DB.GetMFA(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if mfa.EnabledAt == nil {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Two-factor is not enabled", nil)
+		return
+	}
+
+	err = cfg.verifySecondFactor(claims.UserID, mfa, params.Code, params.RecoveryCode)
+	if errors.Is(err, errInvalidMFACode) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid two-factor code", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't verify two-factor code", err)
+		return
+	}
+
+	err = cfg.DB.DisableMFA(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't disable two-factor", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+func (cfg *APIConfig) verifySecondFactor(userID uuid.UUID, mfa database.MFA, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		err := cfg.DB.UseRecoveryCode(userID, auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)))
+		if errors.Is(err, database.ErrMFACodeUsed) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	return cfg.verifyTOTP(userID, mfa.Secret, code)
+}
+
+// verifyTOTP checks code and records its time step so the same code can't be used twice.
+func (cfg *APIConfig) verifyTOTP(userID uuid.UUID, secret, code string) error {
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return errInvalidMFACode
+	}
+	err := cfg.DB.UseTOTPStep(userID, step)
+	if errors.Is(err, database.ErrMFACodeUsed) {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+// enableMFA turns on two-factor for userID and returns freshly generated recovery codes.
+func (cfg *APIConfig) enableMFA(userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(MFA_RECOVERY_CODE_COUNT)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(auth.NormalizeRecoveryCode(code))
+	}
+
+	err = cfg.DB.EnableMFA(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
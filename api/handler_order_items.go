@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+func (cfg *APIConfig) HandlerOrderItemsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ItemID   string `json:"item_id"`
+		Quantity int    `json:"quantity"`
+		Notes    string `json:"notes"`
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	change, err := cfg.DB.AddOrderItem(database.AddOrderItemParams{
+		OrderID:        orderID,
+		ItemID:         params.ItemID,
+		Quantity:       params.Quantity,
+		Notes:          params.Notes,
+		LockedStatuses: cfg.OrderEditLockedStatuses,
+	})
+	cfg.respondOrderItemChange(w, orderID, change, err, http.StatusCreated)
+}
+
+func (cfg *APIConfig) HandlerOrderItemsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Quantity *int    `json:"quantity"`
+		Notes    *string `json:"notes"`
+	}
+
+	orderID, lineID, ok := cfg.orderItemPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	change, err := cfg.DB.UpdateOrderItem(database.UpdateOrderItemParams{
+		OrderID:        orderID,
+		LineID:         lineID,
+		Quantity:       params.Quantity,
+		Notes:          params.Notes,
+		LockedStatuses: cfg.OrderEditLockedStatuses,
+	})
+	cfg.respondOrderItemChange(w, orderID, change, err, http.StatusOK)
+}
+
+func (cfg *APIConfig) HandlerOrderItemsDelete(w http.ResponseWriter, r *http.Request) {
+	orderID, lineID, ok := cfg.orderItemPath(w, r)
+	if !ok {
+		return
+	}
+
+	change, err := cfg.DB.RemoveOrderItem(database.RemoveOrderItemParams{
+		OrderID:        orderID,
+		LineID:         lineID,
+		LockedStatuses: cfg.OrderEditLockedStatuses,
+	})
+	cfg.respondOrderItemChange(w, orderID, change, err, http.StatusOK)
+}
+
+func (cfg *APIConfig) orderItemPath(w http.ResponseWriter, r *http.Request) (orderID, lineID int, ok bool) {
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return 0, 0, false
+	}
+	lineID, err = strconv.Atoi(r.PathValue("lineID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid line ID", err)
+		return 0, 0, false
+	}
+	return orderID, lineID, true
+}
+
+// respondOrderItemChange responds with the edited order, or the edit's error, and sends the kitchen
+// the change along with the updated order.
+func (cfg *APIConfig) respondOrderItemChange(w http.ResponseWriter, orderID int, change database.OrderItemChange, err error, code int) {
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound), errors.Is(err, database.ErrOrderItemNotFound):
+			utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+		case errors.Is(err, database.ErrMenuItemNotFound), errors.Is(err, database.ErrInvalidQuantity):
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		case errors.Is(err, database.ErrOrderAlreadyPaid), errors.Is(err, database.ErrOrderLocked), errors.Is(err, database.ErrOrderItemRedeemed):
+			utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+		default:
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't edit order", err)
+		}
+		return
+	}
+
+	order, err := cfg.DB.GetOrder(orderID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get order", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, code, order)
+
+	hideCustomerDetails(&order)
+	msg, err := json.Marshal(struct {
+		Type    string                     `json:"type"`
+		Changes []database.OrderItemChange `json:"changes"`
+		Order   database.Order             `json:"order"`
+	}{
+		Type:    "order_items_changed",
+		Changes: []database.OrderItemChange{change},
+		Order:   order,
+	})
+	if err != nil {
+		cfg.Logger.Errorf("couldn't marshal order_items_changed message: %v", err)
+		return
+	}
+	cfg.Hub.Broadcast(msg)
+	cfg.broadcastRefreshOrders()
+	cfg.broadcastStationTickets(orderID, change.Station)
+}
@@ -2,23 +2,121 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/internal/database"
 	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
 )
 
+const defaultOrdersLimit = 100
+
+// HandlerOrdersGet lists orders with their items. By default only open orders released to the kitchen are listed, oldest
+// order_date first. Filters: ?status= (comma separated, or "all"), ?type= (comma separated order
+// types, e.g. dine_in for the front of house queue), ?since= and ?until= (RFC 3339,
+// on order_date), ?email=, ?q= (name, email, order ID, ticket number or item name), ?sort= (order_date, created_at,
+// updated_at or total, prefixed with - for descending), ?cursor= and ?limit=. The response stays a
+// plain array; the next page's cursor is sent in the X-Next-Cursor header.
 func (cfg *APIConfig) HandlerOrdersGet(w http.ResponseWriter, r *http.Request) {
-	ordersJSON, err := cfg.DB.GetOrdersJSON()
+	q := r.URL.Query()
+	filter := database.OrderFilter{
+		Email:  q.Get("email"),
+		Search: q.Get("q"),
+		Cursor: q.Get("cursor"),
+		Limit:  defaultOrdersLimit,
+	}
+
+	switch status := q.Get("status"); status {
+	case "":
+		filter.ExcludeStatuses = []string{database.OrderStatusScheduled, database.OrderStatusCompleted, database.OrderStatusVoided, database.OrderStatusRefunded}
+	case "all":
+	default:
+		filter.Statuses = strings.Split(status, ",")
+	}
+	if types := q.Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+		for _, orderType := range filter.Types {
+			if !database.ValidOrderType(orderType) {
+				utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order type", nil)
+				return
+			}
+		}
+	}
+	if sort := q.Get("sort"); sort != "" {
+		filter.Sort = strings.TrimPrefix(sort, "-")
+		filter.Descending = strings.HasPrefix(sort, "-")
+		if !database.ValidOrderSort(filter.Sort) {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid sort", nil)
+			return
+		}
+	}
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid "+name, err)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		filter.Limit = min(n, 1000)
+	}
+
+	orders, next, err := cfg.DB.GetOrders(filter)
+	if errors.Is(err, database.ErrInvalidOrderCursor) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get orders", err)
 		return
 	}
 
-	utils.Respond(w, cfg.Logger, http.StatusOK, ordersJSON)
+	if !cfg.isStaff(r) {
+		for i := range orders {
+			hideCustomerDetails(&orders[i])
+		}
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, orders)
+}
+
+func (cfg *APIConfig) HandlerOrderGet(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	order, err := cfg.DB.GetOrder(orderID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get order", err)
+		return
+	}
+	if order.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", nil)
+		return
+	}
+
+	if !cfg.isStaff(r) {
+		hideCustomerDetails(&order)
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, order)
 }
 
 func (cfg *APIConfig) HandlerOrdersCreate(w http.ResponseWriter, r *http.Request) {
@@ -32,17 +130,139 @@ func (cfg *APIConfig) HandlerOrdersCreate(w http.ResponseWriter, r *http.Request
 		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order date format", err)
 		return
 	}
+	if !cfg.isStaff(r) {
+		params.InternalNotes = ""
+		params.TabID = nil
+		if !cfg.orderCustomer(w, r, &params) {
+			return
+		}
+	}
+	params.PrepLeadTime = cfg.PrepLeadTime
 
 	id, err := cfg.DB.CreateOrder(params)
+	if errors.Is(err, database.ErrCustomerNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't find customer", err)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidOrderTotal) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order total", err)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidAllergen) || errors.Is(err, database.ErrInvalidOrderType) || errors.Is(err, database.ErrCantSchedule) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if errors.Is(err, database.ErrTabNotFound) || errors.Is(err, database.ErrTabClosed) {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+		return
+	}
 	if err != nil {
-		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create order", err)
+		if !cfg.respondLoyaltyError(w, err) {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create order", err)
+		}
 		return
 	}
 
+	if params.RedeemRuleID != 0 {
+		cfg.audit(r, database.CreateAuditEntryParams{
+			Action:     database.AuditDiscountApplied,
+			EntityType: "order",
+			EntityID:   strconv.Itoa(id),
+			Details:    map[string]int{"loyalty_rule_id": params.RedeemRuleID},
+		})
+	}
+
 	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, map[string]string{"id": strconv.Itoa(id)})
+	order, err := cfg.DB.GetOrder(id)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get order %d: %v", id, err)
+	}
+	if order.Status == database.OrderStatusScheduled {
+		// the kitchen gets it when the scheduler releases it
+		cfg.broadcastOrder("order_scheduled", id)
+		cfg.Scheduler.Wake()
+	} else {
+		cfg.broadcastOrder("new_order", id)
+		cfg.broadcastStationTickets(id)
+	}
 	cfg.broadcastRefreshOrders()
+	if params.TabID != nil {
+		cfg.broadcastTab(*params.TabID)
+	}
+}
+
+// HandlerOrderNotesUpdate edits an order's notes, allergies and internal notes. Fields left out of
+// the body are kept. Every edit is audited with the values before and after.
+func (cfg *APIConfig) HandlerOrderNotesUpdate(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	params := database.UpdateOrderNotesParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	params.ID = orderID
+
+	prev, err := cfg.DB.UpdateOrderNotes(params)
+	if errors.Is(err, database.ErrOrderNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", err)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidAllergen) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't update order notes", err)
+		return
+	}
+
+	order, err := cfg.DB.GetOrder(orderID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get order", err)
+		return
+	}
+
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditOrderNotesEdited,
+		EntityType: "order",
+		EntityID:   strconv.Itoa(orderID),
+		Details:    map[string]database.OrderNotes{"before": prev, "after": order.OrderNotes},
+	})
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, order)
+	cfg.broadcastOrder("order_notes", orderID)
 }
 
+// HandlerOrderTicket returns the order as a plain text kitchen ticket for printing.
+func (cfg *APIConfig) HandlerOrderTicket(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(r.PathValue("orderID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid order ID", err)
+		return
+	}
+
+	order, err := cfg.DB.GetOrder(orderID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get order", err)
+		return
+	}
+	if order.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(orderTicket(order)))
+}
+
+// HandlerOrdersUpdate sets an order's status. Voiding or refunding it reverses its loyalty points and
+// gift card payments, so only managers may do that.
 func (cfg *APIConfig) HandlerOrdersUpdate(w http.ResponseWriter, r *http.Request) {
 	params := database.UpdateOrderParams{}
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
@@ -50,14 +270,113 @@ func (cfg *APIConfig) HandlerOrdersUpdate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	claims, _ := claimsFromContext(r.Context())
+	if (params.Status == database.OrderStatusVoided || params.Status == database.OrderStatusRefunded) && claims.Role != auth.RoleManager {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "You are not authorized to void or refund orders", nil)
+		return
+	}
+
 	err := cfg.DB.UpdateOrder(params)
+	if errors.Is(err, database.ErrOrderNotFound) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find order", err)
+		return
+	}
+	if errors.Is(err, database.ErrCantSchedule) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if errors.Is(err, database.ErrOrderReversed) {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Order was already voided or refunded", err)
+		return
+	}
 	if err != nil {
 		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't update order", err)
 		return
 	}
 
+	switch params.Status {
+	case database.OrderStatusVoided:
+		cfg.audit(r, database.CreateAuditEntryParams{Action: database.AuditOrderVoided, EntityType: "order", EntityID: strconv.Itoa(params.ID)})
+	case database.OrderStatusRefunded:
+		cfg.audit(r, database.CreateAuditEntryParams{Action: database.AuditOrderRefunded, EntityType: "order", EntityID: strconv.Itoa(params.ID)})
+	}
+
 	utils.RespondJSON(w, cfg.Logger, http.StatusOK, map[string]string{"message": fmt.Sprintf("Order %d updated successfully", params.ID)})
 	cfg.broadcastRefreshOrders()
+	cfg.broadcastStationTickets(params.ID)
+}
+
+// broadcastOrder sends an order to the kitchen screens, with its notes and allergies. The websocket
+// isn't authenticated, so internal notes are left out.
+func (cfg *APIConfig) broadcastOrder(eventType string, orderID int) {
+	order, err := cfg.DB.GetOrder(orderID)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get order %d for %s event: %v", orderID, eventType, err)
+		return
+	}
+	// anyone can listen on the websocket
+	hideCustomerDetails(&order)
+
+	msg, err := json.Marshal(struct {
+		Type  string         `json:"type"`
+		Order database.Order `json:"order"`
+	}{
+		Type:  eventType,
+		Order: order,
+	})
+	if err != nil {
+		cfg.Logger.Errorf("couldn't marshal %s message: %v", eventType, err)
+		return
+	}
+	cfg.Hub.Broadcast(msg)
+}
+
+// orderCustomer sets who a customer's own order is for. Customers logged in get the order linked to
+// them; anyone else's order is anonymous, not matched to a customer by its email and can't redeem
+// loyalty rewards.
+func (cfg *APIConfig) orderCustomer(w http.ResponseWriter, r *http.Request, params *database.CreateOrderParams) bool {
+	params.CustomerID = nil
+	params.Anonymous = true
+	redeemRuleID := params.RedeemRuleID
+	params.RedeemRuleID = 0
+
+	claims, ok := cfg.auditActor(r)
+	if !ok || claims.IsAPIKey() || claims.Role != auth.RoleCustomer {
+		return true
+	}
+	customer, err := cfg.DB.GetCustomerByUser(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get customer", err)
+		return false
+	}
+	if customer.ID != uuid.Nil {
+		params.CustomerID = &customer.ID
+		params.Anonymous = false
+		params.RedeemRuleID = redeemRuleID
+	}
+	return true
+}
+
+// isStaff reports whether the request was made by a store or manager account, who can see internal
+// notes. Works on public routes too.
+func (cfg *APIConfig) isStaff(r *http.Request) bool {
+	claims, ok := cfg.auditActor(r)
+	return ok && !claims.IsAPIKey() && auth.IsManager(claims.Role)
+}
+
+// hideCustomerDetails takes what only staff may see off an order: its internal notes and the
+// customer's contact details. The name stays so orders can be called out.
+func hideCustomerDetails(order *database.Order) {
+	order.InternalNotes = ""
+	order.CustomerID = nil
+	order.ForEmail = ""
+	order.DeliveryAddress = ""
+}
+
+func hideInternalNotes(orders []database.Order) {
+	for i := range orders {
+		orders[i].InternalNotes = ""
+	}
 }
 
 func (cfg *APIConfig) broadcastRefreshOrders() {
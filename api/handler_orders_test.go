@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreCantVoidOrRefund(t *testing.T) {
+	cfg := &APIConfig{Logger: log.New(os.Stdout)}
+	claims := auth.AccessClaims{UserID: uuid.New(), Role: auth.RoleStore, SessionID: uuid.New()}
+
+	for _, status := range []string{"voided", "refunded"} {
+		req := httptest.NewRequest(http.MethodPut, "/api/orders", strings.NewReader(`{"id": 1, "status": "`+status+`"}`))
+		req = req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+		rr := httptest.NewRecorder()
+		cfg.HandlerOrdersUpdate(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "A store login shouldn't be able to set an order to %s", status)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/orders/1/refund", strings.NewReader(`{}`))
+	req.SetPathValue("orderID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+	rr := httptest.NewRecorder()
+	cfg.HandlerOrdersRefund(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
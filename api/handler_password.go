@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/internal/mailer"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	PASSWORD_RESET_EXPIRATION     = time.Hour
+	EMAIL_VERIFICATION_EXPIRATION = 48 * time.Hour
+	MIN_PASSWORD_LENGTH           = 8
+)
+
+// HandlerPasswordForgot emails a password reset link. It responds the same way whether or not the
+// email belongs to an account.
+func (cfg *APIConfig) HandlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+
+	if user.ID != uuid.Nil {
+		err = cfg.sendUserToken(user, database.TokenPurposePasswordReset, PASSWORD_RESET_EXPIRATION, "/reset-password",
+			"Reset your password",
+			"Someone asked to reset the password for your account. If this was you, open the link below within an hour:\n\n%s\n\nIf it wasn't you, you can ignore this email.")
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create reset token", err)
+			return
+		}
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+// HandlerPasswordReset sets a new password using a token from HandlerPasswordForgot and signs the user out everywhere.
+func (cfg *APIConfig) HandlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Token is required", nil)
+		return
+	}
+	if len(params.Password) < MIN_PASSWORD_LENGTH {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", MIN_PASSWORD_LENGTH), nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	_, sessions, err := cfg.DB.ResetPassword(auth.HashToken(params.Token), hashedPassword)
+	if errors.Is(err, database.ErrUserTokenInvalid) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Reset link is invalid or has expired", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	if cfg.Denylist != nil {
+		for _, id := range sessions {
+			cfg.Denylist.Add(id, JWT_EXPIRATION)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *APIConfig) HandlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Token is required", nil)
+		return
+	}
+
+	_, err := cfg.DB.VerifyEmail(auth.HashToken(params.Token))
+	if errors.Is(err, database.ErrUserTokenInvalid) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Verification link is invalid or has expired", err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerEmailVerifyResend sends a fresh verification link to the logged in user.
+func (cfg *APIConfig) HandlerEmailVerifyResend(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	user, err := cfg.DB.GetUserById(claims.UserID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create verification token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *APIConfig) sendVerificationEmail(user database.User) error {
+	return cfg.sendUserToken(user, database.TokenPurposeEmailVerification, EMAIL_VERIFICATION_EXPIRATION, "/verify-email",
+		"Verify your email",
+		"Confirm this is your email address by opening the link below:\n\n%s")
+}
+
+// sendUserToken stores a new single-use token for user and emails it as a link to path on the frontend.
+// body must contain a single %s for the link. Delivery happens in the background.
+func (cfg *APIConfig) sendUserToken(user database.User, purpose string, ttl time.Duration, path, subject, body string) error {
+	token, err := auth.MakeRandomToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.CreateUserToken(database.CreateUserTokenParams{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s%s?token=%s", cfg.FrontendOrigin, path, url.QueryEscape(token))
+	go cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, link),
+	})
+	return nil
+}
+
+func (cfg *APIConfig) sendMail(msg mailer.Message) {
+	if cfg.Mailer == nil {
+		cfg.Logger.Warnf("No mailer configured, dropping %q to %s", msg.Subject, msg.To)
+		return
+	}
+	if err := cfg.Mailer.Send(msg); err != nil {
+		cfg.Logger.Errorf("couldn't send %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
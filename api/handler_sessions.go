@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
+)
+
+// HandlerSessionsGet lists the caller's active sessions, or every user's sessions for managers.
+func (cfg *APIConfig) HandlerSessionsGet(w http.ResponseWriter, r *http.Request) {
+	type SessionResponse struct {
+		database.Session
+		Current bool `json:"current"`
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	var sessions []database.Session
+	var err error
+	if auth.IsManager(claims.Role) {
+		sessions, err = cfg.DB.GetSessions()
+	} else {
+		sessions, err = cfg.DB.GetSessionsByUser(claims.UserID)
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	respSessions := make([]SessionResponse, len(sessions))
+	for i, s := range sessions {
+		respSessions[i] = SessionResponse{
+			Session: s,
+			Current: s.ID == claims.SessionID,
+		}
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, respSessions)
+}
+
+// HandlerSessionsDelete signs out a session. Users can end their own sessions, managers any session.
+func (cfg *APIConfig) HandlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find session", ErrAuthorizeUser)
+		return
+	}
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	session, err := cfg.DB.GetSession(sessionID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get session", err)
+		return
+	}
+	// don't reveal other users' sessions to non-managers
+	if session.ID == uuid.Nil || (session.UserID != claims.UserID && !auth.IsManager(claims.Role)) {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find session", nil)
+		return
+	}
+
+	err = cfg.revokeSession(session.ID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
 	"github.com/gorilla/websocket"
 )
 
@@ -15,20 +17,21 @@ var upgrader = websocket.Upgrader{
 
 // Hub maintains active clients and broadcasts messages
 type Hub struct {
-	clients map[*websocket.Conn]bool
+	clients map[*websocket.Conn]string // kitchen station the client shows, or "" for every event
 	mu      sync.Mutex
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		clients: make(map[*websocket.Conn]bool),
+		clients: make(map[*websocket.Conn]string),
 	}
 }
 
-func (h *Hub) AddClient(conn *websocket.Conn) {
+// AddClient adds a client. Clients for a kitchen station only get that station's events.
+func (h *Hub) AddClient(conn *websocket.Conn, station string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.clients[conn] = true
+	h.clients[conn] = station
 }
 
 func (h *Hub) RemoveClient(conn *websocket.Conn) {
@@ -38,11 +41,23 @@ func (h *Hub) RemoveClient(conn *websocket.Conn) {
 	conn.Close()
 }
 
-// Broadcast message to all clients
+// Broadcast message to all clients, except those for a kitchen station
 func (h *Hub) Broadcast(message []byte) {
+	h.send(message, func(station string) bool { return station == "" })
+}
+
+// BroadcastStation sends a kitchen station's message to its clients and those getting every event
+func (h *Hub) BroadcastStation(station string, message []byte) {
+	h.send(message, func(s string) bool { return s == "" || s == station })
+}
+
+func (h *Hub) send(message []byte, to func(station string) bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	for client := range h.clients {
+	for client, station := range h.clients {
+		if !to(station) {
+			continue
+		}
 		err := client.WriteMessage(websocket.TextMessage, message)
 		if err != nil {
 			log.Printf("Error sending message to client: %v", err)
@@ -52,7 +67,15 @@ func (h *Hub) Broadcast(message []byte) {
 	}
 }
 
+// WsHandler streams events to a client. Kitchen display screens connect with ?station= to only get
+// their station's tickets.
 func (cfg *APIConfig) WsHandler(w http.ResponseWriter, r *http.Request) {
+	station := r.URL.Query().Get("station")
+	if station != "" && !database.ValidStation(station) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid station", nil)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		cfg.Logger.Errorf("Upgrade error: %v", err)
@@ -64,7 +87,7 @@ func (cfg *APIConfig) WsHandler(w http.ResponseWriter, r *http.Request) {
 		cfg.Logger.Infof("Client disconnected: IP=%s", r.RemoteAddr)
 	}()
 
-	cfg.Hub.AddClient(conn)
+	cfg.Hub.AddClient(conn, station)
 	cfg.Logger.Infof("Client connected: IP=%s", r.RemoteAddr)
 
 	for {
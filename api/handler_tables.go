@@ -0,0 +1,351 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+func (cfg *APIConfig) HandlerSectionsGet(w http.ResponseWriter, r *http.Request) {
+	sections, err := cfg.DB.GetSections()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get sections", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, sections)
+}
+
+func (cfg *APIConfig) HandlerSectionsCreate(w http.ResponseWriter, r *http.Request) {
+	params := database.CreateSectionParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	section, err := cfg.DB.CreateSection(params)
+	if errors.Is(err, database.ErrInvalidTable) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create section", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, section)
+}
+
+func (cfg *APIConfig) HandlerSectionsDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("sectionID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid section ID", err)
+		return
+	}
+
+	if err := cfg.DB.DeleteSection(id); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't delete section", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerTablesGet returns the floor plan: every table with its status and open tab.
+func (cfg *APIConfig) HandlerTablesGet(w http.ResponseWriter, r *http.Request) {
+	tables, err := cfg.DB.GetTables()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tables", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tables)
+}
+
+func (cfg *APIConfig) HandlerTableGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	table, err := cfg.DB.GetTable(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get table", err)
+		return
+	}
+	if table.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find table", nil)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, table)
+}
+
+func (cfg *APIConfig) HandlerTablesCreate(w http.ResponseWriter, r *http.Request) {
+	params := database.CreateTableParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	table, err := cfg.DB.CreateTable(params)
+	if err != nil {
+		cfg.respondTableError(w, err, "Couldn't create table")
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, table)
+	cfg.broadcastTable(table.ID)
+}
+
+func (cfg *APIConfig) HandlerTablesUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	params := database.CreateTableParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	table, err := cfg.DB.UpdateTable(database.UpdateTableParams{ID: id, CreateTableParams: params})
+	if err != nil {
+		cfg.respondTableError(w, err, "Couldn't update table")
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, table)
+	cfg.broadcastTable(table.ID)
+}
+
+func (cfg *APIConfig) HandlerTablesDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.DeleteTable(id); err != nil {
+		cfg.respondTableError(w, err, "Couldn't delete table")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	cfg.broadcastTable(id)
+}
+
+// HandlerTableSeat opens a tab on a free table.
+func (cfg *APIConfig) HandlerTableSeat(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string `json:"name"`
+		Covers int    `json:"covers"`
+	}
+
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	tab, err := cfg.DB.SeatTable(id, params.Name, params.Covers)
+	if err != nil {
+		cfg.respondTableError(w, err, "Couldn't seat table")
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, tab)
+	cfg.broadcastTable(id)
+}
+
+// HandlerTableStatusUpdate sets a table's status by hand, e.g. paying when the bill is requested or
+// free once it has been bussed.
+func (cfg *APIConfig) HandlerTableStatusUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Status string `json:"status"`
+	}
+
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := cfg.DB.SetTableStatus(id, params.Status); err != nil {
+		cfg.respondTableError(w, err, "Couldn't update table status")
+		return
+	}
+	cfg.respondTables(w, id)
+}
+
+// HandlerTableMove moves a table's open tab to a free table.
+func (cfg *APIConfig) HandlerTableMove(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ToTableID int `json:"to_table_id"`
+	}
+
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := cfg.DB.MoveTab(id, params.ToTableID); err != nil {
+		cfg.respondTableError(w, err, "Couldn't move tab")
+		return
+	}
+	cfg.respondTables(w, id, params.ToTableID)
+}
+
+// HandlerTableMerge merges a table's open tab into the open tab on another table.
+func (cfg *APIConfig) HandlerTableMerge(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IntoTableID int `json:"into_table_id"`
+	}
+
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	from, err := cfg.DB.GetTable(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get table", err)
+		return
+	}
+
+	if err := cfg.DB.MergeTabs(id, params.IntoTableID); err != nil {
+		cfg.respondTableError(w, err, "Couldn't merge tabs")
+		return
+	}
+	// the merged tab is paid with the one it was merged into, so its card hold is released
+	if from.Tab != nil {
+		if tab, err := cfg.DB.GetTab(from.Tab.ID); err != nil {
+			cfg.Logger.Errorf("couldn't get merged tab %d: %v", from.Tab.ID, err)
+		} else if tab.Card != nil {
+			cfg.voidCard(tab.Card.AuthID)
+		}
+	}
+	cfg.respondTables(w, id, params.IntoTableID)
+	cfg.broadcastRefreshOrders()
+}
+
+// HandlerTableClear closes a table's tab once the guests have left and paid. A card hold on the tab
+// is released.
+func (cfg *APIConfig) HandlerTableClear(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.tablePath(w, r)
+	if !ok {
+		return
+	}
+
+	table, err := cfg.DB.GetTable(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get table", err)
+		return
+	}
+	if err := cfg.DB.ClearTable(id); err != nil {
+		cfg.respondTableError(w, err, "Couldn't clear table")
+		return
+	}
+	if table.Tab != nil && table.Tab.Card != nil {
+		cfg.voidCard(table.Tab.Card.AuthID)
+	}
+	cfg.respondTables(w, id)
+}
+
+func (cfg *APIConfig) tablePath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("tableID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid table ID", err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (cfg *APIConfig) respondTableError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, database.ErrTableNotFound):
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, database.ErrInvalidTable), errors.Is(err, database.ErrInvalidTableStatus):
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, database.ErrTableOccupied), errors.Is(err, database.ErrTableHasNoTab), errors.Is(err, database.ErrTabHasBalance):
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+	default:
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, msg, err)
+	}
+}
+
+// respondTables responds with the tables after a change and broadcasts their new status.
+func (cfg *APIConfig) respondTables(w http.ResponseWriter, ids ...int) {
+	tables := make([]database.Table, 0, len(ids))
+	for _, id := range ids {
+		table, err := cfg.DB.GetTable(id)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get table", err)
+			return
+		}
+		tables = append(tables, table)
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tables)
+	for _, table := range tables {
+		cfg.sendTable(table)
+	}
+}
+
+// broadcastTable sends a table's current status to all clients. Deleted tables are sent with only
+// their ID and an empty status.
+func (cfg *APIConfig) broadcastTable(id int) {
+	table, err := cfg.DB.GetTable(id)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get table %d for table_status event: %v", id, err)
+		return
+	}
+	if table.ID == 0 {
+		table.ID = id
+	}
+	cfg.sendTable(table)
+}
+
+// broadcastTab sends the status of the table a tab is on, if any.
+func (cfg *APIConfig) broadcastTab(tabID int) {
+	tab, err := cfg.DB.GetTab(tabID)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get tab %d: %v", tabID, err)
+		return
+	}
+	if tab.TableID != nil {
+		cfg.broadcastTable(*tab.TableID)
+	}
+}
+
+func (cfg *APIConfig) sendTable(table database.Table) {
+	msg, err := json.Marshal(struct {
+		Type  string         `json:"type"`
+		Table database.Table `json:"table"`
+	}{
+		Type:  "table_status",
+		Table: table,
+	})
+	if err != nil {
+		cfg.Logger.Errorf("couldn't marshal table_status message: %v", err)
+		return
+	}
+	cfg.Hub.Broadcast(msg)
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/internal/payments"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+// HandlerTabsGet returns every open tab with its running balance.
+func (cfg *APIConfig) HandlerTabsGet(w http.ResponseWriter, r *http.Request) {
+	tabs, err := cfg.DB.GetOpenTabs()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tabs", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tabs)
+}
+
+// HandlerTabsCreate opens a tab that isn't on a table, e.g. at the bar. Orders are added to it by
+// creating them with its tab_id.
+func (cfg *APIConfig) HandlerTabsCreate(w http.ResponseWriter, r *http.Request) {
+	params := database.OpenTabParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	tab, err := cfg.DB.OpenTab(params)
+	if errors.Is(err, database.ErrInvalidTab) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't open tab", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, tab)
+}
+
+func (cfg *APIConfig) HandlerTabGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := cfg.tabPath(w, r)
+	if !ok {
+		return
+	}
+
+	tab, err := cfg.DB.GetTabSummary(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tab", err)
+		return
+	}
+	if tab.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find tab", nil)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tab)
+}
+
+// HandlerTabCard places a hold on the customer's card through the payments gateway and keeps it on
+// the tab. The card is tokenized by the terminal; only the token and last four digits are sent. A
+// card already on the tab is released.
+func (cfg *APIConfig) HandlerTabCard(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		Last4       string `json:"last4"`
+		AmountCents int    `json:"amount_cents"`
+	}
+
+	id, ok := cfg.tabPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	tab, err := cfg.DB.GetTab(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tab", err)
+		return
+	}
+	if tab.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find tab", nil)
+		return
+	}
+	if tab.Status != database.TabOpen {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, database.ErrTabClosed.Error(), nil)
+		return
+	}
+
+	auth, err := cfg.Payments.Authorize(params.Token, params.AmountCents)
+	if err != nil {
+		cfg.respondPaymentsError(w, err)
+		return
+	}
+
+	prev, err := cfg.DB.SetTabCard(id, database.TabCard{AuthID: auth.ID, AmountCents: auth.AmountCents, Last4: params.Last4})
+	if err != nil {
+		cfg.voidCard(auth.ID)
+		cfg.respondTabError(w, err, "Couldn't save card")
+		return
+	}
+	if prev != nil {
+		cfg.voidCard(prev.AuthID)
+	}
+
+	tab, err = cfg.DB.GetTab(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tab", err)
+		return
+	}
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, tab)
+}
+
+// HandlerTabClose settles a tab's balance with a single payment and closes it. Card payments capture
+// the card held on the tab, or are run on the terminal with its reference if there is none. Paying
+// any other way releases the held card.
+func (cfg *APIConfig) HandlerTabClose(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tender    string `json:"tender"`
+		Reference string `json:"reference"`
+	}
+
+	id, ok := cfg.tabPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	tab, err := cfg.DB.GetTabSummary(id)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tab", err)
+		return
+	}
+	if tab.ID == 0 {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find tab", nil)
+		return
+	}
+	if tab.Status != database.TabOpen {
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, database.ErrTabClosed.Error(), nil)
+		return
+	}
+
+	closeParams := database.CloseTabParams{
+		TabID:        id,
+		Tender:       params.Tender,
+		BalanceCents: tab.BalanceCents,
+		Reference:    params.Reference,
+	}
+	// the card is charged inside CloseTab, once the tab is sure to close
+	var captured string
+	var chargeErr error
+	if params.Tender == database.TenderCard && tab.Card != nil {
+		closeParams.Charge = func(balanceCents int) (string, error) {
+			captured, chargeErr = cfg.Payments.Capture(tab.Card.AuthID, balanceCents)
+			return captured, chargeErr
+		}
+	}
+
+	closed, err := cfg.DB.CloseTab(closeParams)
+	if chargeErr != nil {
+		if errors.Is(chargeErr, payments.ErrUnknownAuth) {
+			// the hold expired or was lost, so staff need to take the card again
+			if err := cfg.DB.ClearTabCard(id, tab.Card.AuthID); err != nil {
+				cfg.Logger.Errorf("couldn't remove lost card hold from tab %d: %v", id, err)
+			}
+			cfg.broadcastTab(id)
+		}
+		cfg.respondPaymentsError(w, chargeErr)
+		return
+	}
+	if err != nil {
+		if captured != "" {
+			cfg.Logger.Errorf("captured %d cents on tab %d (%s) but couldn't close it", tab.BalanceCents, id, captured)
+		}
+		cfg.respondTabError(w, err, "Couldn't close tab")
+		return
+	}
+	if captured != "" {
+		params.Reference = captured
+	} else if tab.Card != nil {
+		cfg.voidCard(tab.Card.AuthID)
+	}
+
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditTabClosed,
+		EntityType: "tab",
+		EntityID:   strconv.Itoa(id),
+		Details: map[string]interface{}{
+			"tender":        params.Tender,
+			"balance_cents": tab.BalanceCents,
+			"reference":     params.Reference,
+		},
+	})
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, closed)
+	cfg.broadcastTab(id)
+	cfg.broadcastRefreshOrders()
+}
+
+// HandlerOpenTabsReport lists the tabs still open, for closing up. Tabs opened on an earlier business
+// day are flagged as overdue and logged as a warning.
+func (cfg *APIConfig) HandlerOpenTabsReport(w http.ResponseWriter, r *http.Request) {
+	type openTab struct {
+		database.TabSummary
+		Overdue bool `json:"overdue"`
+	}
+	type response struct {
+		BusinessDay  string    `json:"business_day"`
+		Tabs         []openTab `json:"tabs"`
+		BalanceCents int       `json:"balance_cents"`
+		OverdueCount int       `json:"overdue_count"`
+	}
+
+	tabs, err := cfg.DB.GetOpenTabs()
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get tabs", err)
+		return
+	}
+
+	res := response{BusinessDay: cfg.DB.Today(), Tabs: make([]openTab, 0, len(tabs))}
+	for _, tab := range tabs {
+		overdue := tab.BusinessDay < res.BusinessDay
+		if overdue {
+			res.OverdueCount++
+		}
+		res.BalanceCents += tab.BalanceCents
+		res.Tabs = append(res.Tabs, openTab{TabSummary: tab, Overdue: overdue})
+	}
+	if res.OverdueCount > 0 {
+		cfg.Logger.Warnf("%d tabs were left open from before %s", res.OverdueCount, res.BusinessDay)
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, res)
+}
+
+func (cfg *APIConfig) tabPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("tabID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid tab ID", err)
+		return 0, false
+	}
+	return id, true
+}
+
+func (cfg *APIConfig) respondTabError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, database.ErrTabNotFound):
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, database.ErrInvalidTender):
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, database.ErrTabClosed), errors.Is(err, database.ErrTabBalanceChanged):
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, err.Error(), err)
+	default:
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, msg, err)
+	}
+}
+
+func (cfg *APIConfig) respondPaymentsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, payments.ErrMissingCardData), errors.Is(err, payments.ErrInvalidAmount):
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, payments.ErrDeclined), errors.Is(err, payments.ErrOverAuthorized):
+		utils.RespondError(w, cfg.Logger, http.StatusPaymentRequired, err.Error(), err)
+	case errors.Is(err, payments.ErrUnknownAuth):
+		utils.RespondError(w, cfg.Logger, http.StatusConflict, "Card hold has expired, take the card again", err)
+	default:
+		utils.RespondError(w, cfg.Logger, http.StatusBadGateway, "Couldn't reach payments gateway", err)
+	}
+}
+
+// voidCard releases a card hold that is no longer needed. Failures are logged, the hold expires on
+// its own.
+func (cfg *APIConfig) voidCard(authID string) {
+	if err := cfg.Payments.Void(authID); err != nil {
+		cfg.Logger.Errorf("couldn't release card hold %s: %v", authID, err)
+	}
+}
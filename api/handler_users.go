@@ -7,6 +7,7 @@ import (
 	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/internal/database"
 	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
 )
 
 func (cfg *APIConfig) HandlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -48,5 +49,81 @@ func (cfg *APIConfig) HandlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't send verification email to %s: %v", user.Email, err)
+	}
+
 	utils.RespondJSON(w, cfg.Logger, http.StatusCreated, user)
 }
+
+// HandlerUsersRoleUpdate changes a user's role and ends their sessions so new tokens carry it.
+// Only managers can grant or take away the manager role.
+func (cfg *APIConfig) HandlerUsersRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !auth.ValidRole(params.Role) {
+		utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	user, err := cfg.DB.GetUserById(userID)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+
+	if (user.Role == auth.RoleManager || params.Role == auth.RoleManager) && claims.Role != auth.RoleManager {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "Only managers can change the manager role", nil)
+		return
+	}
+
+	if user.Role == params.Role {
+		utils.RespondJSON(w, cfg.Logger, http.StatusOK, user)
+		return
+	}
+
+	if err := cfg.DB.UpdateUserRole(user.ID, params.Role); err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:     database.AuditRoleChanged,
+		EntityType: "user",
+		EntityID:   user.ID.String(),
+		Details:    map[string]string{"old_role": user.Role, "new_role": params.Role},
+	})
+
+	sessions, err := cfg.DB.GetSessionsByUser(user.ID)
+	if err != nil {
+		cfg.Logger.Errorf("couldn't get sessions for user %s after role change: %v", user.ID, err)
+	}
+	for _, session := range sessions {
+		if err := cfg.revokeSession(session.ID); err != nil {
+			cfg.Logger.Errorf("couldn't revoke session %s after role change: %v", session.ID, err)
+		}
+	}
+
+	user.Role = params.Role
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, user)
+}
@@ -1,20 +1,32 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/utils"
+	"github.com/google/uuid"
 )
 
-// type contextKey string
+type contextKey string
 
-// const (
-// userIDKey contextKey = "userID"
-// roleKey   contextKey = "role"
-// )
+const (
+	claimsKey        contextKey = "claims"
+	requiredScopeKey contextKey = "required_scope"
+)
 
-func (cfg *APIConfig) StoreAuthMiddleware(next http.Handler) http.Handler {
+// RequireScope declares the scope an API key needs to call next. Routes that don't declare one
+// reject API keys. User tokens are unaffected.
+func (cfg *APIConfig) RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requiredScopeKey, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AuthMiddleware requires a valid access token for a live session and stores its claims in the request context.
+func (cfg *APIConfig) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, err := auth.GetBearerToken(r, auth.AccessToken)
 		if err != nil {
@@ -22,23 +34,109 @@ func (cfg *APIConfig) StoreAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		userID, role, err := auth.ValidateJWT(token, cfg.JWTSecret)
-		if err != nil {
-			utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't validate token for store access", err)
-			return
+		var claims auth.AccessClaims
+		if auth.IsAPIKey(token) {
+			var ok bool
+			claims, ok = cfg.authenticateAPIKey(w, r, token)
+			if !ok {
+				return
+			}
+		} else {
+			claims, err = auth.ValidateJWTClaims(token, cfg.JWTKeys)
+			if err != nil {
+				utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't validate token", err)
+				return
+			}
+
+			if cfg.Denylist != nil && cfg.Denylist.Contains(claims.SessionID) {
+				utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Session has been revoked", nil)
+				return
+			}
+			if err := cfg.DB.TouchSession(claims.SessionID); err != nil {
+				cfg.Logger.Errorf("couldn't record use of session %s: %v", claims.SessionID, err)
+			}
 		}
 
-		_, err = cfg.DB.GetUserById(userID)
+		user, err := cfg.DB.GetUserById(claims.UserID)
 		if err != nil {
 			utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find user", err)
 			return
 		}
+		if user.ID == uuid.Nil {
+			utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Couldn't find user", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		if role != "store" {
+func (cfg *APIConfig) StoreAuthMiddleware(next http.Handler) http.Handler {
+	return cfg.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromContext(r.Context())
+		// API keys already passed their scope check in AuthMiddleware
+		if !claims.IsAPIKey() && claims.Role != auth.RoleStore {
 			utils.RespondError(w, cfg.Logger, http.StatusForbidden, "You are not authorized to access this resource", nil)
 			return
 		}
 
 		next.ServeHTTP(w, r)
-	})
+	}))
+}
+
+// ManagerAuthMiddleware only lets through users whose role can manage other users.
+func (cfg *APIConfig) ManagerAuthMiddleware(next http.Handler) http.Handler {
+	return cfg.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromContext(r.Context())
+		if !claims.IsAPIKey() && !auth.IsManager(claims.Role) {
+			utils.RespondError(w, cfg.Logger, http.StatusForbidden, "You are not authorized to access this resource", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// authenticateAPIKey checks key against its IP allowlist and the scope required by the route.
+// It responds with an error and returns false if the key can't be used.
+func (cfg *APIConfig) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string) (auth.AccessClaims, bool) {
+	apiKey, err := cfg.DB.GetActiveAPIKeyByHash(auth.HashToken(key))
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't look up API key", err)
+		return auth.AccessClaims{}, false
+	}
+	if apiKey.ID == uuid.Nil {
+		utils.RespondError(w, cfg.Logger, http.StatusUnauthorized, "Invalid API key", nil)
+		return auth.AccessClaims{}, false
+	}
+
+	ip := clientIP(r)
+	if !auth.IPAllowed(apiKey.IPAllowlist, ip) {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "API key isn't allowed from this IP", nil)
+		return auth.AccessClaims{}, false
+	}
+
+	claims := auth.AccessClaims{
+		UserID:   apiKey.UserID,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}
+	scope, _ := r.Context().Value(requiredScopeKey).(string)
+	if scope == "" || !claims.HasScope(scope) {
+		utils.RespondError(w, cfg.Logger, http.StatusForbidden, "API key doesn't have access to this resource", nil)
+		return auth.AccessClaims{}, false
+	}
+
+	if err := cfg.DB.TouchAPIKey(apiKey.ID, ip); err != nil {
+		cfg.Logger.Errorf("couldn't record use of api key %s: %v", apiKey.Prefix, err)
+	}
+
+	return claims, true
+}
+
+// claimsFromContext returns the access token claims stored by AuthMiddleware.
+func claimsFromContext(ctx context.Context) (auth.AccessClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(auth.AccessClaims)
+	return claims, ok
 }
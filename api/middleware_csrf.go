@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+// CSRFMiddleware requires the X-CSRF-Token header to match the csrf_token cookie on state-changing
+// requests that carry auth cookies. Requests with an Authorization header are exempt since
+// browsers never attach it cross-site on their own.
+func (cfg *APIConfig) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requiresCSRFCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(string(auth.CSRFToken))
+		header := r.Header.Get(auth.CSRFHeader)
+		if err != nil || cookie.Value == "" || header == "" ||
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			utils.RespondError(w, cfg.Logger, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requiresCSRFCheck(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	if auth.HasBearerHeader(r) {
+		return false
+	}
+
+	for _, name := range []auth.TokenType{auth.AccessToken, auth.RefreshToken} {
+		if _, err := r.Cookie(string(name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlerCSRF returns the CSRF token for the current cookie session, issuing one if the browser
+// doesn't have it yet. The frontend can't read the cookie itself when it's on another origin.
+func (cfg *APIConfig) HandlerCSRF(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+
+	token, err := cfg.ensureCSRFCookie(w, r)
+	if err != nil {
+		utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't create CSRF token", err)
+		return
+	}
+
+	utils.RespondJSON(w, cfg.Logger, http.StatusOK, response{CSRFToken: token})
+}
+
+// setCSRFCookie issues a new CSRF token lasting as long as the refresh token.
+func (cfg *APIConfig) setCSRFCookie(w http.ResponseWriter) (string, error) {
+	token, err := auth.MakeRandomToken()
+	if err != nil {
+		return "", err
+	}
+	auth.SetTokenCookie(w, token, auth.CSRFToken, "/", REFRESH_TOKEN_EXPIRATION, cfg.CookieSameSite, cfg.CookieSecure)
+	return token, nil
+}
+
+// ensureCSRFCookie returns the request's CSRF token, or issues one if it has none.
+func (cfg *APIConfig) ensureCSRFCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(string(auth.CSRFToken)); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	return cfg.setCSRFCookie(w)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/chaeanthony/go-pos/internal/auth"
+	"github.com/charmbracelet/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	cfg := &APIConfig{Logger: log.New(os.Stdout)}
+	handler := cfg.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		cookies    map[auth.TokenType]string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "GET with cookie is allowed",
+			method:     http.MethodGet,
+			cookies:    map[auth.TokenType]string{auth.AccessToken: "jwt"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "POST without auth cookies is allowed",
+			method:     http.MethodPost,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "POST with bearer header is exempt",
+			method:     http.MethodPost,
+			cookies:    map[auth.TokenType]string{auth.AccessToken: "jwt"},
+			headers:    map[string]string{"Authorization": "Bearer jwt"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "POST with cookie and no token is rejected",
+			method:     http.MethodPost,
+			cookies:    map[auth.TokenType]string{auth.AccessToken: "jwt"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "DELETE with mismatched token is rejected",
+			method:     http.MethodDelete,
+			cookies:    map[auth.TokenType]string{auth.RefreshToken: "rt", auth.CSRFToken: "abc"},
+			headers:    map[string]string{auth.CSRFHeader: "xyz"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "PUT with matching token is allowed",
+			method:     http.MethodPut,
+			cookies:    map[auth.TokenType]string{auth.AccessToken: "jwt", auth.CSRFToken: "abc"},
+			headers:    map[string]string{auth.CSRFHeader: "abc"},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/items", nil)
+			for name, value := range tt.cookies {
+				req.AddCookie(&http.Cookie{Name: string(name), Value: value})
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/chaeanthony/go-pos/utils"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER  = "Idempotency-Key"
+	IDEMPOTENCY_KEY_TTL     = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyMiddleware makes POSTs with an Idempotency-Key header safe to retry. The first request
+// with a key runs and its response is stored; repeats get that response back without running the
+// handler again. Reusing a key with a different body is rejected with 422. Server errors aren't
+// stored so the request can be retried. Requests without the header are passed through.
+func (cfg *APIConfig) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Idempotency key is too long", nil)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusBadRequest, "Couldn't read request body", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		// Keys are per route and per caller so clients can't replay each other's responses.
+		scope := r.Method + " " + r.URL.Path + " " + cfg.idempotencyCaller(r)
+
+		existing, reserved, err := cfg.DB.ReserveIdempotencyKey(key, scope, hash, IDEMPOTENCY_KEY_TTL)
+		if err != nil {
+			utils.RespondError(w, cfg.Logger, http.StatusInternalServerError, "Couldn't check idempotency key", err)
+			return
+		}
+		if !reserved {
+			switch {
+			case existing.RequestHash != hash:
+				utils.RespondError(w, cfg.Logger, http.StatusUnprocessableEntity, "Idempotency key was already used with a different request", nil)
+			case !existing.Completed():
+				utils.RespondError(w, cfg.Logger, http.StatusConflict, "A request with this idempotency key is still being processed", nil)
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.ResponseBody)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.statusCode >= http.StatusInternalServerError {
+			if err := cfg.DB.ReleaseIdempotencyKey(key, scope); err != nil {
+				cfg.Logger.Errorf("couldn't release idempotency key: %v", err)
+			}
+			return
+		}
+		if err := cfg.DB.CompleteIdempotencyKey(key, scope, rec.statusCode, w.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+			cfg.Logger.Errorf("couldn't store idempotent response: %v", err)
+		}
+	})
+}
+
+// idempotencyCaller identifies who sent r: the API key or logged in user, or the IP address of
+// anonymous callers such as customers ordering without an account.
+func (cfg *APIConfig) idempotencyCaller(r *http.Request) string {
+	claims, ok := cfg.auditActor(r)
+	switch {
+	case ok && claims.IsAPIKey():
+		return "key:" + claims.APIKeyID.String()
+	case ok:
+		return "user:" + claims.UserID.String()
+	default:
+		return "ip:" + clientIP(r)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	rec.statusCode = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// SCHEDULER_INTERVAL is the longest the scheduler sleeps between checks for scheduled orders to release
+// and late station tickets.
+const SCHEDULER_INTERVAL = time.Minute
+
+// Scheduler releases scheduled orders to the kitchen when their prep lead time before pickup is
+// reached, and flags station tickets that go past the kitchen's target prep time. Both are only kept
+// in the database, so nothing is lost on restart: orders that came due while the server was down are
+// released as soon as it runs again.
+type Scheduler struct {
+	cfg  *APIConfig
+	wake chan struct{}
+}
+
+func NewScheduler(cfg *APIConfig) *Scheduler {
+	return &Scheduler{cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Run releases due orders and flags late tickets until ctx is done. It sleeps until the next order is
+// due or ticket goes late, checking at least every SCHEDULER_INTERVAL. After an error it waits the full
+// SCHEDULER_INTERVAL, since an order that can't be released stays due and would otherwise be retried
+// straight away.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		now := time.Now()
+		released := s.release(now)
+		flagged := s.flagLate(now)
+
+		wait := SCHEDULER_INTERVAL
+		next, err := s.cfg.DB.NextOrderRelease()
+		if err != nil {
+			s.cfg.Logger.Errorf("couldn't get next scheduled order: %v", err)
+		} else if !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+		if s.cfg.KitchenTargetPrepTime > 0 {
+			next, err := s.cfg.DB.NextStationTicketLate(s.cfg.KitchenTargetPrepTime)
+			if err != nil {
+				s.cfg.Logger.Errorf("couldn't get next late station ticket: %v", err)
+			} else if !next.IsZero() {
+				wait = min(wait, max(time.Until(next), 0))
+			}
+		}
+		if !released || !flagged {
+			wait = SCHEDULER_INTERVAL
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Wake makes the scheduler recheck when the next order is due or ticket goes late, e.g. after an
+// order is scheduled. Safe to call on a nil Scheduler.
+func (s *Scheduler) Wake() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// release sends due scheduled orders to the kitchen. Reports false if any couldn't be released.
+func (s *Scheduler) release(now time.Time) bool {
+	ids, err := s.cfg.DB.ReleaseScheduledOrders(now)
+	if err != nil {
+		s.cfg.Logger.Errorf("couldn't release scheduled orders: %v", err)
+	}
+	if len(ids) == 0 {
+		return err == nil
+	}
+
+	s.cfg.Logger.Infof("released %d scheduled orders", len(ids))
+	for _, id := range ids {
+		s.cfg.broadcastOrder("order_released", id)
+		s.cfg.broadcastStationTickets(id)
+	}
+	s.cfg.broadcastRefreshOrders()
+	return err == nil
+}
+
+// flagLate sends the screens of stations with tickets open past the target prep time a kds_ticket_late
+// event for each, once. Reports false if they couldn't be flagged.
+func (s *Scheduler) flagLate(now time.Time) bool {
+	if s.cfg.KitchenTargetPrepTime <= 0 {
+		return true
+	}
+
+	tickets, err := s.cfg.DB.FlagLateStationTickets(s.cfg.KitchenTargetPrepTime, now)
+	if err != nil {
+		s.cfg.Logger.Errorf("couldn't flag late station tickets: %v", err)
+		return false
+	}
+	for _, ticket := range tickets {
+		s.cfg.Logger.Warnf("%s ticket %d of order %d is late", ticket.Station, ticket.ID, ticket.Order.ID)
+		s.cfg.sendStationTicket("kds_ticket_late", ticket)
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+)
+
+const ticketWidth = 32
+
+// orderTicket lays an order out for a narrow receipt printer. Allergies go right under the header
+// so they can't be missed.
+func orderTicket(order database.Order) string {
+	var b strings.Builder
+	rule := strings.Repeat("-", ticketWidth) + "\n"
+
+	if order.TicketNumber != 0 {
+		fmt.Fprintf(&b, "TICKET %d\n", order.TicketNumber)
+	}
+	fmt.Fprintf(&b, "ORDER #%d\n", order.ID)
+	fmt.Fprintf(&b, "%s\n", order.ForName)
+	if order.OrderDate != "" {
+		fmt.Fprintf(&b, "%s\n", order.OrderDate)
+	}
+	switch order.Type {
+	case database.OrderTypeDineIn:
+		fmt.Fprintf(&b, "DINE IN - TABLE %s (%d)\n", order.TableNumber, order.Covers)
+	case database.OrderTypeTakeout:
+		if order.PickupAt != "" {
+			fmt.Fprintf(&b, "TAKEOUT - PICKUP %s\n", order.PickupAt)
+		} else {
+			b.WriteString("TAKEOUT\n")
+		}
+	case database.OrderTypeDelivery:
+		fmt.Fprintf(&b, "DELIVERY - %s\n", order.DeliveryAddress)
+	}
+
+	if len(order.Allergies) > 0 {
+		banner := strings.Repeat("!", ticketWidth) + "\n"
+		b.WriteString(banner)
+		fmt.Fprintf(&b, "ALLERGY: %s\n", strings.ToUpper(strings.ReplaceAll(strings.Join(order.Allergies, ", "), "_", " ")))
+		b.WriteString(banner)
+	}
+	if order.Notes != "" {
+		fmt.Fprintf(&b, "NOTE: %s\n", order.Notes)
+	}
+
+	b.WriteString(rule)
+	for _, item := range order.Items {
+		fmt.Fprintf(&b, "%d x %s\n", item.Quantity, item.ItemName)
+		if item.Notes != "" {
+			fmt.Fprintf(&b, "   - %s\n", item.Notes)
+		}
+	}
+	b.WriteString(rule)
+
+	if order.InternalNotes != "" {
+		fmt.Fprintf(&b, "STAFF: %s\n", order.InternalNotes)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderTicket(t *testing.T) {
+	order := database.Order{
+		ID:           1042,
+		TicketNumber: 12,
+		ForName:      "Ada",
+		OrderDate:    "2025-01-01 12:00:00",
+		OrderTypeDetails: database.OrderTypeDetails{
+			Type:        database.OrderTypeDineIn,
+			TableNumber: "4",
+			Covers:      2,
+		},
+		OrderNotes: database.OrderNotes{
+			Notes:         "Birthday",
+			Allergies:     []string{"peanuts", "tree_nuts"},
+			InternalNotes: "Regular, comp the cookie",
+		},
+		Items: []database.OrderItem{
+			{ItemName: "Latte", Quantity: 2, Notes: "extra hot"},
+		},
+	}
+
+	ticket := orderTicket(order)
+	lines := strings.Split(ticket, "\n")
+	assert.Equal(t, "TICKET 12", lines[0], "Ticket number is what gets called out")
+	assert.Equal(t, "ORDER #1042", lines[1])
+	assert.Contains(t, ticket, "DINE IN - TABLE 4 (2)\n")
+	assert.Contains(t, lines[:8], "ALLERGY: PEANUTS, TREE NUTS", "Allergies should be at the top of the ticket")
+	assert.Contains(t, ticket, "NOTE: Birthday\n")
+	assert.Contains(t, ticket, "2 x Latte\n   - extra hot\n")
+	assert.Contains(t, ticket, "STAFF: Regular, comp the cookie\n")
+
+	order.OrderNotes = database.OrderNotes{}
+	assert.NotContains(t, orderTicket(order), "ALLERGY")
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	require.NoError(t, err)
+	assert.True(t, IsAPIKey(key))
+	assert.True(t, strings.HasPrefix(key, prefix+"_"))
+	assert.Len(t, prefix, len(APIKeyPrefix)+8)
+	assert.False(t, IsAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig"))
+}
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		ip        string
+		want      bool
+	}{
+		{"empty allows all", nil, "203.0.113.9", true},
+		{"exact match", []string{"203.0.113.9"}, "203.0.113.9", true},
+		{"exact mismatch", []string{"203.0.113.9"}, "203.0.113.10", false},
+		{"cidr match", []string{"10.0.0.0/8"}, "10.20.30.40", true},
+		{"cidr mismatch", []string{"10.0.0.0/8"}, "11.0.0.1", false},
+		{"ipv4 mapped ipv6", []string{"10.0.0.0/8"}, "::ffff:10.0.0.1", true},
+		{"ipv6 cidr", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"invalid ip", []string{"10.0.0.0/8"}, "not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IPAllowed(tt.allowlist, tt.ip))
+		})
+	}
+
+	_, err := ParseIPAllowlist([]string{"10.0.0.0/8", "bogus"})
+	assert.Error(t, err)
+}
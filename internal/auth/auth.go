@@ -2,10 +2,12 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,14 +22,28 @@ const (
 	TokenTypeAccess TokenType = "go-home-pos"
 	AccessToken     TokenType = "access_token"
 	RefreshToken    TokenType = "refresh_token"
+	CSRFToken       TokenType = "csrf_token"
+)
+
+// CSRFHeader must echo the csrf_token cookie on state-changing requests authenticated by cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+const (
+	RoleUser     = "user"
+	RoleStore    = "store"
+	RoleManager  = "manager"
+	RoleCustomer = "customer" // customers who log in to see their own orders
 )
 
 type CustomClaims struct {
-	Role string `json:"role"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"` // refresh token family the access token was issued for
 	jwt.RegisteredClaims
 }
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
+var ErrMalformedAuthHeader = errors.New("malformed authorization header")
+var errUnknownUser = errors.New("unknown user")
 
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -41,10 +57,19 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, role string) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomClaims{
-		Role: role,
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleStore || role == RoleManager || role == RoleCustomer
+}
+
+// IsManager reports whether role can manage other users' sessions and data.
+func IsManager(role string) bool {
+	return role == RoleStore || role == RoleManager
+}
+
+func MakeJWT(userID uuid.UUID, keys *KeySet, expiresIn time.Duration, role string, sessionID uuid.UUID) (string, error) {
+	return keys.Sign(&CustomClaims{
+		Role:      role,
+		SessionID: sessionID.String(),
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    string(TokenTypeAccess),
 			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
@@ -52,69 +77,131 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, role
 			Subject:   userID.String(),
 		},
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (userID uuid.UUID, role string, err error) {
+func ValidateJWT(tokenString string, keys *KeySet) (userID uuid.UUID, role string, err error) {
+	claims, err := ValidateJWTClaims(tokenString, keys)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return claims.UserID, claims.Role, nil
+}
+
+// AccessClaims are the validated contents of an access token.
+type AccessClaims struct {
+	UserID    uuid.UUID
+	Role      string
+	SessionID uuid.UUID
+	ExpiresAt time.Time
+	APIKeyID  uuid.UUID // set instead of SessionID when authenticated with an API key
+	Scopes    []string  // API key scopes
+}
+
+func (c AccessClaims) IsAPIKey() bool {
+	return c.APIKeyID != uuid.Nil
+}
+
+func (c AccessClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+func ValidateJWTClaims(tokenString string, keys *KeySet) (AccessClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&CustomClaims{},
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyfunc,
+		jwt.WithValidMethods(keys.algorithms()),
 	)
 	if err != nil {
-		return uuid.Nil, "", err
+		return AccessClaims{}, err
 	}
 
 	if !token.Valid {
-		return uuid.Nil, "", fmt.Errorf("token invalid")
+		return AccessClaims{}, fmt.Errorf("token invalid")
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, "", err
+		return AccessClaims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, "", err
+		return AccessClaims{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, "", fmt.Errorf("invalid issuer")
+		return AccessClaims{}, fmt.Errorf("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+		return AccessClaims{}, fmt.Errorf("invalid user ID: %w", err)
 	}
 
 	claims, ok := token.Claims.(*CustomClaims)
 	if !ok {
-		return uuid.Nil, "", fmt.Errorf("failed to parse claims")
+		return AccessClaims{}, fmt.Errorf("failed to parse claims")
 	}
 
-	return id, claims.Role, nil
+	// tokens issued before session IDs existed have no sid
+	sessionID, _ := uuid.Parse(claims.SessionID)
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	return AccessClaims{
+		UserID:    id,
+		Role:      claims.Role,
+		SessionID: sessionID,
+		ExpiresAt: expiresAt,
+	}, nil
 }
 
+// GetBearerToken returns the token from the Authorization header, falling back to the tokenType
+// cookie. The header wins when both are present so API clients aren't affected by stale browser
+// cookies. Returns ErrNoAuthHeaderIncluded if neither carries a token.
 func GetBearerToken(r *http.Request, tokenType TokenType) (string, error) {
-	// 1. Check Authorization header for Bearer token
-	if r.Method == http.MethodPost {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-			token := strings.TrimPrefix(authHeader, "Bearer ")
-			return token, nil
-		}
+	if token, ok, err := bearerFromHeader(r.Header); ok || err != nil {
+		return token, err
 	}
 
-	// 2. Check cookie for access token
 	cookie, err := r.Cookie(string(tokenType))
-	if err == nil {
+	if err == nil && cookie.Value != "" {
 		return cookie.Value, nil
 	}
 
-	return "", nil
+	return "", ErrNoAuthHeaderIncluded
+}
+
+// HasBearerHeader reports whether the request authenticates with an Authorization: Bearer header
+// rather than cookies.
+func HasBearerHeader(r *http.Request) bool {
+	_, ok, _ := bearerFromHeader(r.Header)
+	return ok
+}
+
+// bearerFromHeader parses "Authorization: Bearer <token>". ok is false if there is no Bearer header;
+// other schemes are ignored so the cookie can still be used.
+func bearerFromHeader(h http.Header) (token string, ok bool, err error) {
+	scheme, token, found := strings.Cut(strings.TrimSpace(h.Get("Authorization")), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", false, nil
+	}
+	token = strings.TrimSpace(token)
+	if !found || token == "" {
+		return "", false, ErrMalformedAuthHeader
+	}
+	return token, true, nil
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeRandomToken()
+}
+
+// MakeRandomToken returns 32 random bytes hex encoded, for opaque tokens such as password reset links.
+func MakeRandomToken() (string, error) {
 	token := make([]byte, 32)
 	_, err := rand.Read(token)
 	if err != nil {
@@ -123,6 +210,12 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashToken returns the SHA-256 hex digest of a random token for storage at rest.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func SetTokenCookie(w http.ResponseWriter, token string, tokenType TokenType, path string, expireTime time.Duration, sameSite http.SameSite, secure bool) {
 	accessCookie := &http.Cookie{
 		Name:     string(tokenType),
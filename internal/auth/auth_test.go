@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		header    string
+		cookie    string
+		tokenType TokenType
+		want      string
+		wantErr   error
+	}{
+		{name: "header on GET", method: http.MethodGet, header: "Bearer abc", tokenType: AccessToken, want: "abc"},
+		{name: "header on POST", method: http.MethodPost, header: "Bearer abc", tokenType: AccessToken, want: "abc"},
+		{name: "header on PUT", method: http.MethodPut, header: "Bearer abc", tokenType: AccessToken, want: "abc"},
+		{name: "header on DELETE", method: http.MethodDelete, header: "Bearer abc", tokenType: AccessToken, want: "abc"},
+		{name: "scheme is case insensitive", method: http.MethodGet, header: "bearer abc", tokenType: AccessToken, want: "abc"},
+		{name: "header wins over cookie", method: http.MethodGet, header: "Bearer header", cookie: "cookie", tokenType: AccessToken, want: "header"},
+		{name: "cookie fallback", method: http.MethodGet, cookie: "cookie", tokenType: AccessToken, want: "cookie"},
+		{name: "refresh cookie", method: http.MethodPost, cookie: "rt", tokenType: RefreshToken, want: "rt"},
+		{name: "other scheme falls back to cookie", method: http.MethodGet, header: "Basic dXNlcjpwYXNz", cookie: "cookie", tokenType: AccessToken, want: "cookie"},
+		{name: "other scheme without cookie", method: http.MethodGet, header: "Basic dXNlcjpwYXNz", tokenType: AccessToken, wantErr: ErrNoAuthHeaderIncluded},
+		{name: "empty bearer token", method: http.MethodGet, header: "Bearer ", cookie: "cookie", tokenType: AccessToken, wantErr: ErrMalformedAuthHeader},
+		{name: "bearer without token", method: http.MethodGet, header: "Bearer", tokenType: AccessToken, wantErr: ErrMalformedAuthHeader},
+		{name: "empty cookie", method: http.MethodGet, cookie: "", tokenType: AccessToken, wantErr: ErrNoAuthHeaderIncluded},
+		{name: "nothing", method: http.MethodGet, tokenType: AccessToken, wantErr: ErrNoAuthHeaderIncluded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: string(tt.tokenType), Value: tt.cookie})
+			}
+
+			got, err := GetBearerToken(r, tt.tokenType)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHasBearerHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer abc", true},
+		{"bearer abc", true},
+		{"Bearer ", false},
+		{"Basic abc", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", tt.header)
+		assert.Equal(t, tt.want, HasBearerHeader(r), "header %q", tt.header)
+	}
+}
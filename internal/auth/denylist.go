@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Denylist holds revoked session IDs until any access token issued for them has expired.
+type Denylist struct {
+	entries map[uuid.UUID]time.Time
+	mu      sync.Mutex
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{
+		entries: make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Add denies sessionID for ttl, which should be at least the access token lifetime.
+func (d *Denylist) Add(sessionID uuid.UUID, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[sessionID] = time.Now().Add(ttl)
+}
+
+// Contains reports whether sessionID has been revoked. Expired entries are pruned.
+func (d *Denylist) Contains(sessionID uuid.UUID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, until := range d.entries {
+		if now.After(until) {
+			delete(d.entries, id)
+		}
+	}
+
+	_, ok := d.entries[sessionID]
+	return ok
+}
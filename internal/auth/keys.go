@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a JWT signing or verification key. The kid is derived from the key material so every
+// service that loads the same key agrees on its ID.
+type Key struct {
+	ID        string
+	Algorithm string // JWT alg the key is pinned to
+
+	signingKey      interface{} // nil for verification-only keys
+	verificationKey interface{}
+	public          crypto.PublicKey // nil for symmetric keys, which are never published
+}
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// NewHMACKey wraps a shared HS256 secret. HMAC keys are never published in the JWKS.
+func NewHMACKey(secret string) Key {
+	sum := sha256.Sum256([]byte(secret))
+	return Key{
+		ID:              "hs256-" + hex.EncodeToString(sum[:8]),
+		Algorithm:       jwt.SigningMethodHS256.Alg(),
+		signingKey:      []byte(secret),
+		verificationKey: []byte(secret),
+	}
+}
+
+func NewEd25519Key(private ed25519.PrivateKey) Key {
+	key := newPublicKey(private.Public().(ed25519.PublicKey))
+	key.signingKey = private
+	return key
+}
+
+func NewRSAKey(private *rsa.PrivateKey) Key {
+	key := newPublicKey(&private.PublicKey)
+	key.signingKey = private
+	return key
+}
+
+// NewVerificationKey wraps a public key that can only verify tokens, such as a retired signing key.
+func NewVerificationKey(public crypto.PublicKey) (Key, error) {
+	switch public.(type) {
+	case ed25519.PublicKey, *rsa.PublicKey:
+		return newPublicKey(public), nil
+	default:
+		return Key{}, fmt.Errorf("unsupported public key type %T", public)
+	}
+}
+
+func newPublicKey(public crypto.PublicKey) Key {
+	key := Key{verificationKey: public, public: public}
+	switch public.(type) {
+	case ed25519.PublicKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+	case *rsa.PublicKey:
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+	}
+	key.ID = key.thumbprint()
+	return key
+}
+
+// LoadKeyFile reads a PEM encoded Ed25519 or RSA key. Private keys (PKCS#8 or PKCS#1) can sign,
+// public keys (PKIX) can only verify.
+func LoadKeyFile(path string) (Key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return Key{}, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("couldn't parse %s: %w", path, err)
+		}
+		switch k := private.(type) {
+		case ed25519.PrivateKey:
+			return NewEd25519Key(k), nil
+		case *rsa.PrivateKey:
+			return NewRSAKey(k), nil
+		default:
+			return Key{}, fmt.Errorf("unsupported private key type %T in %s", private, path)
+		}
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("couldn't parse %s: %w", path, err)
+		}
+		return NewRSAKey(private), nil
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("couldn't parse %s: %w", path, err)
+		}
+		return NewVerificationKey(public)
+	default:
+		return Key{}, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+}
+
+func (k Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+func (k Key) signingMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet signs new tokens with one key and accepts tokens from any key in the set, so keys can be
+// rotated without logging everyone out.
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeySet signs with signing and also verifies tokens signed by any of verification.
+func NewKeySet(signing Key, verification ...Key) (*KeySet, error) {
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("key %s can't sign", signing.ID)
+	}
+
+	ks := &KeySet{
+		signing: signing,
+		keys:    map[string]Key{signing.ID: signing},
+	}
+	for _, k := range verification {
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// Sign returns a token for claims signed with the current key and its kid in the header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.signingMethod(), claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.signingKey)
+}
+
+// keyfunc looks up the verification key by kid and only accepts the algorithm that key is pinned to,
+// so a token can't pick a weaker algorithm than the key was issued for.
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// tokens issued before key IDs were added were signed with the shared HMAC secret
+		var legacy jwt.VerificationKeySet
+		for _, k := range ks.keys {
+			if k.Algorithm == jwt.SigningMethodHS256.Alg() {
+				legacy.Keys = append(legacy.Keys, k.verificationKey)
+			}
+		}
+		if len(legacy.Keys) == 0 || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, ErrUnknownKey
+		}
+		return legacy, nil
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.verificationKey, nil
+}
+
+// algorithms lists every algorithm used by a key in the set.
+func (ks *KeySet) algorithms() []string {
+	seen := map[string]bool{}
+	algs := []string{}
+	for _, k := range ks.keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	return algs
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the set so other services can verify tokens. HMAC keys are omitted.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	// signing key first so clients that only take the first key get the current one
+	if jwk, ok := ks.signing.jwk(); ok {
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	for id, k := range ks.keys {
+		if id == ks.signing.ID {
+			continue
+		}
+		if jwk, ok := k.jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+func (k Key) jwk() (JWK, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pub), Kid: k.ID, Alg: k.Algorithm, Use: "sig"}, true
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()), Kid: k.ID, Alg: k.Algorithm, Use: "sig"}, true
+	default:
+		return JWK{}, false
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as the kid for asymmetric keys.
+func (k Key) thumbprint() string {
+	jwk, ok := k.jwk()
+	if !ok {
+		return ""
+	}
+
+	// required members only, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	}
+
+	dat, _ := json.Marshal(members)
+	sum := sha256.Sum256(dat)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
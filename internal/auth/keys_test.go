@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	hmacKey := NewHMACKey("old-secret")
+	edKey := NewEd25519Key(edPrivate)
+	rsaKey := NewRSAKey(rsaPrivate)
+
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	t.Run("Sign and validate", func(t *testing.T) {
+		for _, key := range []Key{hmacKey, edKey, rsaKey} {
+			ks, err := NewKeySet(key)
+			require.NoError(t, err)
+
+			token, err := MakeJWT(userID, ks, time.Minute, RoleStore, sessionID)
+			require.NoError(t, err)
+
+			claims, err := ValidateJWTClaims(token, ks)
+			require.NoError(t, err, key.Algorithm)
+			assert.Equal(t, userID, claims.UserID)
+			assert.Equal(t, sessionID, claims.SessionID)
+			assert.Equal(t, RoleStore, claims.Role)
+		}
+	})
+
+	t.Run("Rotation keeps old tokens valid", func(t *testing.T) {
+		old, err := NewKeySet(hmacKey)
+		require.NoError(t, err)
+		token, err := MakeJWT(userID, old, time.Minute, RoleUser, sessionID)
+		require.NoError(t, err)
+
+		rotated, err := NewKeySet(edKey, hmacKey)
+		require.NoError(t, err)
+		_, err = ValidateJWTClaims(token, rotated)
+		assert.NoError(t, err, "Token from a retired key should still validate")
+
+		retired, err := NewKeySet(edKey)
+		require.NoError(t, err)
+		_, err = ValidateJWTClaims(token, retired)
+		assert.Error(t, err, "Token from a removed key should fail")
+	})
+
+	t.Run("Legacy token without kid", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomClaims{
+			Role: RoleUser,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    string(TokenTypeAccess),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Subject:   userID.String(),
+			},
+		}).SignedString([]byte("old-secret"))
+		require.NoError(t, err)
+
+		ks, err := NewKeySet(edKey, hmacKey)
+		require.NoError(t, err)
+		claims, err := ValidateJWTClaims(token, ks)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Nil, claims.SessionID)
+	})
+
+	t.Run("Algorithm is pinned to the key", func(t *testing.T) {
+		ks, err := NewKeySet(rsaKey, hmacKey)
+		require.NoError(t, err)
+
+		// HS256 token claiming the RSA key's kid, using the public key bytes as the secret
+		pubDER, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+		require.NoError(t, err)
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    string(TokenTypeAccess),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Subject:   userID.String(),
+			},
+		})
+		forged.Header["kid"] = rsaKey.ID
+		token, err := forged.SignedString(pubDER)
+		require.NoError(t, err)
+
+		_, err = ValidateJWTClaims(token, ks)
+		assert.Error(t, err)
+
+		none := jwt.NewWithClaims(jwt.SigningMethodNone, &CustomClaims{})
+		token, err = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		_, err = ValidateJWTClaims(token, ks)
+		assert.Error(t, err)
+	})
+
+	t.Run("JWKS only publishes public keys", func(t *testing.T) {
+		ks, err := NewKeySet(edKey, rsaKey, hmacKey)
+		require.NoError(t, err)
+
+		jwks := ks.JWKS()
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, edKey.ID, jwks.Keys[0].Kid, "Signing key should be listed first")
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+		assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+		assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	})
+
+	t.Run("Load key files", func(t *testing.T) {
+		dir := t.TempDir()
+		privDER, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+		require.NoError(t, err)
+		pubDER, err := x509.MarshalPKIXPublicKey(edPrivate.Public())
+		require.NoError(t, err)
+
+		privPath := filepath.Join(dir, "signing.pem")
+		pubPath := filepath.Join(dir, "retired.pem")
+		require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600))
+		require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0600))
+
+		signing, err := LoadKeyFile(privPath)
+		require.NoError(t, err)
+		assert.True(t, signing.CanSign())
+		assert.Equal(t, edKey.ID, signing.ID, "kid should be stable for the same key")
+
+		verifying, err := LoadKeyFile(pubPath)
+		require.NoError(t, err)
+		assert.False(t, verifying.CanSign())
+		assert.Equal(t, edKey.ID, verifying.ID)
+
+		_, err = NewKeySet(verifying)
+		assert.Error(t, err, "Public key can't be the signing key")
+	})
+}
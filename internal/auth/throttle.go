@@ -0,0 +1,69 @@
+package auth
+
+import "time"
+
+// LoginThrottle decides how long a client must wait before another login attempt
+// after a run of failures.
+type LoginThrottle struct {
+	Window          time.Duration // failures older than this are forgotten
+	FreeAttempts    int           // failures allowed before backoff starts
+	BaseDelay       time.Duration // delay after the first failure past FreeAttempts, doubled for each one after
+	MaxDelay        time.Duration
+	LockoutAfter    int // failures that trigger a temporary lockout
+	LockoutDuration time.Duration
+}
+
+var (
+	AccountLoginThrottle = LoginThrottle{
+		Window:          time.Hour,
+		FreeAttempts:    3,
+		BaseDelay:       2 * time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+	IPLoginThrottle = LoginThrottle{
+		Window:          time.Hour,
+		FreeAttempts:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    50,
+		LockoutDuration: time.Hour,
+	}
+)
+
+// dummyPasswordHash is compared against when the email is unknown so that the
+// response takes as long as a wrong password for a real account.
+const dummyPasswordHash = "$2a$10$ccqHWrZ5Hg7wmOtlRY.SLuTMLcAiVT.9vqXhD3XOjEzadvxUDCQ7S"
+
+// RetryAfter returns how long to wait before the next attempt is allowed, or 0 if it is allowed now.
+func (t LoginThrottle) RetryAfter(failures int, lastFailure, now time.Time) time.Duration {
+	if failures <= t.FreeAttempts {
+		return 0
+	}
+
+	delay := t.LockoutDuration
+	if failures < t.LockoutAfter {
+		delay = t.BaseDelay
+		for i := t.FreeAttempts + 1; i < failures && delay < t.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, t.MaxDelay)
+	}
+
+	wait := lastFailure.Add(delay).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// CheckPasswordHashOrDummy behaves like CheckPasswordHash but always runs a bcrypt comparison,
+// using a dummy hash when hash is empty (unknown user). It only succeeds for a real hash.
+func CheckPasswordHashOrDummy(password, hash string) error {
+	if hash == "" {
+		CheckPasswordHash(password, dummyPasswordHash)
+		return errUnknownUser
+	}
+	return CheckPasswordHash(password, hash)
+}
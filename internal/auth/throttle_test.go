@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottleRetryAfter(t *testing.T) {
+	throttle := LoginThrottle{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		LockoutAfter:    10,
+		LockoutDuration: time.Hour,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		failures    int
+		lastFailure time.Time
+		want        time.Duration
+	}{
+		{"no failures", 0, now, 0},
+		{"within free attempts", 3, now, 0},
+		{"first backoff", 4, now, time.Second},
+		{"doubles", 5, now, 2 * time.Second},
+		{"doubles again", 6, now, 4 * time.Second},
+		{"capped at max", 9, now, 10 * time.Second},
+		{"locked out", 10, now, time.Hour},
+		{"backoff elapsed", 5, now.Add(-3 * time.Second), 0},
+		{"partially elapsed", 6, now.Add(-time.Second), 3 * time.Second},
+		{"lockout elapsed", 12, now.Add(-2 * time.Hour), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, throttle.RetryAfter(tt.failures, tt.lastFailure, now))
+		})
+	}
+}
+
+func TestCheckPasswordHashOrDummy(t *testing.T) {
+	hash, err := HashPassword("secret")
+	assert.NoError(t, err)
+
+	assert.NoError(t, CheckPasswordHashOrDummy("secret", hash))
+	assert.Error(t, CheckPasswordHashOrDummy("wrong", hash))
+	assert.Error(t, CheckPasswordHashOrDummy("go-pos-dummy-password", ""), "unknown user must never succeed")
+}
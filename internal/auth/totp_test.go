@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 SHA1 test secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		name     string
+		code     string
+		now      time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"rfc vector at 59s", "287082", time.Unix(59, 0), 1, true},
+		{"rfc vector at 1111111109s", "081804", time.Unix(1111111109, 0), 37037036, true},
+		{"previous step within skew", "287082", time.Unix(75, 0), 1, true},
+		{"outside skew", "287082", time.Unix(200, 0), 0, false},
+		{"wrong code", "123456", time.Unix(59, 0), 0, false},
+		{"wrong length", "28708", time.Unix(59, 0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tt.code, tt.now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantStep, step)
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	uri := TOTPURI(secret, "Go POS", "owner@test.com")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Go%20POS:owner@test.com?"), uri)
+	assert.Contains(t, uri, "secret="+secret)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Len(t, NormalizeRecoveryCode(strings.ToUpper(code)), 10)
+	}
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	KeyHash     string     `json:"-"`
+	UserID      uuid.UUID  `json:"user_id"` // user who created the key
+	Scopes      []string   `json:"scopes"`
+	IPAllowlist []string   `json:"ip_allowlist"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+func (c *Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+
+	var expiresAt *string
+	if params.ExpiresAt != nil {
+		t := params.ExpiresAt.UTC().Format(TIME_LAYOUT)
+		expiresAt = &t
+	}
+
+	query := `
+		INSERT INTO api_keys (id, created_at, name, prefix, key_hash, user_id, scopes, ip_allowlist, expires_at)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query,
+		id.String(),
+		params.Name,
+		params.Prefix,
+		params.KeyHash,
+		params.UserID.String(),
+		strings.Join(params.Scopes, " "),
+		strings.Join(params.IPAllowlist, " "),
+		expiresAt,
+	)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("couldn't create api key: %w", err)
+	}
+
+	return c.GetAPIKey(id)
+}
+
+const apiKeyColumns = `
+	id, created_at, name, prefix, key_hash, user_id, scopes, ip_allowlist, expires_at,
+	last_used_at, COALESCE(last_used_ip, ''), revoked_at
+`
+
+// GetAPIKeys lists every key, newest first.
+func (c *Client) GetAPIKeys() ([]APIKey, error) {
+	rows, err := c.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKey returns the key with id. Returns an empty APIKey if none exists.
+func (c *Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	row := c.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id.String())
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, nil
+	}
+	return key, err
+}
+
+// GetActiveAPIKeyByHash returns an unrevoked, unexpired key. Returns an empty APIKey if none matches.
+func (c *Client) GetActiveAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE key_hash = ?
+			AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, nil
+	}
+	return key, err
+}
+
+func (c *Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+// TouchAPIKey records that a key was just used from ip.
+func (c *Client) TouchAPIKey(id uuid.UUID, ip string) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, ip, id.String())
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var id, userID, created_at, scopes, allowlist string
+	var expires_at, last_used_at, revoked_at *string
+
+	err := row.Scan(&id, &created_at, &key.Name, &key.Prefix, &key.KeyHash, &userID, &scopes, &allowlist, &expires_at,
+		&last_used_at, &key.LastUsedIP, &revoked_at)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if key.ID, err = uuid.Parse(id); err != nil {
+		return APIKey{}, err
+	}
+	if key.UserID, err = uuid.Parse(userID); err != nil {
+		return APIKey{}, err
+	}
+	if key.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.IPAllowlist = strings.Fields(allowlist)
+
+	if key.ExpiresAt, err = parseTimePtr(expires_at); err != nil {
+		return APIKey{}, err
+	}
+	if key.LastUsedAt, err = parseTimePtr(last_used_at); err != nil {
+		return APIKey{}, err
+	}
+	if key.RevokedAt, err = parseTimePtr(revoked_at); err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
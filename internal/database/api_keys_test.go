@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	user, err := c.CreateUser(CreateUserParams{Email: "owner@test.com", Password: "pw", Role: "store"})
+	require.NoError(t, err, "Failed to create user")
+
+	key, err := c.CreateAPIKey(CreateAPIKeyParams{
+		Name:        "Accounting sync",
+		Prefix:      "pos_abcd1234",
+		KeyHash:     "hash",
+		UserID:      user.ID,
+		Scopes:      []string{"orders:read"},
+		IPAllowlist: []string{"10.0.0.0/8"},
+	})
+	require.NoError(t, err, "Failed to create api key")
+	require.Equal(t, []string{"orders:read"}, key.Scopes)
+	require.Equal(t, []string{"10.0.0.0/8"}, key.IPAllowlist)
+	require.Nil(t, key.ExpiresAt)
+
+	t.Run("Lookup and touch", func(t *testing.T) {
+		found, err := c.GetActiveAPIKeyByHash("hash")
+		require.NoError(t, err)
+		require.Equal(t, key.ID, found.ID)
+
+		require.NoError(t, c.TouchAPIKey(key.ID, "10.1.2.3"))
+		found, err = c.GetAPIKey(key.ID)
+		require.NoError(t, err)
+		require.NotNil(t, found.LastUsedAt)
+		require.Equal(t, "10.1.2.3", found.LastUsedIP)
+	})
+
+	t.Run("Expired key", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		_, err := c.CreateAPIKey(CreateAPIKeyParams{
+			Name: "Old", Prefix: "pos_old", KeyHash: "expired", UserID: user.ID, ExpiresAt: &expired,
+		})
+		require.NoError(t, err)
+
+		found, err := c.GetActiveAPIKeyByHash("expired")
+		require.NoError(t, err)
+		require.Equal(t, uuid.Nil, found.ID)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		require.NoError(t, c.RevokeAPIKey(key.ID))
+		found, err := c.GetActiveAPIKeyByHash("hash")
+		require.NoError(t, err)
+		require.Equal(t, uuid.Nil, found.ID)
+
+		keys, err := c.GetAPIKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+	})
+}
@@ -0,0 +1,335 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audited actions.
+const (
+	AuditLogin            = "auth.login"
+	AuditRoleChanged      = "user.role_changed"
+	AuditItemPriceEdited  = "item.price_changed"
+	AuditOrderVoided      = "order.voided"
+	AuditOrderRefunded    = "order.refunded"
+	AuditDiscountApplied  = "order.discount_applied"
+	AuditDrawerOpened     = "drawer.opened"
+	AuditOrderNotesEdited = "order.notes_edited"
+	AuditTabClosed        = "tab.closed"
+	AuditGiftCardUnlocked = "gift_card.unlocked"
+)
+
+// auditGenesisHash is the prev_hash of the first entry in the chain.
+const auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AUDIT_APPEND_RETRIES bounds how often an append is retried when another writer extended the chain first.
+const AUDIT_APPEND_RETRIES = 5
+
+type AuditEntry struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorUserID   *uuid.UUID      `json:"actor_user_id"`
+	ActorAPIKeyID *uuid.UUID      `json:"actor_api_key_id"`
+	Action        string          `json:"action"`
+	EntityType    string          `json:"entity_type"`
+	EntityID      string          `json:"entity_id"`
+	IPAddress     string          `json:"ip_address"`
+	Details       json.RawMessage `json:"details"`
+	PrevHash      string          `json:"prev_hash"`
+	Hash          string          `json:"hash"`
+}
+
+type CreateAuditEntryParams struct {
+	ActorUserID   uuid.UUID
+	ActorAPIKeyID uuid.UUID
+	Action        string
+	EntityType    string
+	EntityID      string
+	IPAddress     string
+	Details       interface{} // marshalled to JSON
+}
+
+type AuditFilter struct {
+	Action      string
+	ActorUserID uuid.UUID
+	EntityType  string
+	EntityID    string
+	Since       *time.Time
+	Until       *time.Time
+	BeforeID    int64 // cursor: only entries older than this ID
+	Limit       int
+}
+
+// AuditVerification is the result of walking the chain. HeadHash can be recorded elsewhere so that
+// truncating the newest entries is detectable too.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	HeadHash string `json:"head_hash"`
+	BrokenAt int64  `json:"broken_at,omitempty"` // first entry that doesn't match the chain
+	Reason   string `json:"reason,omitempty"`
+}
+
+var errAuditChainMoved = errors.New("audit chain moved during append")
+
+// AppendAuditEntry adds an entry chained to the current head of the log.
+func (c *Client) AppendAuditEntry(params CreateAuditEntryParams) (AuditEntry, error) {
+	details := []byte("{}")
+	if params.Details != nil {
+		dat, err := json.Marshal(params.Details)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("couldn't marshal audit details: %w", err)
+		}
+		details = dat
+	}
+
+	entry := AuditEntry{
+		Action:     params.Action,
+		EntityType: params.EntityType,
+		EntityID:   params.EntityID,
+		IPAddress:  params.IPAddress,
+		Details:    details,
+	}
+	if params.ActorUserID != uuid.Nil {
+		entry.ActorUserID = &params.ActorUserID
+	}
+	if params.ActorAPIKeyID != uuid.Nil {
+		entry.ActorAPIKeyID = &params.ActorAPIKeyID
+	}
+
+	for range AUDIT_APPEND_RETRIES {
+		err := c.appendAuditEntry(&entry)
+		if errors.Is(err, errAuditChainMoved) {
+			continue
+		}
+		return entry, err
+	}
+	return AuditEntry{}, fmt.Errorf("couldn't append audit entry: %w", errAuditChainMoved)
+}
+
+func (c *Client) appendAuditEntry(entry *AuditEntry) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var lastID int64
+	var lastHash string
+	err = tx.QueryRow(`SELECT id, hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&lastID, &lastHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		lastHash = auditGenesisHash
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	entry.ID = lastID + 1
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	entry.PrevHash = lastHash
+	entry.Hash = entry.computeHash()
+
+	_, err = tx.Exec(`
+		INSERT INTO audit_log (
+			id, created_at, actor_user_id, actor_api_key_id, action, entity_type, entity_id, ip_address, details, prev_hash, hash
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.ID, entry.CreatedAt.Format(TIME_LAYOUT), uuidPtrString(entry.ActorUserID), uuidPtrString(entry.ActorAPIKeyID),
+		entry.Action, entry.EntityType, entry.EntityID, entry.IPAddress, string(entry.Details), entry.PrevHash, entry.Hash)
+	if err != nil {
+		tx.Rollback()
+		// another writer appended after our read; prev_hash and id are unique so retry on the new head
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return errAuditChainMoved
+		}
+		return fmt.Errorf("couldn't insert audit entry: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// computeHash hashes every stored column except the hash itself, together with the previous hash.
+func (e AuditEntry) computeHash() string {
+	dat, _ := json.Marshal([]string{
+		fmt.Sprint(e.ID),
+		e.CreatedAt.UTC().Format(TIME_LAYOUT),
+		uuidPtrHashString(e.ActorUserID),
+		uuidPtrHashString(e.ActorAPIKeyID),
+		e.Action,
+		e.EntityType,
+		e.EntityID,
+		e.IPAddress,
+		string(e.Details),
+		e.PrevHash,
+	})
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:])
+}
+
+const auditColumns = `
+	id, created_at, actor_user_id, actor_api_key_id, action, entity_type, entity_id, ip_address, details, prev_hash, hash
+`
+
+// GetAuditEntries returns entries matching filter, newest first.
+func (c *Client) GetAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.ActorUserID != uuid.Nil {
+		conditions = append(conditions, "actor_user_id = ?")
+		args = append(args, filter.ActorUserID.String())
+	}
+	if filter.EntityType != "" {
+		conditions = append(conditions, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(TIME_LAYOUT))
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC().Format(TIME_LAYOUT))
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+	args = append(args, filter.Limit)
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// VerifyAuditLog walks the whole chain and reports the first entry whose hash, link to the previous
+// entry or sequence number doesn't match, which means a row was edited, inserted or removed.
+func (c *Client) VerifyAuditLog() (AuditVerification, error) {
+	rows, err := c.db.Query(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY id ASC`)
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	defer rows.Close()
+
+	result := AuditVerification{Valid: true, HeadHash: auditGenesisHash}
+	var lastID int64
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return AuditVerification{}, err
+		}
+
+		reason := ""
+		switch {
+		case entry.ID != lastID+1:
+			reason = fmt.Sprintf("expected entry %d", lastID+1)
+		case entry.PrevHash != result.HeadHash:
+			reason = "previous hash doesn't match"
+		case entry.computeHash() != entry.Hash:
+			reason = "entry hash doesn't match its contents"
+		}
+		if reason != "" {
+			result.Valid = false
+			result.BrokenAt = entry.ID
+			result.Reason = reason
+			return result, nil
+		}
+
+		result.Entries++
+		result.HeadHash = entry.Hash
+		lastID = entry.ID
+	}
+
+	if err := rows.Err(); err != nil {
+		return AuditVerification{}, err
+	}
+
+	return result, nil
+}
+
+func scanAuditEntry(row rowScanner) (AuditEntry, error) {
+	var entry AuditEntry
+	var created_at, details string
+	var actorUserID, actorAPIKeyID *string
+
+	err := row.Scan(&entry.ID, &created_at, &actorUserID, &actorAPIKeyID, &entry.Action, &entry.EntityType, &entry.EntityID,
+		&entry.IPAddress, &details, &entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	if entry.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+		return AuditEntry{}, err
+	}
+	if entry.ActorUserID, err = parseUUIDPtr(actorUserID); err != nil {
+		return AuditEntry{}, err
+	}
+	if entry.ActorAPIKeyID, err = parseUUIDPtr(actorAPIKeyID); err != nil {
+		return AuditEntry{}, err
+	}
+	entry.Details = json.RawMessage(details)
+
+	return entry, nil
+}
+
+func parseUUIDPtr(s *string) (*uuid.UUID, error) {
+	if s == nil {
+		return nil, nil
+	}
+	id, err := uuid.Parse(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// uuidPtrString returns the ID as stored, or nil for NULL.
+func uuidPtrString(id *uuid.UUID) *string {
+	if id == nil {
+		return nil
+	}
+	s := id.String()
+	return &s
+}
+
+func uuidPtrHashString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	actor := uuid.New()
+	for _, action := range []string{AuditLogin, AuditItemPriceEdited, AuditDrawerOpened} {
+		_, err := c.AppendAuditEntry(CreateAuditEntryParams{
+			ActorUserID: actor,
+			Action:      action,
+			EntityType:  "test",
+			EntityID:    action,
+			Details:     map[string]string{"action": action},
+		})
+		require.NoError(t, err, "Failed to append audit entry")
+	}
+
+	t.Run("Entries are chained", func(t *testing.T) {
+		entries, err := c.GetAuditEntries(AuditFilter{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.Equal(t, int64(3), entries[0].ID, "Newest entry should be first")
+		require.Equal(t, entries[1].Hash, entries[0].PrevHash)
+		require.Equal(t, entries[2].Hash, entries[1].PrevHash)
+		require.Equal(t, auditGenesisHash, entries[2].PrevHash)
+
+		result, err := c.VerifyAuditLog()
+		require.NoError(t, err)
+		require.True(t, result.Valid)
+		require.Equal(t, 3, result.Entries)
+		require.Equal(t, entries[0].Hash, result.HeadHash)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		entries, err := c.GetAuditEntries(AuditFilter{Action: AuditItemPriceEdited, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, actor, *entries[0].ActorUserID)
+
+		entries, err = c.GetAuditEntries(AuditFilter{ActorUserID: actor, BeforeID: 3, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		entries, err = c.GetAuditEntries(AuditFilter{ActorUserID: uuid.New(), Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("Log is append-only", func(t *testing.T) {
+		_, err := c.db.Exec(`UPDATE audit_log SET action = 'x' WHERE id = 1`)
+		require.Error(t, err)
+		_, err = c.db.Exec(`DELETE FROM audit_log WHERE id = 1`)
+		require.Error(t, err)
+	})
+
+	t.Run("Tampering is detected", func(t *testing.T) {
+		_, err := c.db.Exec(`DROP TRIGGER audit_log_no_update`)
+		require.NoError(t, err)
+		_, err = c.db.Exec(`UPDATE audit_log SET details = '{"action":"forged"}' WHERE id = 2`)
+		require.NoError(t, err)
+
+		result, err := c.VerifyAuditLog()
+		require.NoError(t, err)
+		require.False(t, result.Valid)
+		require.Equal(t, int64(2), result.BrokenAt)
+	})
+}
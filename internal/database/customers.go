@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Customer struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UserID    *uuid.UUID `json:"user_id"` // account the customer logs in with, if any
+	CreateCustomerParams
+}
+
+type CreateCustomerParams struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// NormalizeEmail is the form emails are stored and deduplicated in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone keeps only the digits of a phone number, and a leading + if there is one, so the
+// register can look customers up however the number was typed.
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	var b strings.Builder
+	for i, r := range phone {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// UpsertCustomer returns the customer with params.Email, creating it if there is none. Customers
+// without an email are always created. A non-empty name or phone replaces the stored one.
+func (c *Client) UpsertCustomer(params CreateCustomerParams) (Customer, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Customer{}, err
+	}
+
+	id, err := upsertCustomer(tx, params)
+	if err != nil {
+		tx.Rollback()
+		return Customer{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Customer{}, err
+	}
+
+	return c.GetCustomer(id)
+}
+
+// upsertCustomer is UpsertCustomer inside an existing transaction, e.g. while creating an order.
+func upsertCustomer(tx *sql.Tx, params CreateCustomerParams) (uuid.UUID, error) {
+	email := NormalizeEmail(params.Email)
+	var emailArg *string
+	if email != "" {
+		emailArg = &email
+	}
+
+	query := `
+		INSERT INTO customers (id, created_at, updated_at, name, email, phone)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, NULLIF(?, ''))
+		ON CONFLICT (email) DO UPDATE SET
+			name = COALESCE(NULLIF(excluded.name, ''), name),
+			phone = COALESCE(excluded.phone, phone),
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id
+	`
+	var id string
+	err := tx.QueryRow(query, uuid.NewString(), strings.TrimSpace(params.Name), emailArg, NormalizePhone(params.Phone)).Scan(&id)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't save customer: %w", err)
+	}
+
+	return uuid.Parse(id)
+}
+
+const customerColumns = `
+	id, created_at, updated_at, name, COALESCE(email, ''), COALESCE(phone, ''), user_id
+`
+
+// GetCustomers lists customers, optionally matching search against name, email or phone.
+func (c *Client) GetCustomers(search string, limit int) ([]Customer, error) {
+	query := `SELECT ` + customerColumns + ` FROM customers
+		WHERE ? = '' OR name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\' OR phone LIKE ? ESCAPE '\'
+		ORDER BY updated_at DESC
+		LIMIT ?
+	`
+	pattern := likeContains(search)
+	rows, err := c.db.Query(query, search, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []Customer{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return customers, nil
+}
+
+// GetCustomer returns an empty Customer if none exists.
+func (c *Client) GetCustomer(id uuid.UUID) (Customer, error) {
+	return c.getCustomer(`id = ?`, id.String())
+}
+
+// GetCustomerByEmail returns an empty Customer if none exists.
+func (c *Client) GetCustomerByEmail(email string) (Customer, error) {
+	return c.getCustomer(`email = ?`, NormalizeEmail(email))
+}
+
+// GetCustomerByPhone returns the most recently active customer with phone, or an empty Customer.
+func (c *Client) GetCustomerByPhone(phone string) (Customer, error) {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return Customer{}, nil
+	}
+	return c.getCustomer(`phone = ? ORDER BY updated_at DESC LIMIT 1`, phone)
+}
+
+// GetCustomerByUser returns the customer linked to a user account, or an empty Customer.
+func (c *Client) GetCustomerByUser(userID uuid.UUID) (Customer, error) {
+	return c.getCustomer(`user_id = ?`, userID.String())
+}
+
+func (c *Client) getCustomer(where string, args ...interface{}) (Customer, error) {
+	row := c.db.QueryRow(`SELECT `+customerColumns+` FROM customers WHERE `+where, args...)
+	customer, err := scanCustomer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Customer{}, nil
+	}
+	return customer, err
+}
+
+// LinkCustomerUser links a customer to the user account they log in with, creating the customer
+// from the account's email if there is none yet.
+func (c *Client) LinkCustomerUser(user User) (Customer, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Customer{}, err
+	}
+
+	id, err := upsertCustomer(tx, CreateCustomerParams{
+		Name:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Email: user.Email,
+	})
+	if err != nil {
+		tx.Rollback()
+		return Customer{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE customers SET user_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id IS NULL`,
+		user.ID.String(), id.String())
+	if err != nil {
+		tx.Rollback()
+		return Customer{}, fmt.Errorf("couldn't link customer: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Customer{}, err
+	}
+
+	return c.GetCustomer(id)
+}
+
+func scanCustomer(row rowScanner) (Customer, error) {
+	var customer Customer
+	var id, created_at, updated_at string
+	var userID *string
+
+	err := row.Scan(&id, &created_at, &updated_at, &customer.Name, &customer.Email, &customer.Phone, &userID)
+	if err != nil {
+		return Customer{}, err
+	}
+
+	if customer.ID, err = uuid.Parse(id); err != nil {
+		return Customer{}, err
+	}
+	if customer.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+		return Customer{}, err
+	}
+	if customer.UpdatedAt, err = time.Parse(TIME_LAYOUT, updated_at); err != nil {
+		return Customer{}, err
+	}
+	if customer.UserID, err = parseUUIDPtr(userID); err != nil {
+		return Customer{}, err
+	}
+
+	return customer, nil
+}
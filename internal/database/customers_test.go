@@ -0,0 +1,112 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomers(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Description: "Milk and espresso", Cost: 450})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	itemID := items[0].ID
+
+	t.Run("Deduplicate by email", func(t *testing.T) {
+		first, err := c.UpsertCustomer(CreateCustomerParams{Name: "Ada", Email: "Ada@Example.com "})
+		require.NoError(t, err)
+		require.Equal(t, "ada@example.com", first.Email)
+
+		second, err := c.UpsertCustomer(CreateCustomerParams{Email: "ada@example.com", Phone: "(555) 0100"})
+		require.NoError(t, err)
+		require.Equal(t, first.ID, second.ID, "Same email should be the same customer")
+		require.Equal(t, "Ada", second.Name, "Blank name shouldn't replace the stored one")
+		require.Equal(t, "5550100", second.Phone)
+
+		byPhone, err := c.GetCustomerByPhone("555-0100")
+		require.NoError(t, err)
+		require.Equal(t, first.ID, byPhone.ID)
+
+		noEmail, err := c.UpsertCustomer(CreateCustomerParams{Name: "Walk-in", Phone: "555-0101"})
+		require.NoError(t, err)
+		require.NotEqual(t, first.ID, noEmail.ID)
+	})
+
+	t.Run("Orders are attached to customers", func(t *testing.T) {
+		orderID, err := c.CreateOrder(CreateOrderParams{
+			ForName:   "Grace",
+			ForEmail:  "GRACE@example.com",
+			OrderDate: "2025-01-01 12:00:00",
+			Status:    "pending",
+			Total:     "4.50",
+			Items:     []CreateOrderItemParams{{ItemID: itemID, Quantity: 1, Price: "4.50"}},
+		})
+		require.NoError(t, err)
+
+		customer, err := c.GetCustomerByEmail("grace@example.com")
+		require.NoError(t, err)
+		require.NotEqual(t, uuid.Nil, customer.ID, "Order should create a customer")
+
+		_, err = c.CreateOrder(CreateOrderParams{
+			CustomerID: &customer.ID,
+			OrderDate:  "2025-01-02 12:00:00",
+			Status:     "pending",
+			Total:      "0.00",
+		})
+		require.NoError(t, err)
+
+		orders, err := c.GetOrdersByCustomer(customer.ID)
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		require.Equal(t, "grace@example.com", orders[0].ForEmail, "Email should be filled from the customer")
+		require.Empty(t, orders[0].Items)
+		require.Equal(t, orderID, orders[1].ID)
+		require.Len(t, orders[1].Items, 1)
+		require.Equal(t, "Latte", orders[1].Items[0].ItemName)
+
+		anonymous, err := c.CreateOrder(CreateOrderParams{
+			ForName:   "Not Grace",
+			ForEmail:  "grace@example.com",
+			Status:    "pending",
+			Total:     "0.00",
+			Anonymous: true,
+		})
+		require.NoError(t, err)
+		order, err := c.GetOrder(anonymous)
+		require.NoError(t, err)
+		require.Nil(t, order.CustomerID, "Anonymous orders aren't matched by email")
+		unchanged, err := c.GetCustomer(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, customer.Name, unchanged.Name)
+
+		missing := uuid.New()
+		_, err = c.CreateOrder(CreateOrderParams{CustomerID: &missing, Status: "pending", Total: "0.00"})
+		require.ErrorIs(t, err, ErrCustomerNotFound)
+	})
+
+	t.Run("Link user account", func(t *testing.T) {
+		user, err := c.CreateUser(CreateUserParams{
+			Email:    "grace@example.com",
+			Password: "testpassword",
+			Role:     "customer",
+		})
+		require.NoError(t, err)
+
+		customer, err := c.LinkCustomerUser(user)
+		require.NoError(t, err)
+		require.NotNil(t, customer.UserID)
+		require.Equal(t, user.ID, *customer.UserID)
+
+		linked, err := c.GetCustomerByUser(user.ID)
+		require.NoError(t, err)
+		require.Equal(t, customer.ID, linked.ID)
+	})
+}
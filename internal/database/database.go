@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"strings"
+	"time"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
@@ -11,7 +13,8 @@ const (
 )
 
 type Client struct {
-	db *sql.DB
+	db          *sql.DB
+	businessDay BusinessDay
 }
 
 func NewClient(pathToDB string) (*Client, error) {
@@ -22,3 +25,21 @@ func NewClient(pathToDB string) (*Client, error) {
 
 	return &Client{db: db}, nil
 }
+
+// parseTimePtr parses a nullable TIME_LAYOUT column.
+func parseTimePtr(s *string) (*time.Time, error) {
+	if s == nil {
+		return nil, nil
+	}
+	t, err := time.Parse(TIME_LAYOUT, *s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// likeContains returns a LIKE pattern matching s anywhere, with its own % and _ taken literally. Use
+// it with ESCAPE '\'.
+func likeContains(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
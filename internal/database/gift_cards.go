@@ -0,0 +1,317 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chaeanthony/go-pos/internal/giftcard"
+	"github.com/google/uuid"
+)
+
+// Gift card kinds. Store credit works like a gift card but is issued from refunds rather than sold.
+const (
+	GiftCardKindGiftCard    = "gift_card"
+	GiftCardKindStoreCredit = "store_credit"
+)
+
+// Gift card ledger reasons.
+const (
+	GiftCardReasonIssue  = "issue"
+	GiftCardReasonReload = "reload"
+	GiftCardReasonRedeem = "redeem"
+	GiftCardReasonRefund = "refund" // a payment made with the card was returned to it
+)
+
+// GIFT_CARD_MAX_PIN_FAILURES locks a card after this many wrong PINs in a row.
+const GIFT_CARD_MAX_PIN_FAILURES = 5
+
+type GiftCard struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Number       string     `json:"number"`
+	Kind         string     `json:"kind"`
+	PINHash      string     `json:"-"`
+	PINFailures  int        `json:"-"`
+	BalanceCents int        `json:"balance_cents"`
+	CustomerID   *uuid.UUID `json:"customer_id"`
+}
+
+type CreateGiftCardParams struct {
+	Kind        string
+	PINHash     string // empty for cards without a PIN
+	CustomerID  *uuid.UUID
+	AmountCents int
+}
+
+type GiftCardEntry struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	GiftCardID   uuid.UUID `json:"gift_card_id"`
+	OrderID      *int      `json:"order_id"`
+	AmountCents  int       `json:"amount_cents"`
+	BalanceCents int       `json:"balance_cents"`
+	Reason       string    `json:"reason"`
+}
+
+var (
+	ErrGiftCardNotFound     = errors.New("gift card not found")
+	ErrGiftCardInsufficient = errors.New("gift card balance is too low")
+	ErrGiftCardLocked       = errors.New("gift card is locked after too many wrong PINs")
+)
+
+func (g GiftCard) HasPIN() bool {
+	return g.PINHash != ""
+}
+
+func (g GiftCard) Locked() bool {
+	return g.PINFailures >= GIFT_CARD_MAX_PIN_FAILURES
+}
+
+func (c *Client) CreateGiftCard(params CreateGiftCardParams) (GiftCard, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return GiftCard{}, err
+	}
+
+	id, err := issueGiftCard(tx, params, nil)
+	if err != nil {
+		tx.Rollback()
+		return GiftCard{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return GiftCard{}, err
+	}
+
+	return c.GetGiftCard(id)
+}
+
+// issueGiftCard creates a card with a new number and records its opening balance.
+func issueGiftCard(tx *sql.Tx, params CreateGiftCardParams, orderID *int) (uuid.UUID, error) {
+	if params.AmountCents < 0 {
+		return uuid.Nil, fmt.Errorf("invalid gift card amount %d", params.AmountCents)
+	}
+
+	number, err := giftcard.GenerateNumber()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	var customerID *string
+	if params.CustomerID != nil {
+		s := params.CustomerID.String()
+		customerID = &s
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO gift_cards (id, created_at, updated_at, number, kind, pin_hash, balance_cents, customer_id)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, NULLIF(?, ''), ?, ?)
+	`, id.String(), number, params.Kind, params.PINHash, params.AmountCents, customerID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't create gift card: %w", err)
+	}
+
+	err = insertGiftCardEntry(tx, id, orderID, params.AmountCents, params.AmountCents, GiftCardReasonIssue)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+const giftCardColumns = `
+	id, created_at, updated_at, number, kind, COALESCE(pin_hash, ''), pin_failures, balance_cents, customer_id
+`
+
+// GetGiftCard returns an empty GiftCard if none exists.
+func (c *Client) GetGiftCard(id uuid.UUID) (GiftCard, error) {
+	return c.getGiftCard(`id = ?`, id.String())
+}
+
+// GetGiftCardByNumber returns an empty GiftCard if none exists.
+func (c *Client) GetGiftCardByNumber(number string) (GiftCard, error) {
+	return c.getGiftCard(`number = ?`, number)
+}
+
+func (c *Client) getGiftCard(where string, args ...interface{}) (GiftCard, error) {
+	var card GiftCard
+	var id, created_at, updated_at string
+	var customerID *string
+
+	err := c.db.QueryRow(`SELECT `+giftCardColumns+` FROM gift_cards WHERE `+where, args...).
+		Scan(&id, &created_at, &updated_at, &card.Number, &card.Kind, &card.PINHash, &card.PINFailures, &card.BalanceCents, &customerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return GiftCard{}, nil
+	}
+	if err != nil {
+		return GiftCard{}, err
+	}
+
+	if card.ID, err = uuid.Parse(id); err != nil {
+		return GiftCard{}, err
+	}
+	if card.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+		return GiftCard{}, err
+	}
+	if card.UpdatedAt, err = time.Parse(TIME_LAYOUT, updated_at); err != nil {
+		return GiftCard{}, err
+	}
+	if card.CustomerID, err = parseUUIDPtr(customerID); err != nil {
+		return GiftCard{}, err
+	}
+
+	return card, nil
+}
+
+// ReloadGiftCard adds amountCents to a gift card. Store credit can't be reloaded.
+func (c *Client) ReloadGiftCard(id uuid.UUID, amountCents int) (GiftCard, error) {
+	if amountCents <= 0 {
+		return GiftCard{}, fmt.Errorf("invalid reload amount %d", amountCents)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return GiftCard{}, err
+	}
+
+	var balance int
+	err = tx.QueryRow(`
+		UPDATE gift_cards
+		SET balance_cents = balance_cents + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND kind = ?
+		RETURNING balance_cents
+	`, amountCents, id.String(), GiftCardKindGiftCard).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return GiftCard{}, ErrGiftCardNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return GiftCard{}, fmt.Errorf("couldn't reload gift card: %w", err)
+	}
+
+	if err := insertGiftCardEntry(tx, id, nil, amountCents, balance, GiftCardReasonReload); err != nil {
+		tx.Rollback()
+		return GiftCard{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return GiftCard{}, err
+	}
+
+	return c.GetGiftCard(id)
+}
+
+// CountGiftCardPINAttempt counts a PIN attempt as wrong before the PIN is checked, so concurrent
+// guesses can't get past the limit, and ResetGiftCardPINFailures clears it once the PIN is right.
+// Returns ErrGiftCardLocked if the card already had too many wrong PINs.
+func (c *Client) CountGiftCardPINAttempt(id uuid.UUID) error {
+	res, err := c.db.Exec(`UPDATE gift_cards SET pin_failures = pin_failures + 1 WHERE id = ? AND pin_failures < ?`,
+		id.String(), GIFT_CARD_MAX_PIN_FAILURES)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrGiftCardLocked
+	}
+	return nil
+}
+
+// ResetGiftCardPINFailures clears the wrong PIN count after a correct PIN, or unlocks a locked card.
+func (c *Client) ResetGiftCardPINFailures(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE gift_cards SET pin_failures = 0 WHERE id = ? AND pin_failures > 0`, id.String())
+	return err
+}
+
+// GetGiftCardLedger returns a card's most recent balance movements, newest first.
+func (c *Client) GetGiftCardLedger(id uuid.UUID, limit int) ([]GiftCardEntry, error) {
+	query := `
+		SELECT id, created_at, gift_card_id, order_id, amount_cents, balance_cents, reason
+		FROM gift_card_ledger
+		WHERE gift_card_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, id.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []GiftCardEntry{}
+	for rows.Next() {
+		var entry GiftCardEntry
+		var created_at, giftCardID string
+		if err := rows.Scan(&entry.ID, &created_at, &giftCardID, &entry.OrderID, &entry.AmountCents, &entry.BalanceCents, &entry.Reason); err != nil {
+			return nil, err
+		}
+		if entry.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		if entry.GiftCardID, err = uuid.Parse(giftCardID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// debitGiftCard takes amountCents off a card. The balance check is part of the UPDATE so two
+// payments racing for the same balance can't both succeed; the loser gets ErrGiftCardInsufficient.
+func debitGiftCard(tx *sql.Tx, id uuid.UUID, orderID int, amountCents int) error {
+	var balance int
+	err := tx.QueryRow(`
+		UPDATE gift_cards
+		SET balance_cents = balance_cents - ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND balance_cents >= ?
+		RETURNING balance_cents
+	`, amountCents, id.String(), amountCents).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrGiftCardInsufficient
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't debit gift card: %w", err)
+	}
+
+	return insertGiftCardEntry(tx, id, &orderID, -amountCents, balance, GiftCardReasonRedeem)
+}
+
+// creditGiftCard returns amountCents to a card, e.g. when an order paid with it is refunded.
+func creditGiftCard(tx *sql.Tx, id uuid.UUID, orderID int, amountCents int) error {
+	var balance int
+	err := tx.QueryRow(`
+		UPDATE gift_cards
+		SET balance_cents = balance_cents + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING balance_cents
+	`, amountCents, id.String()).Scan(&balance)
+	if err != nil {
+		return fmt.Errorf("couldn't credit gift card: %w", err)
+	}
+
+	return insertGiftCardEntry(tx, id, &orderID, amountCents, balance, GiftCardReasonRefund)
+}
+
+func insertGiftCardEntry(tx *sql.Tx, id uuid.UUID, orderID *int, amountCents, balanceCents int, reason string) error {
+	_, err := tx.Exec(`
+		INSERT INTO gift_card_ledger (created_at, gift_card_id, order_id, amount_cents, balance_cents, reason)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`, id.String(), orderID, amountCents, balanceCents, reason)
+	if err != nil {
+		return fmt.Errorf("couldn't record gift card movement: %w", err)
+	}
+	return nil
+}
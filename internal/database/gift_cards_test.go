@@ -0,0 +1,181 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/chaeanthony/go-pos/internal/giftcard"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGiftCards(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 450})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	latte := items[0].ID
+
+	newOrder := func(total string) int {
+		id, err := c.CreateOrder(CreateOrderParams{
+			ForName:   "Regular",
+			ForEmail:  "regular@example.com",
+			OrderDate: "2025-01-01 12:00:00",
+			Status:    "pending",
+			Total:     total,
+			Items:     []CreateOrderItemParams{{ItemID: latte, Quantity: 1, Price: total}},
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	card, err := c.CreateGiftCard(CreateGiftCardParams{Kind: GiftCardKindGiftCard, AmountCents: 1000})
+	require.NoError(t, err)
+	require.Equal(t, 1000, card.BalanceCents)
+	_, err = giftcard.Normalize(card.Number)
+	require.NoError(t, err, "Issued numbers should pass the check digit")
+
+	found, err := c.GetGiftCardByNumber(card.Number)
+	require.NoError(t, err)
+	require.Equal(t, card.ID, found.ID)
+
+	card, err = c.ReloadGiftCard(card.ID, 500)
+	require.NoError(t, err)
+	require.Equal(t, 1500, card.BalanceCents)
+
+	t.Run("Partial payment", func(t *testing.T) {
+		orderID := newOrder("20.00")
+
+		payment, err := c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderGiftCard, AmountCents: 2000, GiftCardID: &card.ID})
+		require.NoError(t, err)
+		require.Equal(t, 1500, payment.AmountCents, "Payment should be capped at the card's balance")
+
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderGiftCard, AmountCents: 500, GiftCardID: &card.ID})
+		require.ErrorIs(t, err, ErrGiftCardInsufficient)
+
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderCash, AmountCents: 1000})
+		require.NoError(t, err)
+
+		summary, err := c.GetPaymentSummary(orderID)
+		require.NoError(t, err)
+		require.Equal(t, 2000, summary.PaidCents)
+		require.Equal(t, 0, summary.DueCents)
+		require.Len(t, summary.Payments, 2)
+
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderCash, AmountCents: 100})
+		require.ErrorIs(t, err, ErrOrderAlreadyPaid)
+
+		t.Run("Refund with store credit", func(t *testing.T) {
+			credit, err := c.RefundOrder(RefundOrderParams{OrderID: orderID, StoreCredit: true})
+			require.NoError(t, err)
+			require.Equal(t, GiftCardKindStoreCredit, credit.Kind)
+			require.Equal(t, 500, credit.BalanceCents, "Only the cash part should become store credit")
+			require.NotNil(t, credit.CustomerID)
+
+			card, err = c.GetGiftCard(card.ID)
+			require.NoError(t, err)
+			require.Equal(t, 1500, card.BalanceCents, "Gift card payment should go back onto the card")
+
+			ledger, err := c.GetGiftCardLedger(card.ID, 10)
+			require.NoError(t, err)
+			require.Len(t, ledger, 4)
+			require.Equal(t, GiftCardReasonRefund, ledger[0].Reason)
+			require.Equal(t, 1500, ledger[0].BalanceCents)
+
+			_, err = c.RefundOrder(RefundOrderParams{OrderID: orderID})
+			require.ErrorIs(t, err, ErrOrderNotPayable)
+
+			_, err = c.ReloadGiftCard(credit.ID, 100)
+			require.ErrorIs(t, err, ErrGiftCardNotFound, "Store credit can't be reloaded")
+		})
+	})
+
+	t.Run("Store credit needs a payment", func(t *testing.T) {
+		orderID := newOrder("4.50")
+		_, err := c.RefundOrder(RefundOrderParams{OrderID: orderID, StoreCredit: true})
+		require.ErrorIs(t, err, ErrNothingToCredit)
+
+		order, err := c.GetOrder(orderID)
+		require.NoError(t, err)
+		require.Equal(t, OrderStatusPending, order.Status, "The refund should be rolled back")
+	})
+
+	t.Run("Reopening a voided order", func(t *testing.T) {
+		tenner, err := c.CreateGiftCard(CreateGiftCardParams{Kind: GiftCardKindGiftCard, AmountCents: 1000})
+		require.NoError(t, err)
+		orderID := newOrder("10.00")
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderGiftCard, AmountCents: 1000, GiftCardID: &tenner.ID})
+		require.NoError(t, err)
+
+		require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusVoided}))
+		require.ErrorIs(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusPending}), ErrOrderReversed)
+		require.ErrorIs(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusRefunded}), ErrOrderReversed)
+
+		payments, err := c.GetPayments(orderID)
+		require.NoError(t, err)
+		require.NotNil(t, payments[0].ReversedAt)
+
+		// orders reopened before voids were final
+		_, err = c.db.Exec(`UPDATE orders SET status = ? WHERE id = ?`, OrderStatusPending, orderID)
+		require.NoError(t, err)
+		require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusVoided}))
+		_, err = c.db.Exec(`UPDATE orders SET status = ? WHERE id = ?`, OrderStatusPending, orderID)
+		require.NoError(t, err)
+		_, err = c.RefundOrder(RefundOrderParams{OrderID: orderID, StoreCredit: true})
+		require.ErrorIs(t, err, ErrNothingToCredit, "Reversed payments shouldn't become store credit")
+
+		tenner, err = c.GetGiftCard(tenner.ID)
+		require.NoError(t, err)
+		require.Equal(t, 1000, tenner.BalanceCents, "The card should only get its payment back once")
+	})
+
+	t.Run("Tender must match card", func(t *testing.T) {
+		orderID := newOrder("1.00")
+		_, err := c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderStoreCredit, AmountCents: 100, GiftCardID: &card.ID})
+		require.ErrorIs(t, err, ErrInvalidTender)
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderGiftCard, AmountCents: 100})
+		require.ErrorIs(t, err, ErrInvalidTender)
+	})
+
+	t.Run("Double spend", func(t *testing.T) {
+		spent, err := c.CreateGiftCard(CreateGiftCardParams{Kind: GiftCardKindGiftCard, AmountCents: 300})
+		require.NoError(t, err)
+
+		first, second := newOrder("3.00"), newOrder("3.00")
+
+		tx, err := c.db.Begin()
+		require.NoError(t, err)
+		require.NoError(t, debitGiftCard(tx, spent.ID, first, 300))
+		require.ErrorIs(t, debitGiftCard(tx, spent.ID, second, 300), ErrGiftCardInsufficient)
+		require.NoError(t, tx.Rollback())
+	})
+
+	t.Run("PIN failures", func(t *testing.T) {
+		locked, err := c.CreateGiftCard(CreateGiftCardParams{Kind: GiftCardKindGiftCard, PINHash: "hash", AmountCents: 100})
+		require.NoError(t, err)
+		require.True(t, locked.HasPIN())
+
+		for range GIFT_CARD_MAX_PIN_FAILURES {
+			require.NoError(t, c.CountGiftCardPINAttempt(locked.ID))
+		}
+		require.ErrorIs(t, c.CountGiftCardPINAttempt(locked.ID), ErrGiftCardLocked)
+		locked, err = c.GetGiftCard(locked.ID)
+		require.NoError(t, err)
+		require.True(t, locked.Locked())
+		require.Equal(t, GIFT_CARD_MAX_PIN_FAILURES, locked.PINFailures, "Attempts on a locked card aren't counted")
+
+		require.NoError(t, c.ResetGiftCardPINFailures(locked.ID))
+		locked, err = c.GetGiftCard(locked.ID)
+		require.NoError(t, err)
+		require.False(t, locked.Locked())
+	})
+
+	missing, err := c.GetGiftCard(uuid.New())
+	require.NoError(t, err)
+	require.Equal(t, uuid.Nil, missing.ID)
+}
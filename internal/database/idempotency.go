@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it has finished, its response.
+type IdempotencyRecord struct {
+	Key          string
+	Scope        string
+	RequestHash  string
+	StatusCode   int // 0 while the request is still running
+	ContentType  string
+	ResponseBody []byte
+	ExpiresAt    time.Time
+}
+
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// ReserveIdempotencyKey claims key for a request. If it is new, or the previous use expired, the key
+// is reserved and reserved is true. Otherwise the existing record is returned for the caller to
+// compare and replay. Expired keys are cleaned up along the way.
+func (c *Client) ReserveIdempotencyKey(key, scope, requestHash string, ttl time.Duration) (existing IdempotencyRecord, reserved bool, err error) {
+	now := time.Now().UTC()
+	if _, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.Format(TIME_LAYOUT)); err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("couldn't clean up idempotency keys: %w", err)
+	}
+
+	res, err := c.db.Exec(`
+		INSERT INTO idempotency_keys (key, scope, created_at, expires_at, request_hash)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+		ON CONFLICT (key, scope) DO NOTHING
+	`, key, scope, now.Add(ttl).Format(TIME_LAYOUT), requestHash)
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("couldn't reserve idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return IdempotencyRecord{}, false, err
+	} else if n == 1 {
+		return IdempotencyRecord{}, true, nil
+	}
+
+	record := IdempotencyRecord{Key: key, Scope: scope}
+	var statusCode *int
+	var contentType *string
+	var expiresAt string
+	err = c.db.QueryRow(`
+		SELECT request_hash, status_code, content_type, response_body, expires_at
+		FROM idempotency_keys
+		WHERE key = ? AND scope = ?
+	`, key, scope).Scan(&record.RequestHash, &statusCode, &contentType, &record.ResponseBody, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released by the request that held it in the meantime
+		return c.ReserveIdempotencyKey(key, scope, requestHash, ttl)
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+	if contentType != nil {
+		record.ContentType = *contentType
+	}
+	if record.ExpiresAt, err = time.Parse(TIME_LAYOUT, expiresAt); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for later requests with the key.
+func (c *Client) CompleteIdempotencyKey(key, scope string, statusCode int, contentType string, body []byte) error {
+	_, err := c.db.Exec(`
+		UPDATE idempotency_keys
+		SET status_code = ?, content_type = ?, response_body = ?
+		WHERE key = ? AND scope = ?
+	`, statusCode, contentType, body, key, scope)
+	if err != nil {
+		return fmt.Errorf("couldn't save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a reserved key, e.g. after a server error, so the request can be retried.
+func (c *Client) ReleaseIdempotencyKey(key, scope string) error {
+	_, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND scope = ?`, key, scope)
+	return err
+}
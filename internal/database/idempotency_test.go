@@ -0,0 +1,61 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	const scope = "POST /api/orders"
+
+	_, reserved, err := c.ReserveIdempotencyKey("key-1", scope, "hash-a", time.Hour)
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	existing, reserved, err := c.ReserveIdempotencyKey("key-1", scope, "hash-a", time.Hour)
+	require.NoError(t, err)
+	require.False(t, reserved)
+	require.False(t, existing.Completed(), "First request is still running")
+
+	require.NoError(t, c.CompleteIdempotencyKey("key-1", scope, 201, "application/json", []byte(`{"id":"1"}`)))
+	existing, reserved, err = c.ReserveIdempotencyKey("key-1", scope, "hash-b", time.Hour)
+	require.NoError(t, err)
+	require.False(t, reserved)
+	require.Equal(t, "hash-a", existing.RequestHash, "Caller compares hashes to reject a different body")
+	require.Equal(t, 201, existing.StatusCode)
+	require.Equal(t, "application/json", existing.ContentType)
+	require.Equal(t, `{"id":"1"}`, string(existing.ResponseBody))
+
+	_, reserved, err = c.ReserveIdempotencyKey("key-1", "POST /api/orders/1/payments", "hash-a", time.Hour)
+	require.NoError(t, err)
+	require.True(t, reserved, "Keys are scoped to the route")
+
+	t.Run("Release", func(t *testing.T) {
+		_, reserved, err := c.ReserveIdempotencyKey("key-2", scope, "hash-a", time.Hour)
+		require.NoError(t, err)
+		require.True(t, reserved)
+		require.NoError(t, c.ReleaseIdempotencyKey("key-2", scope))
+
+		_, reserved, err = c.ReserveIdempotencyKey("key-2", scope, "hash-a", time.Hour)
+		require.NoError(t, err)
+		require.True(t, reserved, "Released key can be used again")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, reserved, err := c.ReserveIdempotencyKey("key-3", scope, "hash-a", -time.Minute)
+		require.NoError(t, err)
+		require.True(t, reserved)
+
+		_, reserved, err = c.ReserveIdempotencyKey("key-3", scope, "hash-b", time.Hour)
+		require.NoError(t, err)
+		require.True(t, reserved, "Expired key should be reusable")
+	})
+}
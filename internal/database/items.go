@@ -15,6 +15,8 @@ type CreateItemParams struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Cost        int    `json:"cost"`
+	Category    string `json:"category"`
+	Station     string `json:"station"` // kitchen station, overrides the category's. Empty uses the category's
 }
 
 type UpdateItemParams struct {
@@ -22,11 +24,13 @@ type UpdateItemParams struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Cost        int    `json:"cost"`
+	Category    string `json:"category"`
+	Station     string `json:"station"`
 }
 
 func (c *Client) GetItems() ([]Item, error) {
 	query := `
-	SELECT id, name, description, cost, created_at, updated_at FROM items
+	SELECT id, name, description, cost, category, COALESCE(station, ''), created_at, updated_at FROM items
 	`
 
 	rows, err := c.db.Query(query)
@@ -38,7 +42,7 @@ func (c *Client) GetItems() ([]Item, error) {
 	items := []Item{}
 	for rows.Next() {
 		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		if err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Category, &item.Station, &item.CreatedAt, &item.UpdatedAt); err != nil {
 			return nil, err
 		}
 		items = append(items, item)
@@ -53,11 +57,11 @@ func (c *Client) GetItems() ([]Item, error) {
 
 func (c *Client) GetItemByID(id string) (*Item, error) {
 	query := `
-	SELECT id, name, description, cost, created_at, updated_at FROM items WHERE id = ?
+	SELECT id, name, description, cost, category, COALESCE(station, ''), created_at, updated_at FROM items WHERE id = ?
 	`
 
 	var item Item
-	err := c.db.QueryRow(query, id).Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.CreatedAt, &item.UpdatedAt)
+	err := c.db.QueryRow(query, id).Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Category, &item.Station, &item.CreatedAt, &item.UpdatedAt)
 	if err != nil {
 		return nil, err // also err if error is sql.ErrNoRows
 	}
@@ -67,12 +71,12 @@ func (c *Client) GetItemByID(id string) (*Item, error) {
 
 func (c *Client) CreateItem(params CreateItemParams) (int64, error) {
 	query := `
-	INSERT INTO items (id, name, description, cost, created_at, updated_at)
-	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	INSERT INTO items (id, name, description, cost, category, station, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
 	id := uuid.NewString()
-	res, err := c.db.Exec(query, id, params.Name, params.Description, params.Cost)
+	res, err := c.db.Exec(query, id, params.Name, params.Description, params.Cost, params.Category, params.Station)
 	if err != nil {
 		return 0, err
 	}
@@ -82,11 +86,11 @@ func (c *Client) CreateItem(params CreateItemParams) (int64, error) {
 
 func (c *Client) UpdateItem(params UpdateItemParams) error {
 	query := `
-	UPDATE items SET name = ?, description = ?, cost = ?, updated_at = CURRENT_TIMESTAMP
+	UPDATE items SET name = ?, description = ?, cost = ?, category = ?, station = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(query, params.Name, params.Description, params.Cost, params.ID)
+	_, err := c.db.Exec(query, params.Name, params.Description, params.Cost, params.Category, params.Station, params.ID)
 
 	return err
 }
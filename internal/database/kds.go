@@ -0,0 +1,413 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Kitchen stations. Each order is split into a ticket per station that makes part of it.
+const (
+	StationBar      = "bar"
+	StationEspresso = "espresso"
+	StationHotFood  = "hot_food"
+	StationPastry   = "pastry"
+)
+
+var Stations = []string{StationBar, StationEspresso, StationHotFood, StationPastry}
+
+// DefaultStation makes items with no station of their own or for their category.
+const DefaultStation = StationHotFood
+
+// Station ticket statuses.
+const (
+	StationTicketOpen   = "open"
+	StationTicketBumped = "bumped"
+)
+
+type StationRoute struct {
+	Category string `json:"category"`
+	Station  string `json:"station"`
+}
+
+// StationTicket is the part of an order one station makes. Order only has the station's items.
+// CreatedAt is when the station received it.
+type StationTicket struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Station    string     `json:"station"`
+	Status     string     `json:"status"`
+	StartedAt  *time.Time `json:"started_at"`
+	BumpedAt   *time.Time `json:"bumped_at"`
+	RecalledAt *time.Time `json:"recalled_at"`
+	LateAt     *time.Time `json:"late_at"` // set once it has been open longer than the target prep time
+	Order      Order      `json:"order"`
+}
+
+var (
+	ErrInvalidStation        = errors.New("invalid station")
+	ErrStationTicketNotFound = errors.New("station ticket not found")
+)
+
+func ValidStation(station string) bool {
+	return slices.Contains(Stations, station)
+}
+
+// GetStationRoutes returns which station makes each category, by category.
+func (c *Client) GetStationRoutes() ([]StationRoute, error) {
+	rows, err := c.db.Query(`SELECT category, station FROM station_routes ORDER BY category`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []StationRoute{}
+	for rows.Next() {
+		var route StationRoute
+		if err := rows.Scan(&route.Category, &route.Station); err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// SetStationRoute sends items of a category to a station. Orders already in the kitchen keep their
+// stations.
+func (c *Client) SetStationRoute(route StationRoute) error {
+	route.Category = strings.TrimSpace(route.Category)
+	if route.Category == "" || !ValidStation(route.Station) {
+		return ErrInvalidStation
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO station_routes (category, station) VALUES (?, ?)
+		ON CONFLICT (category) DO UPDATE SET station = excluded.station
+	`, route.Category, route.Station)
+	return err
+}
+
+// DeleteStationRoute sends items of a category back to DefaultStation.
+func (c *Client) DeleteStationRoute(category string) error {
+	_, err := c.db.Exec(`DELETE FROM station_routes WHERE category = ?`, category)
+	return err
+}
+
+// routeOrder sends an order's items that haven't been sent yet to their stations and opens a ticket
+// on each. Scheduled orders aren't sent until they are released. Returns the stations sent items.
+func routeOrder(tx *sql.Tx, orderID int) ([]string, error) {
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM orders WHERE id = ?`, orderID).Scan(&status); err != nil {
+		return nil, err
+	}
+	if status == OrderStatusScheduled || isReversedStatus(status) {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`
+		UPDATE order_items
+		SET station = COALESCE(
+			(SELECT NULLIF(i.station, '') FROM items i WHERE i.id = order_items.item_id),
+			(SELECT r.station FROM items i JOIN station_routes r ON r.category = i.category WHERE i.id = order_items.item_id),
+			?
+		)
+		WHERE order_id = ? AND station IS NULL
+		RETURNING station
+	`, DefaultStation, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't route order items: %w", err)
+	}
+	stations := []string{}
+	for rows.Next() {
+		var station string
+		if err := rows.Scan(&station); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !slices.Contains(stations, station) {
+			stations = append(stations, station)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := openStationTickets(tx, orderID, stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+// openStationTickets opens a ticket for the order on each station, or reopens one it already bumped.
+// A reopened ticket is received again, so its prep time and lateness count from now rather than from
+// the first time round. A ready order is no longer ready.
+func openStationTickets(tx *sql.Tx, orderID int, stations []string) error {
+	if len(stations) == 0 {
+		return nil
+	}
+
+	for _, station := range stations {
+		_, err := tx.Exec(`
+			INSERT INTO station_tickets (created_at, order_id, station, status)
+			VALUES (CURRENT_TIMESTAMP, ?, ?, ?)
+			ON CONFLICT (order_id, station) DO UPDATE SET
+				created_at = CASE WHEN status = ? THEN excluded.created_at ELSE created_at END,
+				started_at = CASE WHEN status = ? THEN NULL ELSE started_at END,
+				late_at = CASE WHEN status = ? THEN NULL ELSE late_at END,
+				status = excluded.status,
+				bumped_at = NULL
+		`, orderID, station, StationTicketOpen, StationTicketBumped, StationTicketBumped, StationTicketBumped)
+		if err != nil {
+			return fmt.Errorf("couldn't open station ticket: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		OrderStatusPending, orderID, OrderStatusReady)
+	if err != nil {
+		return fmt.Errorf("failed to update order: %v", err)
+	}
+	return nil
+}
+
+// pruneStationTickets removes the tickets of stations that no longer have any of the order's items.
+func pruneStationTickets(tx *sql.Tx, orderID int) error {
+	_, err := tx.Exec(`
+		DELETE FROM station_tickets
+		WHERE order_id = ? AND NOT EXISTS (
+			SELECT 1 FROM order_items oi WHERE oi.order_id = station_tickets.order_id AND oi.station = station_tickets.station
+		)
+	`, orderID)
+	return err
+}
+
+// updateOrderReady marks an order ready once every station has bumped its ticket. Reports whether
+// the order became ready.
+func updateOrderReady(tx *sql.Tx, orderID int) (bool, error) {
+	var status string
+	var tickets, open int
+	err := tx.QueryRow(`
+		SELECT o.status,
+			(SELECT COUNT(*) FROM station_tickets t WHERE t.order_id = o.id),
+			(SELECT COUNT(*) FROM station_tickets t WHERE t.order_id = o.id AND t.status = ?)
+		FROM orders o
+		WHERE o.id = ?
+	`, StationTicketOpen, orderID).Scan(&status, &tickets, &open)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case OrderStatusScheduled, OrderStatusReady, OrderStatusCompleted, OrderStatusVoided, OrderStatusRefunded:
+		return false, nil
+	}
+	if tickets == 0 || open > 0 {
+		return false, nil
+	}
+	return true, setOrderStatus(tx, orderID, OrderStatusReady)
+}
+
+func setOrderStatus(tx *sql.Tx, orderID int, status string) error {
+	_, err := tx.Exec(`UPDATE orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, status, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update order: %v", err)
+	}
+	return nil
+}
+
+// BumpStationTicket marks a station's part of an order done. The order becomes ready once every
+// station has bumped its ticket, which is reported. Bumping a bumped ticket changes nothing.
+func (c *Client) BumpStationTicket(id int) (StationTicket, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return StationTicket{}, false, err
+	}
+
+	var orderID int
+	err = tx.QueryRow(`
+		UPDATE station_tickets
+		SET status = ?, bumped_at = COALESCE(bumped_at, CURRENT_TIMESTAMP)
+		WHERE id = ?
+		RETURNING order_id
+	`, StationTicketBumped, id).Scan(&orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return StationTicket{}, false, ErrStationTicketNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return StationTicket{}, false, fmt.Errorf("couldn't bump station ticket: %w", err)
+	}
+
+	ready, err := updateOrderReady(tx, orderID)
+	if err != nil {
+		tx.Rollback()
+		return StationTicket{}, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return StationTicket{}, false, err
+	}
+
+	ticket, err := c.GetStationTicket(id)
+	return ticket, ready, err
+}
+
+// StartStationTicket records when a station started making a ticket. Starting it again keeps the
+// first time.
+func (c *Client) StartStationTicket(id int) (StationTicket, error) {
+	res, err := c.db.Exec(`UPDATE station_tickets SET started_at = COALESCE(started_at, CURRENT_TIMESTAMP) WHERE id = ?`, id)
+	if err != nil {
+		return StationTicket{}, fmt.Errorf("couldn't start station ticket: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return StationTicket{}, err
+	} else if n == 0 {
+		return StationTicket{}, ErrStationTicketNotFound
+	}
+	return c.GetStationTicket(id)
+}
+
+// RecallStationTicket puts a bumped ticket back on its station's screen, e.g. when a drink has to be
+// remade. A ready order is no longer ready.
+func (c *Client) RecallStationTicket(id int) (StationTicket, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return StationTicket{}, err
+	}
+
+	var orderID int
+	var station string
+	err = tx.QueryRow(`
+		UPDATE station_tickets
+		SET recalled_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING order_id, station
+	`, id).Scan(&orderID, &station)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return StationTicket{}, ErrStationTicketNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return StationTicket{}, fmt.Errorf("couldn't recall station ticket: %w", err)
+	}
+
+	if err := openStationTickets(tx, orderID, []string{station}); err != nil {
+		tx.Rollback()
+		return StationTicket{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return StationTicket{}, err
+	}
+	return c.GetStationTicket(id)
+}
+
+// GetStationTicket returns an empty StationTicket if none exists.
+func (c *Client) GetStationTicket(id int) (StationTicket, error) {
+	tickets, err := c.queryStationTickets(`t.id = ?`, `t.id`, id)
+	if err != nil || len(tickets) == 0 {
+		return StationTicket{}, err
+	}
+	return tickets[0], nil
+}
+
+// GetStationTickets returns a station's tickets in status: open tickets oldest first, as the station
+// should make them, and bumped tickets most recently bumped first, for recalling. Tickets of
+// scheduled, voided and refunded orders aren't listed.
+func (c *Client) GetStationTickets(station, status string, limit int) ([]StationTicket, error) {
+	orderBy := `t.created_at, t.id`
+	if status == StationTicketBumped {
+		orderBy = `t.bumped_at DESC, t.id DESC`
+	}
+	where := `t.station = ? AND t.status = ? AND o.status NOT IN (?, ?, ?)`
+	return c.queryStationTickets(where, orderBy+` LIMIT ?`, station, status,
+		OrderStatusScheduled, OrderStatusVoided, OrderStatusRefunded, limit)
+}
+
+// GetOrderStationTickets returns every station's ticket for an order.
+func (c *Client) GetOrderStationTickets(orderID int) ([]StationTicket, error) {
+	return c.queryStationTickets(`t.order_id = ?`, `t.station`, orderID)
+}
+
+func (c *Client) queryStationTickets(where, orderBy string, args ...interface{}) ([]StationTicket, error) {
+	rows, err := c.db.Query(`
+		SELECT t.id, t.created_at, t.order_id, t.station, t.status, t.started_at, t.bumped_at, t.recalled_at, t.late_at
+		FROM station_tickets t
+		JOIN orders o ON o.id = t.order_id
+		WHERE `+where+`
+		ORDER BY `+orderBy, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tickets := []StationTicket{}
+	orderIDs := []interface{}{}
+	for rows.Next() {
+		var ticket StationTicket
+		var created_at string
+		var startedAt, bumpedAt, recalledAt, lateAt *string
+		if err := rows.Scan(&ticket.ID, &created_at, &ticket.Order.ID, &ticket.Station, &ticket.Status, &startedAt, &bumpedAt, &recalledAt, &lateAt); err != nil {
+			return nil, err
+		}
+		if ticket.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		if ticket.StartedAt, err = parseTimePtr(startedAt); err != nil {
+			return nil, err
+		}
+		if ticket.BumpedAt, err = parseTimePtr(bumpedAt); err != nil {
+			return nil, err
+		}
+		if ticket.RecalledAt, err = parseTimePtr(recalledAt); err != nil {
+			return nil, err
+		}
+		if ticket.LateAt, err = parseTimePtr(lateAt); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+		orderIDs = append(orderIDs, ticket.Order.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(tickets) == 0 {
+		return tickets, nil
+	}
+
+	orders, err := c.queryOrders(`o.id IN (`+placeholders(len(orderIDs))+`)`, `o.id`, orderIDs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.loadOrderItems(orders); err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Order, len(orders))
+	for _, order := range orders {
+		byID[order.ID] = order
+	}
+
+	for i := range tickets {
+		order := byID[tickets[i].Order.ID]
+		items := []OrderItem{}
+		for _, item := range order.Items {
+			if item.Station == tickets[i].Station {
+				items = append(items, item)
+			}
+		}
+		order.Items = items
+		tickets[i].Order = order
+	}
+	return tickets, nil
+}
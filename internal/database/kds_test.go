@@ -0,0 +1,102 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStationTickets(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 450, Category: "coffee"})
+	require.NoError(t, err)
+	_, err = c.CreateItem(CreateItemParams{Name: "Affogato", Cost: 550, Category: "coffee", Station: StationPastry})
+	require.NoError(t, err)
+	_, err = c.CreateItem(CreateItemParams{Name: "Toastie", Cost: 800, Category: "food"})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	ids := map[string]string{}
+	for _, item := range items {
+		ids[item.Name] = item.ID
+	}
+
+	require.ErrorIs(t, c.SetStationRoute(StationRoute{Category: "coffee", Station: "grill"}), ErrInvalidStation)
+	require.NoError(t, c.SetStationRoute(StationRoute{Category: "coffee", Station: StationEspresso}))
+
+	orderID, err := c.CreateOrder(CreateOrderParams{
+		ForName: "Ada",
+		Status:  "pending",
+		Total:   "18.00",
+		Items: []CreateOrderItemParams{
+			{ItemID: ids["Latte"], Quantity: 1, Price: "4.50"},
+			{ItemID: ids["Affogato"], Quantity: 1, Price: "5.50"},
+			{ItemID: ids["Toastie"], Quantity: 1, Price: "8.00"},
+		},
+	})
+	require.NoError(t, err)
+
+	tickets, err := c.GetOrderStationTickets(orderID)
+	require.NoError(t, err)
+	byStation := map[string]StationTicket{}
+	for _, ticket := range tickets {
+		byStation[ticket.Station] = ticket
+	}
+	require.Len(t, byStation, 3)
+	require.Equal(t, "Latte", byStation[StationEspresso].Order.Items[0].ItemName, "routed by category")
+	require.Equal(t, "Affogato", byStation[StationPastry].Order.Items[0].ItemName, "item's own station wins")
+	require.Equal(t, "Toastie", byStation[DefaultStation].Order.Items[0].ItemName, "unrouted categories go to the default")
+
+	open, err := c.GetStationTickets(StationEspresso, StationTicketOpen, 10)
+	require.NoError(t, err)
+	require.Len(t, open, 1)
+	require.Len(t, open[0].Order.Items, 1, "stations only see their own items")
+
+	_, ready, err := c.BumpStationTicket(byStation[StationEspresso].ID)
+	require.NoError(t, err)
+	require.False(t, ready)
+	_, ready, err = c.BumpStationTicket(byStation[StationPastry].ID)
+	require.NoError(t, err)
+	require.False(t, ready)
+
+	// removing the last open station's only item leaves every ticket bumped
+	_, err = c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: byStation[DefaultStation].Order.Items[0].ID})
+	require.NoError(t, err)
+	order, err := c.GetOrder(orderID)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusReady, order.Status)
+	tickets, err = c.GetOrderStationTickets(orderID)
+	require.NoError(t, err)
+	require.Len(t, tickets, 2)
+
+	ticket, err := c.RecallStationTicket(byStation[StationEspresso].ID)
+	require.NoError(t, err)
+	require.Equal(t, StationTicketOpen, ticket.Status)
+	require.NotNil(t, ticket.RecalledAt)
+	order, err = c.GetOrder(orderID)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusPending, order.Status, "recalling a ticket takes the order back from ready")
+
+	_, ready, err = c.BumpStationTicket(byStation[StationEspresso].ID)
+	require.NoError(t, err)
+	require.True(t, ready)
+
+	change, err := c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: ids["Affogato"], Quantity: 1})
+	require.NoError(t, err)
+	require.Equal(t, StationPastry, change.Station)
+	ticket, err = c.GetStationTicket(byStation[StationPastry].ID)
+	require.NoError(t, err)
+	require.Equal(t, StationTicketOpen, ticket.Status, "adding an item reopens its station's ticket")
+	require.Len(t, ticket.Order.Items, 2)
+	order, err = c.GetOrder(orderID)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusPending, order.Status)
+
+	_, _, err = c.BumpStationTicket(999)
+	require.ErrorIs(t, err, ErrStationTicketNotFound)
+}
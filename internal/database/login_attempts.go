@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LoginReasonSuccess         = "success"
+	LoginReasonUnknownUser     = "unknown_user"
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonInvalidMFA      = "invalid_mfa" // correct password but wrong two-factor code
+	LoginReasonThrottled       = "throttled"   // rejected before the password was checked
+)
+
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateLoginAttemptParams
+}
+
+type CreateLoginAttemptParams struct {
+	Email     string     `json:"email"`
+	UserID    *uuid.UUID `json:"user_id"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	Success   bool       `json:"success"`
+	Reason    string     `json:"reason"`
+}
+
+// LoginFailures summarizes recent failed password and two-factor checks for an account or IP.
+type LoginFailures struct {
+	Count  int
+	LastAt time.Time
+}
+
+func (c *Client) CreateLoginAttempt(params CreateLoginAttemptParams) error {
+	query := `
+		INSERT INTO login_attempts (created_at, email, user_id, ip_address, user_agent, success, reason)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	var userID *string
+	if params.UserID != nil {
+		id := params.UserID.String()
+		userID = &id
+	}
+
+	_, err := c.db.Exec(query, params.Email, userID, params.IPAddress, params.UserAgent, params.Success, params.Reason)
+	if err != nil {
+		return fmt.Errorf("couldn't create login attempt: %w", err)
+	}
+	return nil
+}
+
+// GetLoginFailuresByEmail counts failures for email since the later of since and its last successful login.
+func (c *Client) GetLoginFailuresByEmail(email string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(created_at), '')
+		FROM login_attempts
+		WHERE email = ?
+			AND success = 0
+			AND reason != ?
+			AND created_at > ?
+			AND created_at > COALESCE(
+				(SELECT MAX(created_at) FROM login_attempts WHERE email = ? AND success = 1), ''
+			)
+	`
+	return c.queryLoginFailures(query, email, LoginReasonThrottled, since.UTC().Format(TIME_LAYOUT), email)
+}
+
+// GetLoginFailuresByIP counts failures from ip since since. Successful logins don't reset it.
+func (c *Client) GetLoginFailuresByIP(ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(created_at), '')
+		FROM login_attempts
+		WHERE ip_address = ?
+			AND success = 0
+			AND reason != ?
+			AND created_at > ?
+	`
+	return c.queryLoginFailures(query, ip, LoginReasonThrottled, since.UTC().Format(TIME_LAYOUT))
+}
+
+func (c *Client) queryLoginFailures(query string, args ...interface{}) (LoginFailures, error) {
+	var failures LoginFailures
+	var lastAt string
+	err := c.db.QueryRow(query, args...).Scan(&failures.Count, &lastAt)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+	if lastAt != "" {
+		failures.LastAt, err = time.Parse(TIME_LAYOUT, lastAt)
+		if err != nil {
+			return LoginFailures{}, fmt.Errorf("couldn't parse created_at: %w", err)
+		}
+	}
+	return failures, nil
+}
+
+// GetFailedLoginAttempts returns the most recent failed attempts, optionally filtered by email.
+func (c *Client) GetFailedLoginAttempts(email string, limit int) ([]LoginAttempt, error) {
+	query := `
+		SELECT id, created_at, email, user_id, ip_address, COALESCE(user_agent, ''), success, COALESCE(reason, '')
+		FROM login_attempts
+		WHERE success = 0 AND (? = '' OR email = ?)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, email, email, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		var created_at string
+		var userID *string
+		if err := rows.Scan(&a.ID, &created_at, &a.Email, &userID, &a.IPAddress, &a.UserAgent, &a.Success, &a.Reason); err != nil {
+			return nil, err
+		}
+		a.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at)
+		if err != nil {
+			return nil, err
+		}
+		if userID != nil {
+			id, err := uuid.Parse(*userID)
+			if err != nil {
+				return nil, err
+			}
+			a.UserID = &id
+		}
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	since := time.Now().Add(-time.Hour)
+	fail := func(email, ip, reason string) {
+		err := c.CreateLoginAttempt(CreateLoginAttemptParams{Email: email, IPAddress: ip, Reason: reason})
+		require.NoError(t, err, "Failed to record login attempt")
+	}
+
+	t.Run("Count failures", func(t *testing.T) {
+		fail("a@test.com", "10.0.0.1", LoginReasonInvalidPassword)
+		fail("a@test.com", "10.0.0.1", LoginReasonInvalidMFA)
+		fail("a@test.com", "10.0.0.1", LoginReasonThrottled)
+		fail("nobody@test.com", "10.0.0.1", LoginReasonUnknownUser)
+
+		byEmail, err := c.GetLoginFailuresByEmail("a@test.com", since)
+		require.NoError(t, err)
+		require.Equal(t, 2, byEmail.Count, "Two-factor failures should count and throttled attempts should not")
+		require.False(t, byEmail.LastAt.IsZero())
+
+		byIP, err := c.GetLoginFailuresByIP("10.0.0.1", since)
+		require.NoError(t, err)
+		require.Equal(t, 3, byIP.Count)
+	})
+
+	t.Run("Success resets account failures", func(t *testing.T) {
+		// created_at has second precision, so make sure the success is strictly later
+		time.Sleep(1100 * time.Millisecond)
+		err := c.CreateLoginAttempt(CreateLoginAttemptParams{Email: "a@test.com", IPAddress: "10.0.0.1", Success: true, Reason: LoginReasonSuccess})
+		require.NoError(t, err)
+
+		byEmail, err := c.GetLoginFailuresByEmail("a@test.com", since)
+		require.NoError(t, err)
+		require.Equal(t, 0, byEmail.Count)
+
+		byIP, err := c.GetLoginFailuresByIP("10.0.0.1", since)
+		require.NoError(t, err)
+		require.Equal(t, 3, byIP.Count, "IP failures should not reset on success")
+	})
+
+	t.Run("List failed attempts", func(t *testing.T) {
+		attempts, err := c.GetFailedLoginAttempts("", 10)
+		require.NoError(t, err)
+		require.Len(t, attempts, 4)
+
+		attempts, err = c.GetFailedLoginAttempts("nobody@test.com", 10)
+		require.NoError(t, err)
+		require.Len(t, attempts, 1)
+		require.Equal(t, LoginReasonUnknownUser, attempts[0].Reason)
+	})
+}
@@ -0,0 +1,466 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Loyalty rule kinds.
+const (
+	LoyaltyEarnSpend      = "earn_spend"      // Points per whole currency unit of the order total
+	LoyaltyEarnItem       = "earn_item"       // Points per unit of ItemID ordered
+	LoyaltyRedeemDiscount = "redeem_discount" // Points buy AmountCents off the order
+	LoyaltyRedeemItem     = "redeem_item"     // Points buy one ItemID free
+)
+
+// Loyalty ledger reasons.
+const (
+	LoyaltyReasonEarn       = "earn"
+	LoyaltyReasonRedeem     = "redeem"
+	LoyaltyReasonReversal   = "reversal"   // order refunded or voided
+	LoyaltyReasonAdjustment = "adjustment" // order items changed after it was placed
+)
+
+type LoyaltyRule struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Active    bool      `json:"active"`
+	CreateLoyaltyRuleParams
+}
+
+type CreateLoyaltyRuleParams struct {
+	Kind        string `json:"kind"`
+	Points      int    `json:"points"`
+	ItemID      string `json:"item_id,omitempty"`
+	AmountCents int    `json:"amount_cents,omitempty"`
+}
+
+type LoyaltyEntry struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	CustomerID uuid.UUID `json:"customer_id"`
+	OrderID    *int      `json:"order_id"`
+	RuleID     *int      `json:"rule_id"`
+	Points     int       `json:"points"`
+	Reason     string    `json:"reason"`
+}
+
+var (
+	ErrLoyaltyRuleInvalid      = errors.New("loyalty rule is invalid")
+	ErrLoyaltyNoCustomer       = errors.New("order has no customer to redeem points for")
+	ErrLoyaltyInsufficient     = errors.New("not enough loyalty points")
+	ErrLoyaltyRewardNotInOrder = errors.New("reward item isn't in the order")
+	ErrInvalidOrderTotal       = errors.New("invalid order total")
+)
+
+// Validate checks that the fields required by the rule's kind are set.
+func (p CreateLoyaltyRuleParams) Validate() error {
+	if p.Points <= 0 {
+		return fmt.Errorf("%w: points must be positive", ErrLoyaltyRuleInvalid)
+	}
+	switch p.Kind {
+	case LoyaltyEarnSpend:
+		return nil
+	case LoyaltyEarnItem, LoyaltyRedeemItem:
+		if p.ItemID == "" {
+			return fmt.Errorf("%w: %s needs an item_id", ErrLoyaltyRuleInvalid, p.Kind)
+		}
+		return nil
+	case LoyaltyRedeemDiscount:
+		if p.AmountCents <= 0 {
+			return fmt.Errorf("%w: %s needs a positive amount_cents", ErrLoyaltyRuleInvalid, p.Kind)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrLoyaltyRuleInvalid, p.Kind)
+	}
+}
+
+func (c *Client) CreateLoyaltyRule(params CreateLoyaltyRuleParams) (LoyaltyRule, error) {
+	if err := params.Validate(); err != nil {
+		return LoyaltyRule{}, err
+	}
+
+	query := `
+		INSERT INTO loyalty_rules (created_at, kind, points, item_id, amount_cents, active)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, NULLIF(?, ''), ?, 1)
+		RETURNING id
+	`
+	var id int
+	err := c.db.QueryRow(query, params.Kind, params.Points, params.ItemID, params.AmountCents).Scan(&id)
+	if err != nil {
+		return LoyaltyRule{}, fmt.Errorf("couldn't create loyalty rule: %w", err)
+	}
+
+	return c.GetLoyaltyRule(id)
+}
+
+const loyaltyRuleColumns = `id, created_at, kind, points, COALESCE(item_id, ''), amount_cents, active`
+
+// GetLoyaltyRules lists active rules.
+func (c *Client) GetLoyaltyRules() ([]LoyaltyRule, error) {
+	return queryLoyaltyRules(c.db, `SELECT `+loyaltyRuleColumns+` FROM loyalty_rules WHERE active = 1 ORDER BY id`)
+}
+
+// GetLoyaltyRule returns an empty LoyaltyRule if none exists.
+func (c *Client) GetLoyaltyRule(id int) (LoyaltyRule, error) {
+	rules, err := queryLoyaltyRules(c.db, `SELECT `+loyaltyRuleColumns+` FROM loyalty_rules WHERE id = ?`, id)
+	if err != nil || len(rules) == 0 {
+		return LoyaltyRule{}, err
+	}
+	return rules[0], nil
+}
+
+// DeactivateLoyaltyRule stops a rule from applying to new orders. Ledger entries keep referring to it.
+func (c *Client) DeactivateLoyaltyRule(id int) error {
+	_, err := c.db.Exec(`UPDATE loyalty_rules SET active = 0 WHERE id = ?`, id)
+	return err
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryLoyaltyRules(q querier, query string, args ...interface{}) ([]LoyaltyRule, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []LoyaltyRule{}
+	for rows.Next() {
+		var rule LoyaltyRule
+		var created_at string
+		if err := rows.Scan(&rule.ID, &created_at, &rule.Kind, &rule.Points, &rule.ItemID, &rule.AmountCents, &rule.Active); err != nil {
+			return nil, err
+		}
+		if rule.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// GetLoyaltyBalance returns a customer's current points.
+func (c *Client) GetLoyaltyBalance(customerID uuid.UUID) (int, error) {
+	return loyaltyBalance(c.db, customerID)
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func loyaltyBalance(q rowQuerier, customerID uuid.UUID) (int, error) {
+	var balance int
+	err := q.QueryRow(`SELECT COALESCE(SUM(points), 0) FROM loyalty_ledger WHERE customer_id = ?`, customerID.String()).Scan(&balance)
+	return balance, err
+}
+
+// GetLoyaltyLedger returns a customer's most recent ledger entries, newest first.
+func (c *Client) GetLoyaltyLedger(customerID uuid.UUID, limit int) ([]LoyaltyEntry, error) {
+	query := `
+		SELECT id, created_at, customer_id, order_id, rule_id, points, reason
+		FROM loyalty_ledger
+		WHERE customer_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, customerID.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LoyaltyEntry{}
+	for rows.Next() {
+		var entry LoyaltyEntry
+		var created_at, customerID string
+		if err := rows.Scan(&entry.ID, &created_at, &customerID, &entry.OrderID, &entry.RuleID, &entry.Points, &entry.Reason); err != nil {
+			return nil, err
+		}
+		if entry.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		if entry.CustomerID, err = uuid.Parse(customerID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// pendingLoyaltyEntry is a ledger entry worked out before its order has an ID.
+type pendingLoyaltyEntry struct {
+	ruleID *int
+	points int
+	reason string
+}
+
+// applyOrderLoyalty redeems order.RedeemRuleID, lowering order.Total by the reward, and works out the
+// points earned on what is left to pay. The entries are written by insertLoyaltyEntries once the
+// order exists, in the same transaction.
+func applyOrderLoyalty(tx *sql.Tx, customerID *string, order *CreateOrderParams) ([]pendingLoyaltyEntry, error) {
+	if customerID == nil {
+		if order.RedeemRuleID != 0 {
+			return nil, ErrLoyaltyNoCustomer
+		}
+		return nil, nil
+	}
+	customer, err := uuid.Parse(*customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := decimal.NewFromString(order.Total)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOrderTotal, order.Total)
+	}
+
+	rules, err := queryLoyaltyRules(tx, `SELECT `+loyaltyRuleColumns+` FROM loyalty_rules WHERE active = 1 ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Points are earned on menu prices, never more than the order's total, so clients can't earn
+	// extra by sending higher prices or totals.
+	prices, err := menuPrices(tx, order.Items)
+	if err != nil {
+		return nil, err
+	}
+	subtotal := menuSubtotal(prices, order.Items)
+
+	entries := []pendingLoyaltyEntry{}
+
+	if order.RedeemRuleID != 0 {
+		var rule *LoyaltyRule
+		for i := range rules {
+			if rules[i].ID == order.RedeemRuleID {
+				rule = &rules[i]
+			}
+		}
+		if rule == nil || (rule.Kind != LoyaltyRedeemDiscount && rule.Kind != LoyaltyRedeemItem) {
+			return nil, fmt.Errorf("%w: %d isn't an active reward", ErrLoyaltyRuleInvalid, order.RedeemRuleID)
+		}
+
+		balance, err := loyaltyBalance(tx, customer)
+		if err != nil {
+			return nil, err
+		}
+		if balance < rule.Points {
+			return nil, ErrLoyaltyInsufficient
+		}
+
+		discount := decimal.New(int64(rule.AmountCents), -2)
+		if rule.Kind == LoyaltyRedeemItem {
+			if !hasOrderItem(order.Items, rule.ItemID) {
+				return nil, ErrLoyaltyRewardNotInOrder
+			}
+			discount = prices[rule.ItemID]
+		}
+		total = decimal.Max(total.Sub(discount), decimal.Zero)
+		subtotal = decimal.Max(subtotal.Sub(discount), decimal.Zero)
+		order.Total = total.StringFixed(2)
+
+		entries = append(entries, pendingLoyaltyEntry{ruleID: &rule.ID, points: -rule.Points, reason: LoyaltyReasonRedeem})
+	}
+
+	return append(entries, earnLoyaltyEntries(rules, decimal.Min(total, subtotal), order.Items)...), nil
+}
+
+// earnLoyaltyEntries works out the points the active earn rules give for spending spend on items.
+func earnLoyaltyEntries(rules []LoyaltyRule, spend decimal.Decimal, items []CreateOrderItemParams) []pendingLoyaltyEntry {
+	entries := []pendingLoyaltyEntry{}
+	for i := range rules {
+		rule := rules[i]
+		points := 0
+		switch rule.Kind {
+		case LoyaltyEarnSpend:
+			points = int(spend.IntPart()) * rule.Points
+		case LoyaltyEarnItem:
+			for _, item := range items {
+				if item.ItemID == rule.ItemID {
+					points += item.Quantity * rule.Points
+				}
+			}
+		}
+		if points > 0 {
+			entries = append(entries, pendingLoyaltyEntry{ruleID: &rule.ID, points: points, reason: LoyaltyReasonEarn})
+		}
+	}
+	return entries
+}
+
+func hasOrderItem(items []CreateOrderItemParams, itemID string) bool {
+	for _, item := range items {
+		if item.ItemID == itemID && item.Quantity > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// menuPrices returns the menu price of each item in items. Items no longer on the menu are left out.
+func menuPrices(tx *sql.Tx, items []CreateOrderItemParams) (map[string]decimal.Decimal, error) {
+	prices := map[string]decimal.Decimal{}
+	if len(items) == 0 {
+		return prices, nil
+	}
+	ids := make([]interface{}, len(items))
+	for i, item := range items {
+		ids[i] = item.ItemID
+	}
+
+	rows, err := tx.Query(`SELECT id, COALESCE(cost, 0) FROM items WHERE id IN (`+placeholders(len(ids))+`)`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var cost int64
+		if err := rows.Scan(&id, &cost); err != nil {
+			return nil, err
+		}
+		prices[id] = decimal.New(cost, -2)
+	}
+	return prices, rows.Err()
+}
+
+// adjustOrderLoyalty works out the points an order earns again after its items changed and records
+// the difference from what it earned so far as an adjustment. Entries already in the ledger are
+// never changed, and redeemed points stay as they are.
+func adjustOrderLoyalty(tx *sql.Tx, orderID int) error {
+	var customerID *string
+	var total, deliveryFee string
+	err := tx.QueryRow(`SELECT customer_id, total, COALESCE(delivery_fee, '0') FROM orders WHERE id = ?`, orderID).
+		Scan(&customerID, &total, &deliveryFee)
+	if err != nil {
+		return err
+	}
+	if customerID == nil {
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT item_id, quantity FROM order_items WHERE order_id = ?`, orderID)
+	if err != nil {
+		return err
+	}
+	items := []CreateOrderItemParams{}
+	for rows.Next() {
+		var item CreateOrderItemParams
+		if err := rows.Scan(&item.ItemID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rules, err := queryLoyaltyRules(tx, `SELECT `+loyaltyRuleColumns+` FROM loyalty_rules WHERE active = 1 ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	prices, err := menuPrices(tx, items)
+	if err != nil {
+		return err
+	}
+	subtotal := menuSubtotal(prices, items)
+	// the delivery fee doesn't earn points, like when the order was placed
+	spend, err := decimal.NewFromString(total)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidOrderTotal, total)
+	}
+	if fee, err := decimal.NewFromString(deliveryFee); err == nil {
+		spend = spend.Sub(fee)
+	}
+
+	earned := 0
+	for _, entry := range earnLoyaltyEntries(rules, decimal.Min(spend, subtotal), items) {
+		earned += entry.points
+	}
+	var earnedBefore int
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(points), 0) FROM loyalty_ledger WHERE order_id = ? AND reason IN (?, ?)
+	`, orderID, LoyaltyReasonEarn, LoyaltyReasonAdjustment).Scan(&earnedBefore)
+	if err != nil {
+		return err
+	}
+	if earned == earnedBefore {
+		return nil
+	}
+	return insertLoyaltyEntries(tx, customerID, orderID, []pendingLoyaltyEntry{{points: earned - earnedBefore, reason: LoyaltyReasonAdjustment}})
+}
+
+// isRedeemedItemLine reports whether lineID holds the last of an item the order redeemed a free item
+// reward for.
+func isRedeemedItemLine(tx *sql.Tx, orderID, lineID int, itemID string) (bool, error) {
+	var redeemed bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM loyalty_ledger l JOIN loyalty_rules r ON l.rule_id = r.id
+			WHERE l.order_id = ? AND l.reason = ? AND r.kind = ? AND r.item_id = ?
+		) AND NOT EXISTS (
+			SELECT 1 FROM order_items WHERE order_id = ? AND item_id = ? AND id != ?
+		)
+	`, orderID, LoyaltyReasonRedeem, LoyaltyRedeemItem, itemID, orderID, itemID, lineID).Scan(&redeemed)
+	return redeemed, err
+}
+
+func menuSubtotal(prices map[string]decimal.Decimal, items []CreateOrderItemParams) decimal.Decimal {
+	subtotal := decimal.Zero
+	for _, item := range items {
+		subtotal = subtotal.Add(prices[item.ItemID].Mul(decimal.NewFromInt(int64(item.Quantity))))
+	}
+	return subtotal
+}
+
+func insertLoyaltyEntries(tx *sql.Tx, customerID *string, orderID int, entries []pendingLoyaltyEntry) error {
+	for _, entry := range entries {
+		_, err := tx.Exec(`
+			INSERT INTO loyalty_ledger (created_at, customer_id, order_id, rule_id, points, reason)
+			VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+		`, *customerID, orderID, entry.ruleID, entry.points, entry.reason)
+		if err != nil {
+			return fmt.Errorf("couldn't record loyalty points: %w", err)
+		}
+	}
+	return nil
+}
+
+// reverseOrderLoyalty undoes everything an order did to its customer's points, so points earned are
+// taken back and points redeemed are returned. The balance can go negative if earned points were
+// already spent.
+func reverseOrderLoyalty(tx *sql.Tx, orderID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO loyalty_ledger (created_at, customer_id, order_id, points, reason)
+		SELECT CURRENT_TIMESTAMP, customer_id, order_id, -SUM(points), ?
+		FROM loyalty_ledger
+		WHERE order_id = ?
+		GROUP BY customer_id, order_id
+		HAVING SUM(points) != 0
+	`, LoyaltyReasonReversal, orderID)
+	if err != nil {
+		return fmt.Errorf("couldn't reverse loyalty points: %w", err)
+	}
+	return nil
+}
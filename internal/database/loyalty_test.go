@@ -0,0 +1,171 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoyalty(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 1000})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	latte := items[0].ID
+
+	_, err = c.CreateLoyaltyRule(CreateLoyaltyRuleParams{Kind: LoyaltyEarnSpend, Points: 1})
+	require.NoError(t, err)
+	_, err = c.CreateLoyaltyRule(CreateLoyaltyRuleParams{Kind: LoyaltyEarnItem, Points: 5, ItemID: latte})
+	require.NoError(t, err)
+	discount, err := c.CreateLoyaltyRule(CreateLoyaltyRuleParams{Kind: LoyaltyRedeemDiscount, Points: 10, AmountCents: 200})
+	require.NoError(t, err)
+	freeLatte, err := c.CreateLoyaltyRule(CreateLoyaltyRuleParams{Kind: LoyaltyRedeemItem, Points: 20, ItemID: latte})
+	require.NoError(t, err)
+
+	_, err = c.CreateLoyaltyRule(CreateLoyaltyRuleParams{Kind: LoyaltyRedeemDiscount, Points: 10})
+	require.ErrorIs(t, err, ErrLoyaltyRuleInvalid, "Discount without an amount should be rejected")
+
+	newOrder := func(total string, redeem int) (int, error) {
+		return c.CreateOrder(CreateOrderParams{
+			ForName:      "Regular",
+			ForEmail:     "regular@example.com",
+			OrderDate:    "2025-01-01 12:00:00",
+			Status:       "pending",
+			Total:        total,
+			Items:        []CreateOrderItemParams{{ItemID: latte, Quantity: 1, Price: "10.00"}},
+			RedeemRuleID: redeem,
+		})
+	}
+
+	_, err = newOrder("10.00", 0)
+	require.NoError(t, err)
+	customer, err := c.GetCustomerByEmail("regular@example.com")
+	require.NoError(t, err)
+
+	balance, err := c.GetLoyaltyBalance(customer.ID)
+	require.NoError(t, err)
+	require.Equal(t, 15, balance, "10 points for $10 plus 5 for the latte")
+
+	t.Run("Redeem discount", func(t *testing.T) {
+		orderID, err := newOrder("4.50", discount.ID)
+		require.NoError(t, err)
+
+		orders, err := c.GetOrdersByCustomer(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, orderID, orders[0].ID)
+		require.Equal(t, "2.50", orders[0].Total.String(), "Discount should come off the total")
+
+		balance, err := c.GetLoyaltyBalance(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, 15-10+2+5, balance)
+
+		t.Run("Refund reverses points once", func(t *testing.T) {
+			require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusRefunded}))
+			require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusRefunded}))
+
+			balance, err := c.GetLoyaltyBalance(customer.ID)
+			require.NoError(t, err)
+			require.Equal(t, 15, balance)
+		})
+	})
+
+	t.Run("Not enough points", func(t *testing.T) {
+		_, err := newOrder("4.50", freeLatte.ID)
+		require.ErrorIs(t, err, ErrLoyaltyInsufficient)
+
+		orders, err := c.GetOrdersByCustomer(customer.ID)
+		require.NoError(t, err)
+		require.Len(t, orders, 2, "Failed redemption shouldn't create an order")
+	})
+
+	t.Run("Redeem free item", func(t *testing.T) {
+		_, err := newOrder("20.00", 0)
+		require.NoError(t, err)
+
+		orderID, err := newOrder("4.50", freeLatte.ID)
+		require.NoError(t, err)
+
+		orders, err := c.GetOrdersByCustomer(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, orderID, orders[0].ID)
+		require.Equal(t, "0.00", orders[0].Total.String())
+
+		entries, err := c.GetLoyaltyLedger(customer.ID, 10)
+		require.NoError(t, err)
+		require.Equal(t, LoyaltyReasonEarn, entries[0].Reason, "Earned points are recorded after the redemption")
+		require.Equal(t, -20, entries[1].Points)
+		require.Equal(t, LoyaltyReasonRedeem, entries[1].Reason)
+
+		t.Run("Free item can't be removed", func(t *testing.T) {
+			order, err := c.GetOrder(orderID)
+			require.NoError(t, err)
+			_, err = c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: order.Items[0].ID})
+			require.ErrorIs(t, err, ErrOrderItemRedeemed, "Removing it would take its price off the total twice")
+
+			_, err = c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: latte, Quantity: 1})
+			require.NoError(t, err)
+			_, err = c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: order.Items[0].ID})
+			require.NoError(t, err, "Another latte is still free")
+
+			order, err = c.GetOrder(orderID)
+			require.NoError(t, err)
+			require.Equal(t, "0.00", order.Total.String())
+		})
+	})
+
+	t.Run("Points are earned on menu prices", func(t *testing.T) {
+		_, err := c.CreateOrder(CreateOrderParams{
+			ForEmail: "regular@example.com",
+			Status:   "pending",
+			Total:    "100.00",
+			Items:    []CreateOrderItemParams{{ItemID: latte, Quantity: 1, Price: "100.00"}},
+		})
+		require.NoError(t, err)
+
+		entries, err := c.GetLoyaltyLedger(customer.ID, 10)
+		require.NoError(t, err)
+		earned := 0
+		for _, entry := range entries[:2] {
+			earned += entry.Points
+		}
+		require.Equal(t, 10+5, earned, "Points for the $10 latte, not the total sent")
+	})
+
+	t.Run("Item edits adjust earned points", func(t *testing.T) {
+		orderID, err := newOrder("10.00", 0)
+		require.NoError(t, err)
+		before, err := c.GetLoyaltyBalance(customer.ID)
+		require.NoError(t, err)
+
+		added, err := c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: latte, Quantity: 2})
+		require.NoError(t, err)
+		balance, err := c.GetLoyaltyBalance(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, before+20+10, balance, "Two more lattes earn $20 and 2 items' worth")
+
+		_, err = c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: added.LineID})
+		require.NoError(t, err)
+		balance, err = c.GetLoyaltyBalance(customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, before, balance)
+
+		entries, err := c.GetLoyaltyLedger(customer.ID, 3)
+		require.NoError(t, err)
+		require.Equal(t, []int{-30, 30, 5}, []int{entries[0].Points, entries[1].Points, entries[2].Points},
+			"Edits should append adjustments and leave the points first earned in the ledger")
+		require.Equal(t, LoyaltyReasonAdjustment, entries[0].Reason)
+		require.Equal(t, LoyaltyReasonAdjustment, entries[1].Reason)
+		require.Equal(t, LoyaltyReasonEarn, entries[2].Reason)
+	})
+
+	t.Run("Walk-in can't redeem", func(t *testing.T) {
+		_, err := c.CreateOrder(CreateOrderParams{Status: "pending", Total: "1.00", RedeemRuleID: discount.ID})
+		require.ErrorIs(t, err, ErrLoyaltyNoCustomer)
+	})
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const MFA_CHALLENGE_MAX_ATTEMPTS = 5
+
+// MFA is a user's two-factor state. Secret is set but EnabledAt is nil while enrollment is pending.
+type MFA struct {
+	Secret    string
+	EnabledAt *time.Time
+}
+
+// MFAChallenge is issued after a correct password when a second factor is still needed.
+type MFAChallenge struct {
+	CreateMFAChallengeParams
+	Attempts int
+}
+
+type CreateMFAChallengeParams struct {
+	TokenHash  string
+	UserID     uuid.UUID
+	DeviceName string
+	Enrollment bool // user must enroll before the challenge can be completed
+	ExpiresAt  time.Time
+}
+
+var ErrMFAChallengeInvalid = errors.New("mfa challenge is invalid or expired")
+var ErrMFACodeUsed = errors.New("mfa code already used")
+
+func (c *Client) GetMFA(userID uuid.UUID) (MFA, error) {
+	query := `
+		SELECT COALESCE(mfa_secret, ''), mfa_enabled_at
+		FROM users
+		WHERE id = ?
+	`
+	var mfa MFA
+	var enabled_at *string
+	err := c.db.QueryRow(query, userID.String()).Scan(&mfa.Secret, &enabled_at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MFA{}, nil
+		}
+		return MFA{}, err
+	}
+	if enabled_at != nil {
+		t, err := time.Parse(TIME_LAYOUT, *enabled_at)
+		if err != nil {
+			return MFA{}, fmt.Errorf("couldn't parse mfa_enabled_at: %w", err)
+		}
+		mfa.EnabledAt = &t
+	}
+	return mfa, nil
+}
+
+// SetPendingMFASecret starts enrollment. It fails if MFA is already enabled.
+func (c *Client) SetPendingMFASecret(userID uuid.UUID, secret string) error {
+	query := `
+		UPDATE users
+		SET mfa_secret = ?, mfa_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND mfa_enabled_at IS NULL
+	`
+	res, err := c.db.Exec(query, secret, userID.String())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("mfa already enabled")
+	}
+	return nil
+}
+
+// EnableMFA finishes enrollment and replaces the user's recovery codes.
+func (c *Client) EnableMFA(userID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET mfa_enabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND mfa_secret IS NOT NULL
+	`, userID.String())
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't enable mfa: %w", err)
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Client) DisableMFA(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET mfa_secret = NULL, mfa_enabled_at = NULL, mfa_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, userID.String())
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't disable mfa: %w", err)
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	_, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return fmt.Errorf("couldn't delete recovery codes: %w", err)
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO mfa_recovery_codes (created_at, user_id, code_hash)
+			VALUES (CURRENT_TIMESTAMP, ?, ?)
+		`, userID.String(), hash)
+		if err != nil {
+			return fmt.Errorf("couldn't create recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the last accepted TOTP time step. It returns ErrMFACodeUsed if
+// a code for this or a later step was already accepted, which stops codes being replayed.
+func (c *Client) UseTOTPStep(userID uuid.UUID, step int64) error {
+	query := `
+		UPDATE users
+		SET mfa_last_step = ?
+		WHERE id = ? AND COALESCE(mfa_last_step, -1) < ?
+	`
+	res, err := c.db.Exec(query, step, userID.String(), step)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns ErrMFACodeUsed if there is no such code.
+func (c *Client) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM mfa_recovery_codes
+			WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+			LIMIT 1
+		)
+	`
+	res, err := c.db.Exec(query, userID.String(), codeHash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+func (c *Client) CreateMFAChallenge(params CreateMFAChallengeParams) error {
+	query := `
+		INSERT INTO mfa_challenges (created_at, token_hash, user_id, device_name, enrollment, expires_at)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.DeviceName, params.Enrollment, params.ExpiresAt.UTC().Format(TIME_LAYOUT))
+	if err != nil {
+		return fmt.Errorf("couldn't create mfa challenge: %w", err)
+	}
+	return nil
+}
+
+// GetMFAChallenge returns an unused, unexpired challenge that hasn't run out of attempts.
+func (c *Client) GetMFAChallenge(tokenHash string) (MFAChallenge, error) {
+	query := `
+		SELECT token_hash, user_id, COALESCE(device_name, ''), enrollment, attempts, expires_at
+		FROM mfa_challenges
+		WHERE token_hash = ?
+			AND used_at IS NULL
+			AND expires_at > CURRENT_TIMESTAMP
+			AND attempts < ?
+	`
+	var ch MFAChallenge
+	var userID, expires_at string
+	err := c.db.QueryRow(query, tokenHash, MFA_CHALLENGE_MAX_ATTEMPTS).
+		Scan(&ch.TokenHash, &userID, &ch.DeviceName, &ch.Enrollment, &ch.Attempts, &expires_at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MFAChallenge{}, ErrMFAChallengeInvalid
+		}
+		return MFAChallenge{}, err
+	}
+
+	ch.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	ch.ExpiresAt, err = time.Parse(TIME_LAYOUT, expires_at)
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	return ch, nil
+}
+
+// RecordMFAChallengeFailure counts a wrong code against the challenge.
+func (c *Client) RecordMFAChallengeFailure(tokenHash string) error {
+	query := `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?`
+	_, err := c.db.Exec(query, tokenHash)
+	return err
+}
+
+// ConsumeMFAChallenge marks a challenge used. It returns ErrMFAChallengeInvalid if it was already used.
+func (c *Client) ConsumeMFAChallenge(tokenHash string) error {
+	query := `
+		UPDATE mfa_challenges
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL
+	`
+	res, err := c.db.Exec(query, tokenHash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return ErrMFAChallengeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMFA(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	user, err := c.CreateUser(CreateUserParams{Email: "manager@test.com", Password: "pw", Role: "manager"})
+	require.NoError(t, err, "Failed to create user")
+
+	t.Run("Enroll", func(t *testing.T) {
+		err := c.SetPendingMFASecret(user.ID, "SECRET")
+		require.NoError(t, err)
+
+		mfa, err := c.GetMFA(user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "SECRET", mfa.Secret)
+		require.Nil(t, mfa.EnabledAt, "MFA should be pending until confirmed")
+
+		err = c.EnableMFA(user.ID, []string{"code-a", "code-b"})
+		require.NoError(t, err)
+
+		mfa, err = c.GetMFA(user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, mfa.EnabledAt)
+
+		err = c.SetPendingMFASecret(user.ID, "OTHER")
+		require.Error(t, err, "Can't restart enrollment while enabled")
+	})
+
+	t.Run("Codes are single use", func(t *testing.T) {
+		require.NoError(t, c.UseTOTPStep(user.ID, 100))
+		require.ErrorIs(t, c.UseTOTPStep(user.ID, 100), ErrMFACodeUsed)
+		require.ErrorIs(t, c.UseTOTPStep(user.ID, 99), ErrMFACodeUsed)
+		require.NoError(t, c.UseTOTPStep(user.ID, 101))
+
+		require.NoError(t, c.UseRecoveryCode(user.ID, "code-a"))
+		require.ErrorIs(t, c.UseRecoveryCode(user.ID, "code-a"), ErrMFACodeUsed)
+		require.ErrorIs(t, c.UseRecoveryCode(user.ID, "unknown"), ErrMFACodeUsed)
+	})
+
+	t.Run("Challenge", func(t *testing.T) {
+		err := c.CreateMFAChallenge(CreateMFAChallengeParams{
+			TokenHash: "challenge", UserID: user.ID, DeviceName: "Office", ExpiresAt: time.Now().Add(time.Minute),
+		})
+		require.NoError(t, err)
+
+		ch, err := c.GetMFAChallenge("challenge")
+		require.NoError(t, err)
+		require.Equal(t, user.ID, ch.UserID)
+		require.Equal(t, "Office", ch.DeviceName)
+
+		for i := 0; i < MFA_CHALLENGE_MAX_ATTEMPTS; i++ {
+			require.NoError(t, c.RecordMFAChallengeFailure("challenge"))
+		}
+		_, err = c.GetMFAChallenge("challenge")
+		require.ErrorIs(t, err, ErrMFAChallengeInvalid, "Challenge should lock after too many attempts")
+
+		err = c.CreateMFAChallenge(CreateMFAChallengeParams{
+			TokenHash: "second", UserID: user.ID, ExpiresAt: time.Now().Add(time.Minute),
+		})
+		require.NoError(t, err)
+		require.NoError(t, c.ConsumeMFAChallenge("second"))
+		require.ErrorIs(t, c.ConsumeMFAChallenge("second"), ErrMFAChallengeInvalid)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		require.NoError(t, c.DisableMFA(user.ID))
+		mfa, err := c.GetMFA(user.ID)
+		require.NoError(t, err)
+		require.Empty(t, mfa.Secret)
+		require.Nil(t, mfa.EnabledAt)
+		require.ErrorIs(t, c.UseRecoveryCode(user.ID, "code-b"), ErrMFACodeUsed, "Recovery codes should be removed")
+	})
+}
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  email TEXT NOT NULL,
+  user_id TEXT,
+  ip_address TEXT NOT NULL,
+  user_agent TEXT,
+  success INTEGER NOT NULL DEFAULT 0,
+  reason TEXT,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address, created_at);
+
+-- +goose Down
+DROP TABLE login_attempts;
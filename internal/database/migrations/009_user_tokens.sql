@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  user_id TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  purpose TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  used_at TEXT,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id, purpose);
+
+ALTER TABLE users ADD COLUMN email_verified_at TEXT;
+
+-- accounts created before verification existed were set up by staff
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN email_verified_at;
+DROP TABLE user_tokens;
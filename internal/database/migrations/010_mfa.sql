@@ -0,0 +1,35 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN mfa_secret TEXT;
+ALTER TABLE users ADD COLUMN mfa_enabled_at TEXT;
+ALTER TABLE users ADD COLUMN mfa_last_step INTEGER;
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  user_id TEXT NOT NULL,
+  code_hash TEXT NOT NULL,
+  used_at TEXT,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  token_hash TEXT NOT NULL UNIQUE,
+  user_id TEXT NOT NULL,
+  device_name TEXT,
+  enrollment INTEGER NOT NULL DEFAULT 0,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  expires_at TEXT NOT NULL,
+  used_at TEXT,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE mfa_challenges;
+DROP TABLE mfa_recovery_codes;
+ALTER TABLE users DROP COLUMN mfa_last_step;
+ALTER TABLE users DROP COLUMN mfa_enabled_at;
+ALTER TABLE users DROP COLUMN mfa_secret;
@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS customers (
+  id TEXT PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  updated_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  name TEXT NOT NULL DEFAULT '',
+  email TEXT UNIQUE, -- stored lowercased, customers are deduplicated by email
+  phone TEXT,
+  user_id TEXT UNIQUE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_customers_phone ON customers(phone);
+
+ALTER TABLE orders ADD COLUMN customer_id TEXT REFERENCES customers(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders(customer_id);
+
+-- one customer per distinct email on existing orders, named after their latest order
+INSERT INTO customers (id, created_at, updated_at, name, email)
+SELECT
+  lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(6))),
+  MIN(o.created_at),
+  MAX(o.updated_at),
+  (SELECT o2.for_name FROM orders o2 WHERE lower(trim(o2.for_email)) = lower(trim(o.for_email)) ORDER BY o2.id DESC LIMIT 1),
+  lower(trim(o.for_email))
+FROM orders o
+WHERE trim(o.for_email) != ''
+GROUP BY lower(trim(o.for_email));
+
+UPDATE orders
+SET customer_id = (SELECT c.id FROM customers c WHERE c.email = lower(trim(orders.for_email)))
+WHERE trim(for_email) != '';
+
+-- link customers to existing accounts with the same email
+UPDATE customers
+SET user_id = (SELECT u.id FROM users u WHERE lower(u.email) = customers.email AND u.email_verified_at IS NOT NULL)
+WHERE user_id IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_customer_id;
+ALTER TABLE orders DROP COLUMN customer_id;
+DROP TABLE customers;
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS loyalty_rules (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  kind TEXT NOT NULL, -- earn_spend, earn_item, redeem_discount or redeem_item
+  points INTEGER NOT NULL,
+  item_id TEXT,
+  amount_cents INTEGER NOT NULL DEFAULT 0,
+  active INTEGER NOT NULL DEFAULT 1,
+  FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS loyalty_ledger (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  customer_id TEXT NOT NULL,
+  order_id INTEGER,
+  rule_id INTEGER,
+  points INTEGER NOT NULL, -- positive when earned, negative when redeemed or reversed
+  reason TEXT NOT NULL,
+  FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE,
+  FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_customer_id ON loyalty_ledger(customer_id, id);
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_order_id ON loyalty_ledger(order_id);
+
+-- +goose Down
+DROP TABLE loyalty_ledger;
+DROP TABLE loyalty_rules;
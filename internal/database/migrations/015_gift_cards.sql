@@ -0,0 +1,51 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS gift_cards (
+  id TEXT PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  updated_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  number TEXT NOT NULL UNIQUE,
+  kind TEXT NOT NULL, -- gift_card or store_credit
+  pin_hash TEXT,
+  pin_failures INTEGER NOT NULL DEFAULT 0,
+  balance_cents INTEGER NOT NULL DEFAULT 0 CHECK (balance_cents >= 0),
+  customer_id TEXT,
+  FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_gift_cards_customer_id ON gift_cards(customer_id);
+
+CREATE TABLE IF NOT EXISTS payments (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  order_id INTEGER NOT NULL,
+  tender TEXT NOT NULL, -- cash, card, gift_card or store_credit
+  amount_cents INTEGER NOT NULL CHECK (amount_cents > 0),
+  gift_card_id TEXT,
+  reference TEXT NOT NULL DEFAULT '',
+  FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+  FOREIGN KEY (gift_card_id) REFERENCES gift_cards(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments(order_id);
+
+CREATE TABLE IF NOT EXISTS gift_card_ledger (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  gift_card_id TEXT NOT NULL,
+  order_id INTEGER,
+  amount_cents INTEGER NOT NULL, -- positive when loaded or refunded, negative when spent
+  balance_cents INTEGER NOT NULL, -- balance after this movement
+  reason TEXT NOT NULL,
+  FOREIGN KEY (gift_card_id) REFERENCES gift_cards(id) ON DELETE CASCADE,
+  FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_gift_card_ledger_gift_card_id ON gift_card_ledger(gift_card_id, id);
+
+ALTER TABLE orders ADD COLUMN paid_at TEXT;
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN paid_at;
+DROP TABLE gift_card_ledger;
+DROP TABLE payments;
+DROP TABLE gift_cards;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN allergies TEXT NOT NULL DEFAULT '[]'; -- JSON array of allergens
+ALTER TABLE orders ADD COLUMN internal_notes TEXT; -- staff only, never shown to customers
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN internal_notes;
+ALTER TABLE orders DROP COLUMN allergies;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key TEXT NOT NULL,
+  scope TEXT NOT NULL, -- method and path the key was used on
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  expires_at TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  status_code INTEGER, -- NULL while the first request is still running
+  content_type TEXT,
+  response_body BLOB,
+  PRIMARY KEY (key, scope)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+-- +goose Down
+DROP TABLE idempotency_keys;
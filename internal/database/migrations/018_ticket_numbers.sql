@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN business_day TEXT; -- YYYY-MM-DD in the store's timezone
+ALTER TABLE orders ADD COLUMN ticket_number INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_ticket_number ON orders(business_day, ticket_number);
+
+CREATE TABLE IF NOT EXISTS ticket_counters (
+  business_day TEXT PRIMARY KEY,
+  last_number INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE ticket_counters;
+DROP INDEX idx_orders_ticket_number;
+ALTER TABLE orders DROP COLUMN ticket_number;
+ALTER TABLE orders DROP COLUMN business_day;
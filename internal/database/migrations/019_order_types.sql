@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN order_type TEXT NOT NULL DEFAULT 'takeout'; -- dine_in, takeout or delivery
+ALTER TABLE orders ADD COLUMN table_number TEXT;
+ALTER TABLE orders ADD COLUMN covers INTEGER;
+ALTER TABLE orders ADD COLUMN pickup_at TEXT;
+ALTER TABLE orders ADD COLUMN delivery_address TEXT;
+ALTER TABLE orders ADD COLUMN delivery_fee TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_orders_order_type ON orders(order_type, status);
+
+-- +goose Down
+DROP INDEX idx_orders_order_type;
+ALTER TABLE orders DROP COLUMN delivery_fee;
+ALTER TABLE orders DROP COLUMN delivery_address;
+ALTER TABLE orders DROP COLUMN pickup_at;
+ALTER TABLE orders DROP COLUMN covers;
+ALTER TABLE orders DROP COLUMN table_number;
+ALTER TABLE orders DROP COLUMN order_type;
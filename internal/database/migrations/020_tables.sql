@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS sections (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  name TEXT NOT NULL UNIQUE,
+  sort_order INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS tables (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  updated_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  section_id INTEGER,
+  name TEXT NOT NULL UNIQUE, -- what staff call the table, e.g. "12" or "Patio 3"
+  capacity INTEGER NOT NULL CHECK (capacity > 0),
+  pos_x REAL NOT NULL DEFAULT 0, -- position on the floor plan
+  pos_y REAL NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'free', -- free, seated, ordered, paying or needs_bussing
+  FOREIGN KEY (section_id) REFERENCES sections(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS tabs (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  updated_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  table_id INTEGER,
+  name TEXT NOT NULL DEFAULT '',
+  covers INTEGER NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'open', -- open, closed or merged
+  closed_at TEXT,
+  merged_into INTEGER,
+  FOREIGN KEY (table_id) REFERENCES tables(id) ON DELETE SET NULL,
+  FOREIGN KEY (merged_into) REFERENCES tabs(id)
+);
+
+-- a table has at most one open tab
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tabs_open_table ON tabs(table_id) WHERE status = 'open' AND table_id IS NOT NULL;
+
+ALTER TABLE orders ADD COLUMN tab_id INTEGER REFERENCES tabs(id);
+CREATE INDEX IF NOT EXISTS idx_orders_tab_id ON orders(tab_id);
+
+-- +goose Down
+DROP INDEX idx_orders_tab_id;
+ALTER TABLE orders DROP COLUMN tab_id;
+DROP TABLE tabs;
+DROP TABLE tables;
+DROP TABLE sections;
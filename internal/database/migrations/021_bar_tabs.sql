@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE tabs ADD COLUMN card_auth_id TEXT; -- card hold placed through the payments gateway
+ALTER TABLE tabs ADD COLUMN card_auth_cents INTEGER;
+ALTER TABLE tabs ADD COLUMN card_last4 TEXT;
+CREATE INDEX IF NOT EXISTS idx_tabs_status ON tabs(status);
+
+-- +goose Down
+DROP INDEX idx_tabs_status;
+ALTER TABLE tabs DROP COLUMN card_last4;
+ALTER TABLE tabs DROP COLUMN card_auth_cents;
+ALTER TABLE tabs DROP COLUMN card_auth_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN release_at TEXT; -- when a scheduled order goes to the kitchen, UTC
+CREATE INDEX IF NOT EXISTS idx_orders_release_at ON orders(status, release_at);
+
+-- +goose Down
+DROP INDEX idx_orders_release_at;
+ALTER TABLE orders DROP COLUMN release_at;
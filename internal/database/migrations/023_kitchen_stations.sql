@@ -0,0 +1,33 @@
+-- +goose Up
+ALTER TABLE items ADD COLUMN category TEXT NOT NULL DEFAULT '';
+ALTER TABLE items ADD COLUMN station TEXT; -- overrides the station of the item's category
+
+-- which station makes each category of item
+CREATE TABLE IF NOT EXISTS station_routes (
+  category TEXT PRIMARY KEY,
+  station TEXT NOT NULL
+);
+
+ALTER TABLE order_items ADD COLUMN station TEXT; -- set when the order is sent to the kitchen
+
+-- the part of an order one station makes, bumped off its screen when done
+CREATE TABLE IF NOT EXISTS station_tickets (
+  id INTEGER PRIMARY KEY,
+  created_at TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  order_id INTEGER NOT NULL,
+  station TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open', -- open or bumped
+  bumped_at TEXT,
+  recalled_at TEXT,
+  UNIQUE (order_id, station),
+  FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_station_tickets_station ON station_tickets(station, status);
+
+-- +goose Down
+DROP INDEX idx_station_tickets_station;
+DROP TABLE station_tickets;
+ALTER TABLE order_items DROP COLUMN station;
+DROP TABLE station_routes;
+ALTER TABLE items DROP COLUMN station;
+ALTER TABLE items DROP COLUMN category;
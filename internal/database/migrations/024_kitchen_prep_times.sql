@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE station_tickets ADD COLUMN started_at TEXT; -- when the station started making it
+ALTER TABLE station_tickets ADD COLUMN late_at TEXT; -- when it went past the target prep time while open
+
+-- +goose Down
+ALTER TABLE station_tickets DROP COLUMN late_at;
+ALTER TABLE station_tickets DROP COLUMN started_at;
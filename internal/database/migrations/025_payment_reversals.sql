@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE payments ADD COLUMN reversed_at TEXT; -- when the order was voided or refunded and the payment given back
+
+-- +goose Down
+ALTER TABLE payments DROP COLUMN reversed_at;
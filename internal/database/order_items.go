@@ -0,0 +1,334 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/shopspring/decimal"
+)
+
+// Kinds of line item change.
+const (
+	OrderItemAdded   = "added"
+	OrderItemChanged = "changed"
+	OrderItemRemoved = "removed"
+)
+
+// OrderItemChange describes one edit to an order's line items, for the kitchen to see what changed.
+type OrderItemChange struct {
+	Action         string `json:"action"`
+	LineID         int    `json:"line_id"`
+	ItemID         string `json:"item_id"`
+	ItemName       string `json:"item_name"`
+	QuantityBefore int    `json:"quantity_before"`
+	QuantityAfter  int    `json:"quantity_after"`
+	NotesBefore    string `json:"notes_before"`
+	NotesAfter     string `json:"notes_after"`
+	Station        string `json:"station,omitempty"` // kitchen station making the item, if sent to the kitchen
+}
+
+// AddOrderItemParams adds a line to an order. LockedStatuses, here and on the other edit params, are
+// the statuses in which an order can no longer be edited besides voided and refunded. Orders with
+// payments can't be edited either. Earned loyalty points are worked out again after each edit.
+type AddOrderItemParams struct {
+	OrderID        int
+	ItemID         string
+	Quantity       int
+	Notes          string
+	LockedStatuses []string
+}
+
+// UpdateOrderItemParams changes the quantity and notes that are set and leaves nil ones as they are.
+type UpdateOrderItemParams struct {
+	OrderID        int
+	LineID         int
+	Quantity       *int
+	Notes          *string
+	LockedStatuses []string
+}
+
+type RemoveOrderItemParams struct {
+	OrderID        int
+	LineID         int
+	LockedStatuses []string
+}
+
+var (
+	ErrOrderLocked       = errors.New("order can no longer be edited")
+	ErrOrderItemNotFound = errors.New("order item not found")
+	ErrInvalidQuantity   = errors.New("quantity must be at least 1")
+	ErrMenuItemNotFound  = errors.New("item not found")
+	ErrOrderItemRedeemed = errors.New("a loyalty reward was redeemed for this item, void the order instead")
+)
+
+// AddOrderItem adds a line to an open order at the item's current price and adds it to the total.
+func (c *Client) AddOrderItem(params AddOrderItemParams) (OrderItemChange, error) {
+	if params.Quantity < 1 {
+		return OrderItemChange{}, ErrInvalidQuantity
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OrderItemChange{}, err
+	}
+
+	if err := checkOrderEditable(tx, params.OrderID, params.LockedStatuses); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	var name string
+	var cost int64
+	err = tx.QueryRow(`SELECT name, COALESCE(cost, 0) FROM items WHERE id = ?`, params.ItemID).Scan(&name, &cost)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return OrderItemChange{}, ErrMenuItemNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	price := decimal.New(cost, -2)
+
+	var lineID int
+	err = tx.QueryRow(`
+		INSERT INTO order_items (order_id, item_id, quantity, price, notes)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`, params.OrderID, params.ItemID, params.Quantity, price.StringFixed(2), params.Notes).Scan(&lineID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, fmt.Errorf("failed to create order item: %v", err)
+	}
+
+	if err := adjustOrderTotal(tx, params.OrderID, price.Mul(decimal.NewFromInt(int64(params.Quantity)))); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	stations, err := routeOrder(tx, params.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	if err := adjustOrderLoyalty(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return OrderItemChange{}, err
+	}
+
+	change := OrderItemChange{
+		Action:        OrderItemAdded,
+		LineID:        lineID,
+		ItemID:        params.ItemID,
+		ItemName:      name,
+		QuantityAfter: params.Quantity,
+		NotesAfter:    params.Notes,
+	}
+	if len(stations) > 0 {
+		change.Station = stations[0]
+	}
+	return change, nil
+}
+
+// UpdateOrderItem changes a line's quantity or notes on an open order and adjusts the total by the
+// difference, at the price the line was ordered at.
+func (c *Client) UpdateOrderItem(params UpdateOrderItemParams) (OrderItemChange, error) {
+	if params.Quantity != nil && *params.Quantity < 1 {
+		return OrderItemChange{}, ErrInvalidQuantity
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OrderItemChange{}, err
+	}
+
+	if err := checkOrderEditable(tx, params.OrderID, params.LockedStatuses); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	change, price, err := getOrderItemForEdit(tx, params.OrderID, params.LineID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	change.Action = OrderItemChanged
+	change.QuantityAfter = change.QuantityBefore
+	change.NotesAfter = change.NotesBefore
+	if params.Quantity != nil {
+		change.QuantityAfter = *params.Quantity
+	}
+	if params.Notes != nil {
+		change.NotesAfter = *params.Notes
+	}
+
+	_, err = tx.Exec(`UPDATE order_items SET quantity = ?, notes = ? WHERE id = ?`, change.QuantityAfter, change.NotesAfter, params.LineID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, fmt.Errorf("failed to update order item: %v", err)
+	}
+
+	delta := price.Mul(decimal.NewFromInt(int64(change.QuantityAfter - change.QuantityBefore)))
+	if err := adjustOrderTotal(tx, params.OrderID, delta); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	if err := adjustOrderLoyalty(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	// the station has to see the change even if it already bumped the ticket
+	if change.Station != "" && (change.QuantityAfter != change.QuantityBefore || change.NotesAfter != change.NotesBefore) {
+		if err := openStationTickets(tx, params.OrderID, []string{change.Station}); err != nil {
+			tx.Rollback()
+			return OrderItemChange{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return OrderItemChange{}, err
+	}
+	return change, nil
+}
+
+// RemoveOrderItem removes a line from an open order and takes it off the total. The last line of an
+// item redeemed as a loyalty reward can't be removed, as its price was already taken off the total.
+func (c *Client) RemoveOrderItem(params RemoveOrderItemParams) (OrderItemChange, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OrderItemChange{}, err
+	}
+
+	if err := checkOrderEditable(tx, params.OrderID, params.LockedStatuses); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	change, price, err := getOrderItemForEdit(tx, params.OrderID, params.LineID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	change.Action = OrderItemRemoved
+
+	redeemed, err := isRedeemedItemLine(tx, params.OrderID, params.LineID, change.ItemID)
+	if err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	if redeemed {
+		tx.Rollback()
+		return OrderItemChange{}, ErrOrderItemRedeemed
+	}
+
+	if _, err := tx.Exec(`DELETE FROM order_items WHERE id = ?`, params.LineID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, fmt.Errorf("failed to remove order item: %v", err)
+	}
+
+	if err := adjustOrderTotal(tx, params.OrderID, price.Mul(decimal.NewFromInt(int64(-change.QuantityBefore)))); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	if err := adjustOrderLoyalty(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	// the station may have nothing left to make, which can leave the order ready
+	if err := pruneStationTickets(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+	if _, err := updateOrderReady(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return OrderItemChange{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return OrderItemChange{}, err
+	}
+	return change, nil
+}
+
+// checkOrderEditable returns an error unless the order exists and can still have its items changed.
+// Once anything was paid the total can't change, or the payments would no longer add up.
+func checkOrderEditable(tx *sql.Tx, orderID int, lockedStatuses []string) error {
+	var status string
+	var paidAt *string
+	var hasPayments bool
+	err := tx.QueryRow(`
+		SELECT status, paid_at, EXISTS (SELECT 1 FROM payments WHERE order_id = o.id)
+		FROM orders o
+		WHERE id = ?
+	`, orderID).Scan(&status, &paidAt, &hasPayments)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if paidAt != nil {
+		return ErrOrderAlreadyPaid
+	}
+	if hasPayments {
+		return fmt.Errorf("%w: order has payments", ErrOrderLocked)
+	}
+	if isReversedStatus(status) || slices.Contains(lockedStatuses, status) {
+		return fmt.Errorf("%w: order is %s", ErrOrderLocked, status)
+	}
+	return nil
+}
+
+// getOrderItemForEdit returns a line of the order as it is before the edit, with its unit price.
+func getOrderItemForEdit(tx *sql.Tx, orderID, lineID int) (OrderItemChange, decimal.Decimal, error) {
+	var change OrderItemChange
+	var price string
+	err := tx.QueryRow(`
+		SELECT oi.id, oi.item_id, COALESCE(i.name, ''), oi.quantity, oi.price, COALESCE(oi.notes, ''), COALESCE(oi.station, '')
+		FROM order_items oi
+		LEFT JOIN items i ON oi.item_id = i.id
+		WHERE oi.id = ? AND oi.order_id = ?
+	`, lineID, orderID).Scan(&change.LineID, &change.ItemID, &change.ItemName, &change.QuantityBefore, &price, &change.NotesBefore, &change.Station)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrderItemChange{}, decimal.Zero, ErrOrderItemNotFound
+	}
+	if err != nil {
+		return OrderItemChange{}, decimal.Zero, err
+	}
+
+	unitPrice, err := decimal.NewFromString(price)
+	if err != nil {
+		return OrderItemChange{}, decimal.Zero, fmt.Errorf("invalid price %q for order item %d: %w", price, lineID, err)
+	}
+	return change, unitPrice, nil
+}
+
+// adjustOrderTotal adds delta to an order's total. The total is adjusted rather than recomputed from
+// the items so discounts already taken off it are kept. It never goes below zero.
+func adjustOrderTotal(tx *sql.Tx, orderID int, delta decimal.Decimal) error {
+	var total string
+	if err := tx.QueryRow(`SELECT total FROM orders WHERE id = ?`, orderID).Scan(&total); err != nil {
+		return err
+	}
+	current, err := decimal.NewFromString(total)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidOrderTotal, total)
+	}
+
+	next := decimal.Max(current.Add(delta), decimal.Zero)
+	_, err = tx.Exec(`UPDATE orders SET total = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, next.StringFixed(2), orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update order total: %v", err)
+	}
+	return nil
+}
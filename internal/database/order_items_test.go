@@ -0,0 +1,88 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditOrderItems(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 450})
+	require.NoError(t, err)
+	_, err = c.CreateItem(CreateItemParams{Name: "Muffin", Cost: 300})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	itemIDs := map[string]string{}
+	for _, item := range items {
+		itemIDs[item.Name] = item.ID
+	}
+
+	orderID, err := c.CreateOrder(CreateOrderParams{
+		ForName: "Ada",
+		Status:  "pending",
+		Total:   "4.00", // 50 cents off the latte
+		Items:   []CreateOrderItemParams{{ItemID: itemIDs["Latte"], Quantity: 1, Price: "4.50"}},
+	})
+	require.NoError(t, err)
+	locked := []string{OrderStatusCompleted}
+
+	total := func() json.Number {
+		order, err := c.GetOrder(orderID)
+		require.NoError(t, err)
+		return order.Total
+	}
+
+	added, err := c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: itemIDs["Muffin"], Quantity: 2, Notes: "warmed", LockedStatuses: locked})
+	require.NoError(t, err)
+	require.Equal(t, OrderItemAdded, added.Action)
+	require.Equal(t, "Muffin", added.ItemName)
+	require.Equal(t, json.Number("10.00"), total(), "Discount should be kept when items are added")
+
+	quantity := 1
+	changed, err := c.UpdateOrderItem(UpdateOrderItemParams{OrderID: orderID, LineID: added.LineID, Quantity: &quantity, LockedStatuses: locked})
+	require.NoError(t, err)
+	require.Equal(t, 2, changed.QuantityBefore)
+	require.Equal(t, 1, changed.QuantityAfter)
+	require.Equal(t, "warmed", changed.NotesAfter, "Notes weren't sent and should be kept")
+	require.Equal(t, json.Number("7.00"), total())
+
+	quantity = 0
+	_, err = c.UpdateOrderItem(UpdateOrderItemParams{OrderID: orderID, LineID: added.LineID, Quantity: &quantity})
+	require.ErrorIs(t, err, ErrInvalidQuantity)
+
+	removed, err := c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: added.LineID, LockedStatuses: locked})
+	require.NoError(t, err)
+	require.Equal(t, OrderItemRemoved, removed.Action)
+	require.Equal(t, json.Number("4.00"), total())
+
+	_, err = c.RemoveOrderItem(RemoveOrderItemParams{OrderID: orderID, LineID: added.LineID})
+	require.ErrorIs(t, err, ErrOrderItemNotFound)
+
+	_, err = c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: "missing", Quantity: 1})
+	require.ErrorIs(t, err, ErrMenuItemNotFound)
+
+	t.Run("Locked", func(t *testing.T) {
+		require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: OrderStatusCompleted}))
+		_, err := c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: itemIDs["Muffin"], Quantity: 1, LockedStatuses: locked})
+		require.ErrorIs(t, err, ErrOrderLocked)
+
+		require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: orderID, Status: "pending"}))
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderCash, AmountCents: 100})
+		require.NoError(t, err)
+		_, err = c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: itemIDs["Muffin"], Quantity: 1, LockedStatuses: locked})
+		require.ErrorIs(t, err, ErrOrderLocked, "Partly paid orders can't be edited")
+
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderCash, AmountCents: 300})
+		require.NoError(t, err)
+		_, err = c.AddOrderItem(AddOrderItemParams{OrderID: orderID, ItemID: itemIDs["Muffin"], Quantity: 1, LockedStatuses: locked})
+		require.ErrorIs(t, err, ErrOrderAlreadyPaid)
+	})
+}
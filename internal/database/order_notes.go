@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Allergens that can be flagged on an order. Anything else goes in the notes.
+var Allergens = []string{
+	"dairy", "eggs", "fish", "gluten", "peanuts", "sesame", "shellfish", "soy", "tree_nuts",
+}
+
+// OrderNotes are the annotations on an order. InternalNotes are for staff and are left out of
+// anything customers can see.
+type OrderNotes struct {
+	Notes         string   `json:"notes"`
+	Allergies     []string `json:"allergies"`
+	InternalNotes string   `json:"internal_notes,omitempty"`
+}
+
+// UpdateOrderNotesParams changes the annotations that are set and leaves nil ones as they are.
+type UpdateOrderNotesParams struct {
+	ID            int       `json:"id"`
+	Notes         *string   `json:"notes"`
+	Allergies     *[]string `json:"allergies"`
+	InternalNotes *string   `json:"internal_notes"`
+}
+
+var ErrInvalidAllergen = errors.New("invalid allergen")
+
+// NormalizeAllergies lowercases, sorts and deduplicates allergies, and checks they are all known.
+func NormalizeAllergies(allergies []string) ([]string, error) {
+	out := []string{}
+	for _, allergen := range allergies {
+		allergen = strings.ToLower(strings.TrimSpace(allergen))
+		if !slices.Contains(Allergens, allergen) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAllergen, allergen)
+		}
+		if !slices.Contains(out, allergen) {
+			out = append(out, allergen)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// UpdateOrderNotes edits an order's annotations. Returns the annotations from before the edit so
+// the change can be audited.
+func (c *Client) UpdateOrderNotes(params UpdateOrderNotesParams) (OrderNotes, error) {
+	var allergies []string
+	if params.Allergies != nil {
+		var err error
+		if allergies, err = NormalizeAllergies(*params.Allergies); err != nil {
+			return OrderNotes{}, err
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OrderNotes{}, err
+	}
+
+	var prev OrderNotes
+	var prevAllergies string
+	err = tx.QueryRow(`SELECT COALESCE(notes, ''), allergies, COALESCE(internal_notes, '') FROM orders WHERE id = ?`, params.ID).
+		Scan(&prev.Notes, &prevAllergies, &prev.InternalNotes)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return OrderNotes{}, ErrOrderNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return OrderNotes{}, err
+	}
+	if prev.Allergies, err = parseAllergies(prevAllergies); err != nil {
+		tx.Rollback()
+		return OrderNotes{}, err
+	}
+
+	next := prev
+	if params.Notes != nil {
+		next.Notes = strings.TrimSpace(*params.Notes)
+	}
+	if params.InternalNotes != nil {
+		next.InternalNotes = strings.TrimSpace(*params.InternalNotes)
+	}
+	if params.Allergies != nil {
+		next.Allergies = allergies
+	}
+	encoded, err := json.Marshal(next.Allergies)
+	if err != nil {
+		tx.Rollback()
+		return OrderNotes{}, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE orders
+		SET notes = NULLIF(?, ''), allergies = ?, internal_notes = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, next.Notes, string(encoded), next.InternalNotes, params.ID)
+	if err != nil {
+		tx.Rollback()
+		return OrderNotes{}, fmt.Errorf("failed to update order notes: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return OrderNotes{}, err
+	}
+	return prev, nil
+}
+
+func parseAllergies(s string) ([]string, error) {
+	allergies := []string{}
+	if err := json.Unmarshal([]byte(s), &allergies); err != nil {
+		return nil, fmt.Errorf("invalid allergies %q: %w", s, err)
+	}
+	return allergies, nil
+}
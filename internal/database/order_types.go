@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Order types.
+const (
+	OrderTypeDineIn   = "dine_in"
+	OrderTypeTakeout  = "takeout"
+	OrderTypeDelivery = "delivery"
+)
+
+// OrderTypeDetails is how an order is served, with the fields that only apply to its type.
+type OrderTypeDetails struct {
+	Type            string `json:"order_type"`
+	TableNumber     string `json:"table_number,omitempty"` // dine in
+	Covers          int    `json:"covers,omitempty"`       // dine in, number of guests
+	PickupAt        string `json:"pickup_at,omitempty"`    // takeout, TIME_LAYOUT. Empty means as soon as possible
+	DeliveryAddress string `json:"delivery_address,omitempty"`
+	DeliveryFee     string `json:"delivery_fee,omitempty"` // delivery, added to the order's total
+}
+
+var ErrInvalidOrderType = errors.New("invalid order type")
+
+func ValidOrderType(orderType string) bool {
+	return orderType == OrderTypeDineIn || orderType == OrderTypeTakeout || orderType == OrderTypeDelivery
+}
+
+// Validate checks the fields required by the order's type are set and no others are. Orders
+// without a type are takeout. Trims the fields and formats the delivery fee.
+func (d *OrderTypeDetails) Validate() error {
+	if d.Type == "" {
+		d.Type = OrderTypeTakeout
+	}
+	d.TableNumber = strings.TrimSpace(d.TableNumber)
+	d.DeliveryAddress = strings.TrimSpace(d.DeliveryAddress)
+	d.DeliveryFee = strings.TrimSpace(d.DeliveryFee)
+
+	invalid := func(msg string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOrderType, msg)
+	}
+
+	switch d.Type {
+	case OrderTypeDineIn:
+		if d.TableNumber == "" {
+			return invalid("dine in orders need a table number")
+		}
+		if d.Covers < 1 {
+			return invalid("dine in orders need at least 1 cover")
+		}
+	case OrderTypeTakeout:
+		if d.PickupAt != "" {
+			if _, err := time.Parse(TIME_LAYOUT, d.PickupAt); err != nil {
+				return invalid("invalid pickup time")
+			}
+		}
+	case OrderTypeDelivery:
+		if d.DeliveryAddress == "" {
+			return invalid("delivery orders need an address")
+		}
+		fee := decimal.Zero
+		if d.DeliveryFee != "" {
+			var err error
+			if fee, err = decimal.NewFromString(d.DeliveryFee); err != nil || fee.IsNegative() {
+				return invalid("invalid delivery fee")
+			}
+		}
+		d.DeliveryFee = fee.StringFixed(2)
+	default:
+		return invalid(fmt.Sprintf("unknown order type %q", d.Type))
+	}
+
+	if d.Type != OrderTypeDineIn && (d.TableNumber != "" || d.Covers != 0) {
+		return invalid("table and covers are only for dine in orders")
+	}
+	if d.Type != OrderTypeTakeout && d.PickupAt != "" {
+		return invalid("pickup time is only for takeout orders")
+	}
+	if d.Type != OrderTypeDelivery && (d.DeliveryAddress != "" || d.DeliveryFee != "") {
+		return invalid("address and delivery fee are only for delivery orders")
+	}
+	return nil
+}
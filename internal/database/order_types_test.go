@@ -0,0 +1,84 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderTypeDetailsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		details OrderTypeDetails
+		wantErr bool
+	}{
+		{"Defaults to takeout", OrderTypeDetails{}, false},
+		{"Dine in", OrderTypeDetails{Type: OrderTypeDineIn, TableNumber: "12", Covers: 2}, false},
+		{"Dine in without table", OrderTypeDetails{Type: OrderTypeDineIn, Covers: 2}, true},
+		{"Dine in without covers", OrderTypeDetails{Type: OrderTypeDineIn, TableNumber: "12"}, true},
+		{"Takeout with pickup time", OrderTypeDetails{Type: OrderTypeTakeout, PickupAt: "2025-01-01 12:30:00"}, false},
+		{"Takeout with bad pickup time", OrderTypeDetails{Type: OrderTypeTakeout, PickupAt: "noon"}, true},
+		{"Takeout with table", OrderTypeDetails{Type: OrderTypeTakeout, TableNumber: "12"}, true},
+		{"Delivery", OrderTypeDetails{Type: OrderTypeDelivery, DeliveryAddress: "1 Main St", DeliveryFee: "3.5"}, false},
+		{"Delivery without address", OrderTypeDetails{Type: OrderTypeDelivery}, true},
+		{"Delivery with negative fee", OrderTypeDetails{Type: OrderTypeDelivery, DeliveryAddress: "1 Main St", DeliveryFee: "-1"}, true},
+		{"Delivery with pickup time", OrderTypeDetails{Type: OrderTypeDelivery, DeliveryAddress: "1 Main St", PickupAt: "2025-01-01 12:30:00"}, true},
+		{"Unknown type", OrderTypeDetails{Type: "drive_thru"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.details.Validate()
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidOrderType)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOrderTypes(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	dineIn, err := c.CreateOrder(CreateOrderParams{
+		ForName:          "Ada",
+		Status:           "pending",
+		Total:            "10.00",
+		OrderTypeDetails: OrderTypeDetails{Type: OrderTypeDineIn, TableNumber: "4", Covers: 3},
+	})
+	require.NoError(t, err)
+	delivery, err := c.CreateOrder(CreateOrderParams{
+		ForName:          "Grace",
+		Status:           "pending",
+		Total:            "10.00",
+		OrderTypeDetails: OrderTypeDetails{Type: OrderTypeDelivery, DeliveryAddress: "1 Main St", DeliveryFee: "3.5"},
+	})
+	require.NoError(t, err)
+	takeout, err := c.CreateOrder(CreateOrderParams{ForName: "Linus", Status: "pending", Total: "10.00"})
+	require.NoError(t, err)
+
+	order, err := c.GetOrder(dineIn)
+	require.NoError(t, err)
+	require.Equal(t, OrderTypeDetails{Type: OrderTypeDineIn, TableNumber: "4", Covers: 3}, order.OrderTypeDetails)
+
+	order, err = c.GetOrder(delivery)
+	require.NoError(t, err)
+	require.Equal(t, "3.50", order.DeliveryFee)
+	require.Equal(t, json.Number("13.50"), order.Total, "Delivery fee should be added to the total")
+
+	order, err = c.GetOrder(takeout)
+	require.NoError(t, err)
+	require.Equal(t, OrderTypeTakeout, order.Type)
+
+	orders, _, err := c.GetOrders(OrderFilter{Types: []string{OrderTypeDineIn, OrderTypeDelivery}, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	require.Equal(t, dineIn, orders[0].ID)
+	require.Equal(t, delivery, orders[1].ID)
+}
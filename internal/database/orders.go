@@ -1,18 +1,65 @@
 package database
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
+type Order struct {
+	ID           int         `json:"id"`
+	TicketNumber int         `json:"ticket_number"` // counts up from 1 each business day
+	BusinessDay  string      `json:"business_day"`
+	CustomerID   *uuid.UUID  `json:"customer_id"`
+	TabID        *int        `json:"tab_id"`
+	ForName      string      `json:"for_name"`
+	ForEmail     string      `json:"email"`
+	OrderDate    string      `json:"order_date"`
+	Status       string      `json:"status"`
+	ReleaseAt    string      `json:"release_at,omitempty"` // scheduled orders, when they go to the kitchen
+	Total        json.Number `json:"total"`
+	CreatedAt    string      `json:"created_at"`
+	UpdatedAt    string      `json:"updated_at"`
+	OrderTypeDetails
+	OrderNotes
+	Items []OrderItem `json:"items"`
+}
+
+type OrderItem struct {
+	ID              int    `json:"id"`
+	OrderID         int    `json:"order_id"`
+	ItemID          string `json:"item_id"`
+	ItemName        string `json:"item_name"`
+	ItemDescription string `json:"item_description"`
+	Quantity        int    `json:"quantity"`
+	Price           string `json:"price"`
+	Notes           string `json:"notes"`
+	Station         string `json:"station,omitempty"` // kitchen station making it, once sent to the kitchen
+}
+
 type CreateOrderParams struct {
-	ForName   string                  `json:"for_name"`
-	ForEmail  string                  `json:"for_email"`
-	OrderDate string                  `json:"order_date"`
-	Status    string                  `json:"status"`
-	Total     string                  `json:"total"` // Stored as a string to handle decimal formatting
-	Notes     string                  `json:"notes"` // Additional notes for the order
-	Items     []CreateOrderItemParams `json:"items"` // Associated order items
+	CustomerID    *uuid.UUID              `json:"customer_id"` // set for known customers, otherwise matched by email
+	ForName       string                  `json:"for_name"`
+	ForEmail      string                  `json:"for_email"`
+	OrderDate     string                  `json:"order_date"`
+	Status        string                  `json:"status"`
+	Total         string                  `json:"total"`          // Stored as a string to handle decimal formatting
+	Notes         string                  `json:"notes"`          // Additional notes for the order
+	Allergies     []string                `json:"allergies"`      // one of Allergens each
+	InternalNotes string                  `json:"internal_notes"` // staff only
+	Items         []CreateOrderItemParams `json:"items"`          // Associated order items
+	RedeemRuleID  int                     `json:"redeem_rule_id"` // loyalty reward to redeem, taken off the total
+	TabID         *int                    `json:"tab_id"`         // open tab to add the order to
+	PrepLeadTime  time.Duration           `json:"-"`              // later pickups are scheduled until this long before
+	Anonymous     bool                    `json:"-"`              // placed without logging in, so not matched to a customer by email
+	OrderTypeDetails
 }
 
 type CreateOrderItemParams struct {
@@ -27,72 +74,126 @@ type UpdateOrderParams struct {
 	Status string `json:"status"`
 }
 
-var ErrOrderNotFound = errors.New("order not found")
-
-func (c *Client) GetOrdersJSON() (string, error) {
-	// query formatted like so to return JSON ordered by order_date
-	query := `SELECT json_group_array(json(order_json)) AS orders_json
-		FROM (
-			SELECT
-				'{
-					"id": ' || o.id || ',
-					"for_name": ' || json_quote(o.for_name) || ',
-					"email": ' || json_quote(o.for_email) || ',
-					"order_date": ' || json_quote(o.order_date) || ',
-					"status": ' || json_quote(o.status) || ',
-					"total": ' || o.total || ',
-					"created_at": ' || json_quote(o.created_at) || ',
-					"updated_at": ' || json_quote(o.updated_at) || ',
-					"items": ' || (
-						SELECT COALESCE(json_group_array(
-							json_object(
-								'id', oi.id,
-								'order_id', oi.order_id,
-								'item_name', i.name,
-								'item_description', i.description,
-								'quantity', oi.quantity,
-								'price', oi.price,
-								'notes', oi.notes
-							)
-						), '[]')
-						FROM order_items oi
-						JOIN items i ON oi.item_id = i.id
-						WHERE oi.order_id = o.id
-						ORDER BY oi.id
-					) || '
-				}' AS order_json
-			FROM orders o
-			WHERE o.status != "completed"
-			ORDER BY o.order_date ASC
-		); `
-
-	var ordersJSON string
-	err := c.db.QueryRow(query).Scan(&ordersJSON)
-
-	return ordersJSON, err
-}
+// Order statuses with special handling. Other statuses are set freely by the kitchen and front of house.
+const (
+	OrderStatusScheduled = "scheduled" // waiting to be released to the kitchen, see ReleaseScheduledOrders
+	OrderStatusPending   = "pending"
+	OrderStatusReady     = "ready" // every station has bumped its ticket
+	OrderStatusCompleted = "completed"
+	OrderStatusVoided    = "voided"
+	OrderStatusRefunded  = "refunded"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	ErrCantSchedule  = errors.New("orders are only scheduled when placed for a later pickup")
+	ErrOrderReversed = errors.New("order was voided or refunded and can't change status")
+)
 
 func (c *Client) CreateOrder(order CreateOrderParams) (int, error) {
+	if _, err := decimal.NewFromString(order.Total); err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidOrderTotal, order.Total)
+	}
+	allergies, err := NormalizeAllergies(order.Allergies)
+	if err != nil {
+		return 0, err
+	}
+	encodedAllergies, err := json.Marshal(allergies)
+	if err != nil {
+		return 0, err
+	}
+
 	// Begin a transaction
 	tx, err := c.db.Begin()
 	if err != nil {
 		return 0, err
 	}
 
+	if order.TabID != nil {
+		if err := orderTab(tx, &order); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := order.OrderTypeDetails.Validate(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	releaseAt, err := orderReleaseAt(order, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var scheduledUntil string
+	if !releaseAt.IsZero() {
+		order.Status = OrderStatusScheduled
+		scheduledUntil = releaseAt.UTC().Format(TIME_LAYOUT)
+	} else if order.Status == OrderStatusScheduled {
+		tx.Rollback()
+		return 0, ErrCantSchedule
+	}
+
+	customerID, err := orderCustomer(tx, &order)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	loyaltyEntries, err := applyOrderLoyalty(tx, customerID, &order)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// Loyalty points aren't earned on the delivery fee, so it is added after.
+	if order.DeliveryFee != "" {
+		total, _ := decimal.NewFromString(order.Total)
+		fee, _ := decimal.NewFromString(order.DeliveryFee)
+		order.Total = total.Add(fee).StringFixed(2)
+	}
+
+	// scheduled orders are numbered when they go to the kitchen, on the day they are made
+	var businessDay string
+	var ticketNumber int
+	if order.Status != OrderStatusScheduled {
+		businessDay, ticketNumber, err = c.nextTicketNumber(tx)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
 	// Insert the order and get its ID
 	orderQuery := `
-		INSERT INTO orders (for_name, for_email, order_date, status, total, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO orders (customer_id, for_name, for_email, order_date, status, total, notes, allergies, internal_notes,
+			business_day, ticket_number, order_type, table_number, covers, pickup_at, delivery_address, delivery_fee, tab_id, release_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), ?, NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''),
+			CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		RETURNING id
 	`
 
 	var orderID int
 	err = tx.QueryRow(orderQuery,
+		customerID,
 		order.ForName,
 		order.ForEmail,
 		order.OrderDate,
 		order.Status,
 		order.Total,
+		strings.TrimSpace(order.Notes),
+		string(encodedAllergies),
+		strings.TrimSpace(order.InternalNotes),
+		businessDay,
+		ticketNumber,
+		order.Type,
+		order.TableNumber,
+		order.Covers,
+		order.PickupAt,
+		order.DeliveryAddress,
+		order.DeliveryFee,
+		order.TabID,
+		scheduledUntil,
 	).Scan(&orderID)
 	if err != nil {
 		tx.Rollback()
@@ -120,6 +221,16 @@ func (c *Client) CreateOrder(order CreateOrderParams) (int, error) {
 		}
 	}
 
+	if err := insertLoyaltyEntries(tx, customerID, orderID, loyaltyEntries); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if _, err := routeOrder(tx, orderID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		return 0, err
@@ -128,19 +239,416 @@ func (c *Client) CreateOrder(order CreateOrderParams) (int, error) {
 	return orderID, nil
 }
 
+// orderCustomer resolves the customer an order belongs to: the given customer ID, or the customer
+// with the order's email, created on first order. Fills in the order's name and email from a known
+// customer if they were left blank. Returns nil for orders without an email and anonymous orders,
+// which anyone could place under someone else's email.
+func orderCustomer(tx *sql.Tx, order *CreateOrderParams) (*string, error) {
+	if order.CustomerID != nil {
+		var name, email string
+		err := tx.QueryRow(`SELECT name, COALESCE(email, '') FROM customers WHERE id = ?`, order.CustomerID.String()).Scan(&name, &email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCustomerNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if order.ForName == "" {
+			order.ForName = name
+		}
+		if order.ForEmail == "" {
+			order.ForEmail = email
+		}
+		id := order.CustomerID.String()
+		return &id, nil
+	}
+
+	if order.Anonymous || NormalizeEmail(order.ForEmail) == "" {
+		return nil, nil
+	}
+	id, err := upsertCustomer(tx, CreateCustomerParams{Name: order.ForName, Email: order.ForEmail})
+	if err != nil {
+		return nil, err
+	}
+	s := id.String()
+	return &s, nil
+}
+
+// Sort keys for GetOrders. Orders with the same key are ordered by ID.
+const (
+	OrderSortOrderDate = "order_date"
+	OrderSortCreatedAt = "created_at"
+	OrderSortUpdatedAt = "updated_at"
+	OrderSortTotal     = "total"
+)
+
+// orderSorts maps sort keys to the column sorted on and how a cursor value compares against it.
+var orderSorts = map[string]struct{ expr, arg string }{
+	OrderSortOrderDate: {"COALESCE(o.order_date, '')", "?"},
+	OrderSortCreatedAt: {"o.created_at", "?"},
+	OrderSortUpdatedAt: {"o.updated_at", "?"},
+	OrderSortTotal:     {"CAST(o.total AS REAL)", "CAST(? AS REAL)"},
+}
+
+type OrderFilter struct {
+	Statuses        []string // only orders in one of these statuses; empty means any
+	Types           []string // only orders of these types; empty means any
+	ExcludeStatuses []string
+	Since           *time.Time // order_date range
+	Until           *time.Time
+	Email           string
+	Search          string // matches name, email, order ID, ticket number or item names
+	Sort            string // one of the OrderSort keys, order_date if empty
+	Descending      bool
+	Cursor          string // NextCursor from the previous page
+	Limit           int
+}
+
+// OrderCursor is where a page of orders ended: the last order's sort value and ID. It is sent to
+// clients base64 encoded and only valid with the same sort.
+type OrderCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         int    `json:"id"`
+}
+
+var (
+	ErrInvalidOrderSort   = errors.New("invalid order sort")
+	ErrInvalidOrderCursor = errors.New("invalid order cursor")
+)
+
+func ValidOrderSort(sort string) bool {
+	_, ok := orderSorts[sort]
+	return ok
+}
+
+func (cur OrderCursor) Encode() string {
+	dat, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeOrderCursor(s string) (OrderCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidOrderCursor
+	}
+	var cur OrderCursor
+	if err := json.Unmarshal(dat, &cur); err != nil || cur.ID <= 0 {
+		return OrderCursor{}, ErrInvalidOrderCursor
+	}
+	return cur, nil
+}
+
+// GetOrders returns a page of orders matching filter with their items, and the cursor of the next
+// page, which is empty on the last page.
+func (c *Client) GetOrders(filter OrderFilter) ([]Order, string, error) {
+	if filter.Sort == "" {
+		filter.Sort = OrderSortOrderDate
+	}
+	sort, ok := orderSorts[filter.Sort]
+	if !ok {
+		return nil, "", ErrInvalidOrderSort
+	}
+
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "o.status IN ("+placeholders(len(filter.Statuses))+")")
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(filter.ExcludeStatuses) > 0 {
+		conditions = append(conditions, "o.status NOT IN ("+placeholders(len(filter.ExcludeStatuses))+")")
+		for _, status := range filter.ExcludeStatuses {
+			args = append(args, status)
+		}
+	}
+	if len(filter.Types) > 0 {
+		conditions = append(conditions, "o.order_type IN ("+placeholders(len(filter.Types))+")")
+		for _, orderType := range filter.Types {
+			args = append(args, orderType)
+		}
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "o.order_date >= ?")
+		args = append(args, filter.Since.UTC().Format(TIME_LAYOUT))
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "o.order_date < ?")
+		args = append(args, filter.Until.UTC().Format(TIME_LAYOUT))
+	}
+	if email := NormalizeEmail(filter.Email); email != "" {
+		conditions = append(conditions, "LOWER(o.for_email) = ?")
+		args = append(args, email)
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		conditions = append(conditions, `(
+			o.for_name LIKE ? ESCAPE '\' OR o.for_email LIKE ? ESCAPE '\' OR CAST(o.id AS TEXT) = ?
+			OR CAST(o.ticket_number AS TEXT) = ?
+			OR EXISTS (
+				SELECT 1 FROM order_items oi JOIN items i ON oi.item_id = i.id
+				WHERE oi.order_id = o.id AND i.name LIKE ? ESCAPE '\'
+			)
+		)`)
+		pattern := likeContains(search)
+		args = append(args, pattern, pattern, search, search, pattern)
+	}
+
+	cmp, dir := ">", "ASC"
+	if filter.Descending {
+		cmp, dir = "<", "DESC"
+	}
+	if filter.Cursor != "" {
+		cur, err := decodeOrderCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if cur.Sort != filter.Sort || cur.Descending != filter.Descending {
+			return nil, "", ErrInvalidOrderCursor
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND o.id %[2]s ?))", sort.expr, cmp, sort.arg))
+		args = append(args, cur.Value, cur.Value, cur.ID)
+	}
+
+	// Fetch one extra order to know whether there is another page.
+	args = append(args, filter.Limit+1)
+	orders, err := c.queryOrders(strings.Join(conditions, " AND "),
+		fmt.Sprintf("%s %s, o.id %s LIMIT ?", sort.expr, dir, dir), args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		next = OrderCursor{
+			Sort:       filter.Sort,
+			Descending: filter.Descending,
+			Value:      orderSortValue(last, filter.Sort),
+			ID:         last.ID,
+		}.Encode()
+	}
+
+	if err := c.loadOrderItems(orders); err != nil {
+		return nil, "", err
+	}
+	return orders, next, nil
+}
+
+func orderSortValue(order Order, sort string) string {
+	switch sort {
+	case OrderSortCreatedAt:
+		return order.CreatedAt
+	case OrderSortUpdatedAt:
+		return order.UpdatedAt
+	case OrderSortTotal:
+		return order.Total.String()
+	}
+	return order.OrderDate
+}
+
+// GetOrder returns an order with its items, or an empty Order if none exists.
+func (c *Client) GetOrder(id int) (Order, error) {
+	orders, err := c.queryOrders("o.id = ?", "o.id", id)
+	if err != nil || len(orders) == 0 {
+		return Order{}, err
+	}
+
+	if err := c.loadOrderItems(orders); err != nil {
+		return Order{}, err
+	}
+	return orders[0], nil
+}
+
+// GetOrdersByCustomer returns all of a customer's orders, newest first, with their items.
+func (c *Client) GetOrdersByCustomer(customerID uuid.UUID) ([]Order, error) {
+	orders, err := c.queryOrders("o.customer_id = ?", "o.created_at DESC, o.id DESC", customerID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.loadOrderItems(orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// queryOrders returns the orders matching where, without their items.
+func (c *Client) queryOrders(where, orderBy string, args ...interface{}) ([]Order, error) {
+	query := `
+		SELECT o.id, o.customer_id, o.tab_id, o.for_name, o.for_email, COALESCE(o.order_date, ''), o.status, o.total, o.created_at, o.updated_at,
+			COALESCE(o.notes, ''), o.allergies, COALESCE(o.internal_notes, ''), COALESCE(o.business_day, ''), COALESCE(o.ticket_number, 0),
+			o.order_type, COALESCE(o.table_number, ''), COALESCE(o.covers, 0), COALESCE(o.pickup_at, ''),
+			COALESCE(o.delivery_address, ''), COALESCE(o.delivery_fee, ''), COALESCE(o.release_at, '')
+		FROM orders o
+		WHERE ` + where + `
+		ORDER BY ` + orderBy
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		var order Order
+		var customerID *string
+		var total, allergies string
+		if err := rows.Scan(&order.ID, &customerID, &order.TabID, &order.ForName, &order.ForEmail, &order.OrderDate, &order.Status,
+			&total, &order.CreatedAt, &order.UpdatedAt, &order.Notes, &allergies, &order.InternalNotes,
+			&order.BusinessDay, &order.TicketNumber, &order.Type, &order.TableNumber, &order.Covers, &order.PickupAt,
+			&order.DeliveryAddress, &order.DeliveryFee, &order.ReleaseAt); err != nil {
+			return nil, err
+		}
+		if order.Allergies, err = parseAllergies(allergies); err != nil {
+			return nil, err
+		}
+		if order.CustomerID, err = parseUUIDPtr(customerID); err != nil {
+			return nil, err
+		}
+		order.Total = orderTotal(total)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// orderTotal returns a stored total as a JSON number. Totals that aren't numeric, which older
+// versions accepted, are returned as an empty number that encodes as 0 rather than breaking the
+// whole response.
+func orderTotal(total string) json.Number {
+	d, err := decimal.NewFromString(total)
+	if err != nil {
+		return ""
+	}
+	if !json.Valid([]byte(total)) {
+		// e.g. ".50", which is a valid decimal but not a valid JSON number
+		return json.Number(d.String())
+	}
+	return json.Number(total)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// loadOrderItems fills in the items of every order with a single query.
+func (c *Client) loadOrderItems(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Order, len(orders))
+	args := make([]interface{}, len(orders))
+	for i := range orders {
+		orders[i].Items = []OrderItem{}
+		byID[orders[i].ID] = &orders[i]
+		args[i] = orders[i].ID
+	}
+
+	query := `
+		SELECT oi.id, oi.order_id, oi.item_id, COALESCE(i.name, ''), COALESCE(i.description, ''), oi.quantity, oi.price, COALESCE(oi.notes, ''),
+			COALESCE(oi.station, '')
+		FROM order_items oi
+		LEFT JOIN items i ON oi.item_id = i.id
+		WHERE oi.order_id IN (` + placeholders(len(orders)) + `)
+		ORDER BY oi.id
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ItemID, &item.ItemName, &item.ItemDescription, &item.Quantity,
+			&item.Price, &item.Notes, &item.Station); err != nil {
+			return err
+		}
+		if order, ok := byID[item.OrderID]; ok {
+			order.Items = append(order.Items, item)
+		}
+	}
+
+	return rows.Err()
+}
+
+// UpdateOrder sets an order's status. Refunding or voiding an order reverses its loyalty points and
+// gift card payments in the same transaction, and is final: the order can't be moved to another
+// status afterwards.
 func (c *Client) UpdateOrder(order UpdateOrderParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var prevStatus string
+	err = tx.QueryRow(`SELECT status FROM orders WHERE id = ?`, order.ID).Scan(&prevStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// a scheduled order needs a release time, which only CreateOrder sets
+	if order.Status == OrderStatusScheduled && prevStatus != OrderStatusScheduled {
+		tx.Rollback()
+		return ErrCantSchedule
+	}
+	// reopening would let the order be reversed, and its payments given back, again
+	if isReversedStatus(prevStatus) && order.Status != prevStatus {
+		tx.Rollback()
+		return ErrOrderReversed
+	}
+
 	query := `
 		UPDATE orders
 		SET status = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
 
-	_, err := c.db.Exec(query, order.Status, order.ID)
+	_, err = tx.Exec(query, order.Status, order.ID)
 	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("failed to update order: %v", err)
 	}
 
-	return nil
+	if isReversedStatus(order.Status) && !isReversedStatus(prevStatus) {
+		if err := reverseOrder(tx, order.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// e.g. a scheduled order released early by hand
+	if prevStatus == OrderStatusScheduled && order.Status != OrderStatusScheduled {
+		if !isReversedStatus(order.Status) {
+			if err := c.assignTicketNumber(tx, order.ID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := routeOrder(tx, order.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// isReversedStatus reports whether an order in status no longer counts as a sale.
+func isReversedStatus(status string) bool {
+	return status == OrderStatusVoided || status == OrderStatusRefunded
 }
 
 // DeleteOrder removes an order by ID
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrders(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 450})
+	require.NoError(t, err)
+	_, err = c.CreateItem(CreateItemParams{Name: "Muffin", Cost: 300})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	itemIDs := map[string]string{}
+	for _, item := range items {
+		itemIDs[item.Name] = item.ID
+	}
+
+	newOrder := func(name, email, date, status, total, item string) int {
+		id, err := c.CreateOrder(CreateOrderParams{
+			ForName:   name,
+			ForEmail:  email,
+			OrderDate: date,
+			Status:    status,
+			Total:     total,
+			Items:     []CreateOrderItemParams{{ItemID: itemIDs[item], Quantity: 1, Price: total}},
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	first := newOrder("Ada", "ada@example.com", "2025-01-01 08:00:00", "pending", "4.50", "Latte")
+	second := newOrder("Grace", "grace@example.com", "2025-01-01 09:00:00", "pending", "3.00", "Muffin")
+	third := newOrder("Ada", "ADA@example.com", "2025-01-02 08:00:00", OrderStatusCompleted, "7.50", "Latte")
+
+	ids := func(orders []Order) []int {
+		out := []int{}
+		for _, order := range orders {
+			out = append(out, order.ID)
+		}
+		return out
+	}
+
+	t.Run("Get one", func(t *testing.T) {
+		order, err := c.GetOrder(first)
+		require.NoError(t, err)
+		require.Equal(t, "Ada", order.ForName)
+		require.Len(t, order.Items, 1)
+		require.Equal(t, "Latte", order.Items[0].ItemName)
+
+		missing, err := c.GetOrder(9999)
+		require.NoError(t, err)
+		require.Equal(t, 0, missing.ID)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		orders, _, err := c.GetOrders(OrderFilter{ExcludeStatuses: []string{OrderStatusCompleted}, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int{first, second}, ids(orders))
+
+		orders, _, err = c.GetOrders(OrderFilter{Statuses: []string{OrderStatusCompleted}, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int{third}, ids(orders))
+
+		orders, _, err = c.GetOrders(OrderFilter{Email: "Ada@Example.com", Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int{first, third}, ids(orders), "Email should match case-insensitively")
+
+		orders, _, err = c.GetOrders(OrderFilter{Search: "muff", Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int{second}, ids(orders), "Search should match item names")
+
+		orders, _, err = c.GetOrders(OrderFilter{Search: "%", Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, orders, "Wildcards in the search should be taken literally")
+
+		since := time.Date(2025, 1, 1, 8, 30, 0, 0, time.UTC)
+		until := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+		orders, _, err = c.GetOrders(OrderFilter{Since: &since, Until: &until, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int{second}, ids(orders))
+	})
+
+	t.Run("Notes", func(t *testing.T) {
+		id, err := c.CreateOrder(CreateOrderParams{
+			ForName:       "Ada",
+			Status:        "pending",
+			Total:         "4.50",
+			Notes:         " allergy: peanuts ",
+			Allergies:     []string{"Peanuts", "dairy", "peanuts"},
+			InternalNotes: "VIP",
+		})
+		require.NoError(t, err)
+		defer c.DeleteOrder(id)
+
+		order, err := c.GetOrder(id)
+		require.NoError(t, err)
+		require.Equal(t, OrderNotes{Notes: "allergy: peanuts", Allergies: []string{"dairy", "peanuts"}, InternalNotes: "VIP"}, order.OrderNotes)
+
+		_, err = c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "1.00", Allergies: []string{"cilantro"}})
+		require.ErrorIs(t, err, ErrInvalidAllergen)
+
+		notes := "no foam"
+		prev, err := c.UpdateOrderNotes(UpdateOrderNotesParams{ID: id, Notes: &notes, Allergies: &[]string{}})
+		require.NoError(t, err)
+		require.Equal(t, order.OrderNotes, prev)
+
+		order, err = c.GetOrder(id)
+		require.NoError(t, err)
+		require.Equal(t, OrderNotes{Notes: "no foam", Allergies: []string{}, InternalNotes: "VIP"}, order.OrderNotes, "Internal notes weren't sent and should be kept")
+
+		_, err = c.UpdateOrderNotes(UpdateOrderNotesParams{ID: 9999, Notes: &notes})
+		require.ErrorIs(t, err, ErrOrderNotFound)
+	})
+
+	t.Run("Totals", func(t *testing.T) {
+		_, err := c.CreateOrder(CreateOrderParams{ForName: "Bad", Status: "pending", Total: "four fifty"})
+		require.ErrorIs(t, err, ErrInvalidOrderTotal)
+
+		// rows written before totals were validated
+		_, err = c.db.Exec(`UPDATE orders SET total = 'abc' WHERE id = ?`, second)
+		require.NoError(t, err)
+		defer c.db.Exec(`UPDATE orders SET total = '3.00' WHERE id = ?`, second)
+
+		orders, _, err := c.GetOrders(OrderFilter{Limit: 10})
+		require.NoError(t, err)
+		_, err = json.Marshal(orders)
+		require.NoError(t, err, "A bad total shouldn't break encoding the list")
+
+		order, err := c.GetOrder(first)
+		require.NoError(t, err)
+		require.Equal(t, json.Number("4.50"), order.Total)
+	})
+
+	t.Run("Sort and paginate", func(t *testing.T) {
+		page, next, err := c.GetOrders(OrderFilter{Sort: OrderSortTotal, Descending: true, Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, []int{third, first}, ids(page))
+		require.NotEmpty(t, next)
+
+		page, next, err = c.GetOrders(OrderFilter{Sort: OrderSortTotal, Descending: true, Cursor: next, Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, []int{second}, ids(page))
+		require.Empty(t, next)
+
+		_, _, err = c.GetOrders(OrderFilter{Sort: OrderSortCreatedAt, Cursor: OrderCursor{Sort: OrderSortTotal, ID: 1}.Encode(), Limit: 2})
+		require.ErrorIs(t, err, ErrInvalidOrderCursor, "Cursor from another sort should be rejected")
+
+		_, _, err = c.GetOrders(OrderFilter{Sort: "name", Limit: 2})
+		require.ErrorIs(t, err, ErrInvalidOrderSort)
+	})
+}
@@ -0,0 +1,407 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Payment tenders.
+const (
+	TenderCash        = "cash"
+	TenderCard        = "card"
+	TenderGiftCard    = "gift_card"
+	TenderStoreCredit = "store_credit"
+)
+
+type Payment struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReversedAt *time.Time `json:"reversed_at"` // set once the order is voided or refunded
+	CreatePaymentParams
+}
+
+type CreatePaymentParams struct {
+	OrderID     int        `json:"order_id"`
+	Tender      string     `json:"tender"`
+	AmountCents int        `json:"amount_cents"`
+	GiftCardID  *uuid.UUID `json:"gift_card_id"` // for gift card and store credit tenders
+	Reference   string     `json:"reference"`    // e.g. the card terminal's transaction ID
+}
+
+// PaymentSummary is what has been paid towards an order and what is still due.
+type PaymentSummary struct {
+	TotalCents int       `json:"total_cents"`
+	PaidCents  int       `json:"paid_cents"`
+	DueCents   int       `json:"due_cents"`
+	Payments   []Payment `json:"payments"`
+}
+
+var (
+	ErrOrderAlreadyPaid = errors.New("order is already paid")
+	ErrOrderNotPayable  = errors.New("order was voided or refunded")
+	ErrInvalidTender    = errors.New("invalid tender")
+	ErrNothingToCredit  = errors.New("order has no payments to refund as store credit")
+)
+
+func ValidTender(tender string) bool {
+	switch tender {
+	case TenderCash, TenderCard, TenderGiftCard, TenderStoreCredit:
+		return true
+	}
+	return false
+}
+
+// CreatePayment records a payment towards an order. The amount is capped at what is still due and,
+// for gift cards and store credit, at the card's balance, so a card can pay part of an order. The
+// card is debited in the same transaction. Marks the order paid once nothing is due.
+func (c *Client) CreatePayment(params CreatePaymentParams) (Payment, error) {
+	if !ValidTender(params.Tender) || params.AmountCents <= 0 {
+		return Payment{}, ErrInvalidTender
+	}
+	usesCard := params.Tender == TenderGiftCard || params.Tender == TenderStoreCredit
+	if usesCard != (params.GiftCardID != nil) {
+		return Payment{}, ErrInvalidTender
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Payment{}, err
+	}
+
+	totalCents, paidCents, status, err := orderPaymentState(tx, params.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return Payment{}, err
+	}
+	if isReversedStatus(status) {
+		tx.Rollback()
+		return Payment{}, ErrOrderNotPayable
+	}
+	due := totalCents - paidCents
+	if due <= 0 {
+		tx.Rollback()
+		return Payment{}, ErrOrderAlreadyPaid
+	}
+	amount := min(params.AmountCents, due)
+
+	if usesCard {
+		var balance int
+		var kind string
+		err := tx.QueryRow(`SELECT balance_cents, kind FROM gift_cards WHERE id = ?`, params.GiftCardID.String()).Scan(&balance, &kind)
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return Payment{}, ErrGiftCardNotFound
+		}
+		if err != nil {
+			tx.Rollback()
+			return Payment{}, err
+		}
+		if kind != params.Tender {
+			tx.Rollback()
+			return Payment{}, ErrInvalidTender
+		}
+
+		amount = min(amount, balance)
+		if amount == 0 {
+			tx.Rollback()
+			return Payment{}, ErrGiftCardInsufficient
+		}
+		if err := debitGiftCard(tx, *params.GiftCardID, params.OrderID, amount); err != nil {
+			tx.Rollback()
+			return Payment{}, err
+		}
+	}
+
+	var giftCardID *string
+	if params.GiftCardID != nil {
+		s := params.GiftCardID.String()
+		giftCardID = &s
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO payments (created_at, order_id, tender, amount_cents, gift_card_id, reference)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, params.OrderID, params.Tender, amount, giftCardID, params.Reference).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return Payment{}, fmt.Errorf("couldn't record payment: %w", err)
+	}
+
+	if paidCents+amount >= totalCents {
+		_, err = tx.Exec(`UPDATE orders SET paid_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, params.OrderID)
+		if err != nil {
+			tx.Rollback()
+			return Payment{}, fmt.Errorf("couldn't mark order paid: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Payment{}, err
+	}
+
+	payments, err := c.queryPayments(`id = ?`, `id`, 0, id)
+	if err != nil || len(payments) == 0 {
+		return Payment{}, err
+	}
+	return payments[0], nil
+}
+
+// orderPaymentState returns an order's total and what has been paid towards it.
+func orderPaymentState(q rowQuerier, orderID int) (totalCents, paidCents int, status string, err error) {
+	var total string
+	err = q.QueryRow(`
+		SELECT o.total, o.status, COALESCE((SELECT SUM(p.amount_cents) FROM payments p WHERE p.order_id = o.id), 0)
+		FROM orders o
+		WHERE o.id = ?
+	`, orderID).Scan(&total, &status, &paidCents)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, "", ErrOrderNotFound
+	}
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	totalCents, err = totalToCents(total)
+	return totalCents, paidCents, status, err
+}
+
+func totalToCents(total string) (int, error) {
+	d, err := decimal.NewFromString(total)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidOrderTotal, total)
+	}
+	return int(d.Mul(decimal.NewFromInt(100)).Round(0).IntPart()), nil
+}
+
+// GetPayments lists the payments made towards an order, oldest first.
+func (c *Client) GetPayments(orderID int) ([]Payment, error) {
+	return c.queryPayments(`order_id = ?`, `id`, 0, orderID)
+}
+
+// PaymentFilter pages through the payments of every order, e.g. for an accounting export.
+type PaymentFilter struct {
+	Since   *time.Time // on created_at, inclusive
+	Until   *time.Time // exclusive
+	AfterID int        // last ID of the previous page
+	Limit   int
+}
+
+// GetAllPayments lists payments across orders, oldest first.
+func (c *Client) GetAllPayments(filter PaymentFilter) ([]Payment, error) {
+	where := `id > ?`
+	args := []interface{}{filter.AfterID}
+	if filter.Since != nil {
+		where += ` AND created_at >= ?`
+		args = append(args, filter.Since.UTC().Format(TIME_LAYOUT))
+	}
+	if filter.Until != nil {
+		where += ` AND created_at < ?`
+		args = append(args, filter.Until.UTC().Format(TIME_LAYOUT))
+	}
+	return c.queryPayments(where, `id`, filter.Limit, args...)
+}
+
+// queryPayments returns the payments matching where, sorted by orderBy. A limit of 0 returns them all.
+func (c *Client) queryPayments(where, orderBy string, limit int, args ...interface{}) ([]Payment, error) {
+	query := `
+		SELECT id, created_at, reversed_at, order_id, tender, amount_cents, gift_card_id, reference
+		FROM payments
+		WHERE ` + where + `
+		ORDER BY ` + orderBy
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		var p Payment
+		var created_at string
+		var reversedAt, giftCardID *string
+		if err := rows.Scan(&p.ID, &created_at, &reversedAt, &p.OrderID, &p.Tender, &p.AmountCents, &giftCardID, &p.Reference); err != nil {
+			return nil, err
+		}
+		if p.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		if p.ReversedAt, err = parseTimePtr(reversedAt); err != nil {
+			return nil, err
+		}
+		if p.GiftCardID, err = parseUUIDPtr(giftCardID); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// GetPaymentSummary returns an order's payments with what is still due.
+func (c *Client) GetPaymentSummary(orderID int) (PaymentSummary, error) {
+	totalCents, paidCents, _, err := orderPaymentState(c.db, orderID)
+	if err != nil {
+		return PaymentSummary{}, err
+	}
+
+	payments, err := c.GetPayments(orderID)
+	if err != nil {
+		return PaymentSummary{}, err
+	}
+
+	return PaymentSummary{
+		TotalCents: totalCents,
+		PaidCents:  paidCents,
+		DueCents:   max(totalCents-paidCents, 0),
+		Payments:   payments,
+	}, nil
+}
+
+// reverseOrder undoes an order's side effects when it is voided or refunded: loyalty points are
+// reversed and gift card payments go back onto their cards. Other tenders are refunded outside the
+// POS, or as store credit by RefundOrder. Every payment is marked reversed so none is given back twice.
+func reverseOrder(tx *sql.Tx, orderID int) error {
+	if err := reverseOrderLoyalty(tx, orderID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT gift_card_id, amount_cents FROM payments WHERE order_id = ? AND gift_card_id IS NOT NULL AND reversed_at IS NULL
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	type cardPayment struct {
+		id     uuid.UUID
+		amount int
+	}
+	cardPayments := []cardPayment{}
+	for rows.Next() {
+		var id string
+		var p cardPayment
+		if err := rows.Scan(&id, &p.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		if p.id, err = uuid.Parse(id); err != nil {
+			rows.Close()
+			return err
+		}
+		cardPayments = append(cardPayments, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range cardPayments {
+		if err := creditGiftCard(tx, p.id, orderID, p.amount); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE payments SET reversed_at = CURRENT_TIMESTAMP WHERE order_id = ? AND reversed_at IS NULL`, orderID)
+	if err != nil {
+		return fmt.Errorf("couldn't mark payments reversed: %w", err)
+	}
+	return nil
+}
+
+type RefundOrderParams struct {
+	OrderID     int
+	StoreCredit bool // refund what wasn't paid by gift card as store credit
+}
+
+// RefundOrder marks an order refunded and reverses it. With StoreCredit, the part paid other than by
+// gift card is issued as store credit to the order's customer; orders without payments return
+// ErrNothingToCredit, as there is no money to give back. Returns the store credit card, or an empty
+// GiftCard.
+func (c *Client) RefundOrder(params RefundOrderParams) (GiftCard, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return GiftCard{}, err
+	}
+
+	_, _, status, err := orderPaymentState(tx, params.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return GiftCard{}, err
+	}
+	if isReversedStatus(status) {
+		tx.Rollback()
+		return GiftCard{}, ErrOrderNotPayable
+	}
+
+	// only payments not given back yet count, so an order's money is never credited twice
+	var paidCents, cardPaidCents int
+	var customerID *string
+	err = tx.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(amount_cents) FROM payments WHERE order_id = o.id AND reversed_at IS NULL), 0),
+			COALESCE((SELECT SUM(amount_cents) FROM payments WHERE order_id = o.id AND reversed_at IS NULL AND gift_card_id IS NOT NULL), 0),
+			o.customer_id
+		FROM orders o
+		WHERE o.id = ?
+	`, params.OrderID).Scan(&paidCents, &cardPaidCents, &customerID)
+	if err != nil {
+		tx.Rollback()
+		return GiftCard{}, err
+	}
+	if params.StoreCredit && paidCents == 0 {
+		tx.Rollback()
+		return GiftCard{}, ErrNothingToCredit
+	}
+
+	_, err = tx.Exec(`UPDATE orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, OrderStatusRefunded, params.OrderID)
+	if err != nil {
+		tx.Rollback()
+		return GiftCard{}, fmt.Errorf("failed to update order: %v", err)
+	}
+
+	if err := reverseOrder(tx, params.OrderID); err != nil {
+		tx.Rollback()
+		return GiftCard{}, err
+	}
+
+	var creditID uuid.UUID
+	if amount := paidCents - cardPaidCents; params.StoreCredit && amount > 0 {
+		customer, err := parseUUIDPtr(customerID)
+		if err != nil {
+			tx.Rollback()
+			return GiftCard{}, err
+		}
+		creditID, err = issueGiftCard(tx, CreateGiftCardParams{
+			Kind:        GiftCardKindStoreCredit,
+			CustomerID:  customer,
+			AmountCents: amount,
+		}, &params.OrderID)
+		if err != nil {
+			tx.Rollback()
+			return GiftCard{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return GiftCard{}, err
+	}
+
+	if creditID == uuid.Nil {
+		return GiftCard{}, nil
+	}
+	return c.GetGiftCard(creditID)
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAllPayments(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	var payments []Payment
+	for _, total := range []string{"4.50", "3.00", "7.50"} {
+		orderID, err := c.CreateOrder(CreateOrderParams{ForName: "Regular", OrderDate: "2025-01-01 12:00:00", Status: "pending", Total: total})
+		require.NoError(t, err)
+		cents, err := totalToCents(total)
+		require.NoError(t, err)
+		payment, err := c.CreatePayment(CreatePaymentParams{OrderID: orderID, Tender: TenderCash, AmountCents: cents})
+		require.NoError(t, err)
+		payments = append(payments, payment)
+	}
+
+	page, err := c.GetAllPayments(PaymentFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, payments[:2], page)
+
+	page, err = c.GetAllPayments(PaymentFilter{AfterID: page[1].ID, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, payments[2:], page, "The next page should start after the cursor")
+
+	hourAgo, inAnHour := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	page, err = c.GetAllPayments(PaymentFilter{Since: &hourAgo, Until: &inAnHour, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, payments, page)
+
+	page, err = c.GetAllPayments(PaymentFilter{Since: &inAnHour, Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+var ErrInvalidKitchenReport = errors.New("invalid report range")
+
+// PrepTimeStats summarizes how long a set of tickets took, in seconds. Percentiles are nearest-rank.
+type PrepTimeStats struct {
+	Count      int     `json:"count"`
+	LateCount  int     `json:"late_count"` // took longer than the target prep time
+	AvgSeconds float64 `json:"avg_seconds"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P90Seconds float64 `json:"p90_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+}
+
+// StationPrepTimes splits a station's prep time, from receiving a ticket to bumping it, into the wait
+// before it was started and the time making it. Tickets bumped without being started only count
+// towards Prep.
+type StationPrepTimes struct {
+	Station string        `json:"station"`
+	Prep    PrepTimeStats `json:"prep"`
+	Wait    PrepTimeStats `json:"wait"`
+	Make    PrepTimeStats `json:"make"`
+}
+
+// ItemPrepTimes is the prep time of the tickets an item was on.
+type ItemPrepTimes struct {
+	ItemID   string        `json:"item_id"`
+	ItemName string        `json:"item_name"`
+	Prep     PrepTimeStats `json:"prep"`
+}
+
+type KitchenReport struct {
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	TargetSeconds float64            `json:"target_seconds"`
+	Stations      []StationPrepTimes `json:"stations"`
+	Items         []ItemPrepTimes    `json:"items"`
+}
+
+// KitchenReportParams covers the orders of business days From to To, inclusive.
+type KitchenReportParams struct {
+	From   string
+	To     string
+	Target time.Duration // tickets taking longer are counted late
+}
+
+// GetKitchenReport returns prep times of the bumped tickets of the orders in a range of business
+// days, by station and by item, busiest first. Voided and refunded orders aren't counted.
+func (c *Client) GetKitchenReport(params KitchenReportParams) (KitchenReport, error) {
+	from, errFrom := time.Parse(time.DateOnly, params.From)
+	to, errTo := time.Parse(time.DateOnly, params.To)
+	if errFrom != nil || errTo != nil || to.Before(from) {
+		return KitchenReport{}, ErrInvalidKitchenReport
+	}
+
+	rows, err := c.db.Query(`
+		SELECT t.id, t.station, t.created_at, t.started_at, t.bumped_at, COALESCE(oi.item_id, ''), COALESCE(i.name, '')
+		FROM station_tickets t
+		JOIN orders o ON o.id = t.order_id
+		LEFT JOIN order_items oi ON oi.order_id = t.order_id AND oi.station = t.station
+		LEFT JOIN items i ON i.id = oi.item_id
+		WHERE t.status = ? AND t.bumped_at IS NOT NULL AND o.business_day BETWEEN ? AND ? AND o.status NOT IN (?, ?)
+		ORDER BY t.id
+	`, StationTicketBumped, params.From, params.To, OrderStatusVoided, OrderStatusRefunded)
+	if err != nil {
+		return KitchenReport{}, err
+	}
+	defer rows.Close()
+
+	type durations struct {
+		prep, wait, make []time.Duration
+	}
+	stations := map[string]*durations{}
+	items := map[string]*ItemPrepTimes{}
+	itemPrep := map[string][]time.Duration{}
+
+	lastTicket := 0
+	var prep time.Duration
+	for rows.Next() {
+		var ticketID int
+		var station, createdAt, itemID, itemName string
+		var startedAt, bumpedAt *string
+		if err := rows.Scan(&ticketID, &station, &createdAt, &startedAt, &bumpedAt, &itemID, &itemName); err != nil {
+			return KitchenReport{}, err
+		}
+
+		// a ticket is listed once per item on it
+		if ticketID != lastTicket {
+			lastTicket = ticketID
+			received, err := time.Parse(TIME_LAYOUT, createdAt)
+			if err != nil {
+				return KitchenReport{}, err
+			}
+			started, err := parseTimePtr(startedAt)
+			if err != nil {
+				return KitchenReport{}, err
+			}
+			bumped, err := parseTimePtr(bumpedAt)
+			if err != nil {
+				return KitchenReport{}, err
+			}
+
+			d := stations[station]
+			if d == nil {
+				d = &durations{}
+				stations[station] = d
+			}
+			prep = bumped.Sub(received)
+			d.prep = append(d.prep, prep)
+			if started != nil {
+				d.wait = append(d.wait, started.Sub(received))
+				d.make = append(d.make, bumped.Sub(*started))
+			}
+		}
+
+		if itemID == "" {
+			continue
+		}
+		if items[itemID] == nil {
+			items[itemID] = &ItemPrepTimes{ItemID: itemID, ItemName: itemName}
+		}
+		itemPrep[itemID] = append(itemPrep[itemID], prep)
+	}
+	if err := rows.Err(); err != nil {
+		return KitchenReport{}, err
+	}
+
+	report := KitchenReport{
+		From:          params.From,
+		To:            params.To,
+		TargetSeconds: params.Target.Seconds(),
+		Stations:      []StationPrepTimes{},
+		Items:         []ItemPrepTimes{},
+	}
+	for station, d := range stations {
+		report.Stations = append(report.Stations, StationPrepTimes{
+			Station: station,
+			Prep:    prepTimeStats(d.prep, params.Target),
+			Wait:    prepTimeStats(d.wait, 0),
+			Make:    prepTimeStats(d.make, 0),
+		})
+	}
+	for id, item := range items {
+		item.Prep = prepTimeStats(itemPrep[id], params.Target)
+		report.Items = append(report.Items, *item)
+	}
+
+	sort.Slice(report.Stations, func(i, j int) bool {
+		a, b := report.Stations[i], report.Stations[j]
+		if a.Prep.Count != b.Prep.Count {
+			return a.Prep.Count > b.Prep.Count
+		}
+		return a.Station < b.Station
+	})
+	sort.Slice(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if a.Prep.Count != b.Prep.Count {
+			return a.Prep.Count > b.Prep.Count
+		}
+		return a.ItemName < b.ItemName
+	})
+	return report, nil
+}
+
+// prepTimeStats summarizes durations. A zero target counts none late.
+func prepTimeStats(durations []time.Duration, target time.Duration) PrepTimeStats {
+	stats := PrepTimeStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+		if target > 0 && d > target {
+			stats.LateCount++
+		}
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p * float64(len(sorted))))
+		return sorted[max(rank-1, 0)].Seconds()
+	}
+
+	stats.AvgSeconds = math.Round(total.Seconds()/float64(len(sorted))*10) / 10
+	stats.P50Seconds = percentile(0.5)
+	stats.P90Seconds = percentile(0.9)
+	stats.MaxSeconds = sorted[len(sorted)-1].Seconds()
+	return stats
+}
+
+// FlagLateStationTickets marks open tickets received more than target before now as late and returns
+// them. Each ticket is flagged once.
+func (c *Client) FlagLateStationTickets(target time.Duration, now time.Time) ([]StationTicket, error) {
+	rows, err := c.db.Query(`
+		UPDATE station_tickets
+		SET late_at = ?
+		WHERE status = ? AND late_at IS NULL AND created_at <= ?
+			AND order_id IN (SELECT id FROM orders WHERE status NOT IN (?, ?, ?))
+		RETURNING id
+	`, now.UTC().Format(TIME_LAYOUT), StationTicketOpen, now.Add(-target).UTC().Format(TIME_LAYOUT),
+		OrderStatusScheduled, OrderStatusVoided, OrderStatusRefunded)
+	if err != nil {
+		return nil, err
+	}
+	ids := []interface{}{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []StationTicket{}, nil
+	}
+
+	return c.queryStationTickets(`t.id IN (`+placeholders(len(ids))+`)`, `t.created_at, t.id`, ids...)
+}
+
+// NextStationTicketLate returns when the next open ticket goes past target, or the zero time if no
+// open tickets are waiting to.
+func (c *Client) NextStationTicketLate(target time.Duration) (time.Time, error) {
+	var createdAt *string
+	err := c.db.QueryRow(`
+		SELECT MIN(t.created_at)
+		FROM station_tickets t
+		JOIN orders o ON o.id = t.order_id
+		WHERE t.status = ? AND t.late_at IS NULL AND o.status NOT IN (?, ?, ?)
+	`, StationTicketOpen, OrderStatusScheduled, OrderStatusVoided, OrderStatusRefunded).Scan(&createdAt)
+	if err != nil || createdAt == nil {
+		return time.Time{}, err
+	}
+	received, err := time.Parse(TIME_LAYOUT, *createdAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return received.Add(target), nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepTimeStats(t *testing.T) {
+	durations := []time.Duration{}
+	for i := 10; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Minute)
+	}
+
+	stats := prepTimeStats(durations, 8*time.Minute)
+	require.Equal(t, 10, stats.Count)
+	require.Equal(t, 2, stats.LateCount)
+	require.Equal(t, 330.0, stats.AvgSeconds)
+	require.Equal(t, 300.0, stats.P50Seconds)
+	require.Equal(t, 540.0, stats.P90Seconds)
+	require.Equal(t, 600.0, stats.MaxSeconds)
+
+	require.Equal(t, PrepTimeStats{}, prepTimeStats(nil, time.Minute))
+}
+
+func TestKitchenPrepTimes(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.CreateItem(CreateItemParams{Name: "Latte", Cost: 450, Station: StationEspresso})
+	require.NoError(t, err)
+	_, err = c.CreateItem(CreateItemParams{Name: "Toastie", Cost: 800})
+	require.NoError(t, err)
+	items, err := c.GetItems()
+	require.NoError(t, err)
+	ids := map[string]string{}
+	for _, item := range items {
+		ids[item.Name] = item.ID
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newOrder := func(items ...string) int {
+		params := CreateOrderParams{ForName: "Ada", Status: "pending", Total: "12.50"}
+		for _, item := range items {
+			params.Items = append(params.Items, CreateOrderItemParams{ItemID: ids[item], Quantity: 1, Price: "1.00"})
+		}
+		id, err := c.CreateOrder(params)
+		require.NoError(t, err)
+		return id
+	}
+	// received is set by the database, so tickets are moved back in time to control their prep times
+	receivedAgo := func(orderID int, station string, ago time.Duration) StationTicket {
+		_, err := c.db.Exec(`UPDATE station_tickets SET created_at = ? WHERE order_id = ? AND station = ?`,
+			now.Add(-ago).Format(TIME_LAYOUT), orderID, station)
+		require.NoError(t, err)
+		tickets, err := c.GetOrderStationTickets(orderID)
+		require.NoError(t, err)
+		for _, ticket := range tickets {
+			if ticket.Station == station {
+				return ticket
+			}
+		}
+		t.Fatalf("no %s ticket on order %d", station, orderID)
+		return StationTicket{}
+	}
+
+	first := newOrder("Latte", "Toastie")
+	second := newOrder("Latte")
+	latte1 := receivedAgo(first, StationEspresso, 2*time.Minute)
+	toastie := receivedAgo(first, StationHotFood, 9*time.Minute)
+	latte2 := receivedAgo(second, StationEspresso, 6*time.Minute)
+
+	next, err := c.NextStationTicketLate(5 * time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-4*time.Minute), next)
+
+	late, err := c.FlagLateStationTickets(5*time.Minute, now)
+	require.NoError(t, err)
+	require.Len(t, late, 2)
+	require.Equal(t, toastie.ID, late[0].ID, "oldest first")
+	require.NotNil(t, late[0].LateAt)
+	require.Equal(t, latte2.ID, late[1].ID)
+
+	late, err = c.FlagLateStationTickets(5*time.Minute, now)
+	require.NoError(t, err)
+	require.Empty(t, late, "tickets are flagged late once")
+
+	next, err = c.NextStationTicketLate(5 * time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(3*time.Minute), next, "only the ticket that isn't late yet is left")
+
+	_, err = c.StartStationTicket(999)
+	require.ErrorIs(t, err, ErrStationTicketNotFound)
+	ticket, err := c.StartStationTicket(latte1.ID)
+	require.NoError(t, err)
+	require.NotNil(t, ticket.StartedAt)
+	_, err = c.db.Exec(`UPDATE station_tickets SET started_at = ? WHERE id = ?`, now.Add(-time.Minute).Format(TIME_LAYOUT), latte1.ID)
+	require.NoError(t, err)
+
+	for _, ticket := range []StationTicket{latte1, latte2, toastie} {
+		_, _, err := c.BumpStationTicket(ticket.ID)
+		require.NoError(t, err)
+	}
+	// bumped at the same time the tickets were moved relative to
+	_, err = c.db.Exec(`UPDATE station_tickets SET bumped_at = ?`, now.Format(TIME_LAYOUT))
+	require.NoError(t, err)
+
+	today := c.Today()
+	report, err := c.GetKitchenReport(KitchenReportParams{From: today, To: today, Target: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, 300.0, report.TargetSeconds)
+
+	require.Len(t, report.Stations, 2)
+	espresso := report.Stations[0]
+	require.Equal(t, StationEspresso, espresso.Station, "busiest station first")
+	require.Equal(t, PrepTimeStats{Count: 2, LateCount: 1, AvgSeconds: 240, P50Seconds: 120, P90Seconds: 360, MaxSeconds: 360}, espresso.Prep)
+	require.Equal(t, PrepTimeStats{Count: 1, AvgSeconds: 60, P50Seconds: 60, P90Seconds: 60, MaxSeconds: 60}, espresso.Wait)
+	require.Equal(t, PrepTimeStats{Count: 1, AvgSeconds: 60, P50Seconds: 60, P90Seconds: 60, MaxSeconds: 60}, espresso.Make)
+	require.Equal(t, StationHotFood, report.Stations[1].Station)
+	require.Equal(t, 540.0, report.Stations[1].Prep.AvgSeconds)
+	require.Zero(t, report.Stations[1].Wait.Count, "never started")
+
+	require.Len(t, report.Items, 2)
+	require.Equal(t, "Latte", report.Items[0].ItemName)
+	require.Equal(t, 2, report.Items[0].Prep.Count)
+	require.Equal(t, "Toastie", report.Items[1].ItemName)
+	require.Equal(t, 1, report.Items[1].Prep.LateCount)
+
+	require.NoError(t, c.UpdateOrder(UpdateOrderParams{ID: second, Status: OrderStatusVoided}))
+	report, err = c.GetKitchenReport(KitchenReportParams{From: today, To: today, Target: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Stations[0].Prep.Count, "voided orders aren't counted")
+
+	_, err = c.GetKitchenReport(KitchenReportParams{From: today, To: "2000-01-01"})
+	require.ErrorIs(t, err, ErrInvalidKitchenReport)
+
+	// a recalled ticket is timed again from the recall, not from when it was first received
+	_, err = c.StartStationTicket(toastie.ID)
+	require.NoError(t, err)
+	recalled, err := c.RecallStationTicket(toastie.ID)
+	require.NoError(t, err)
+	require.Equal(t, StationTicketOpen, recalled.Status)
+	require.False(t, recalled.CreatedAt.Before(now), "received again")
+	require.Nil(t, recalled.StartedAt)
+	require.Nil(t, recalled.LateAt)
+	late, err = c.FlagLateStationTickets(5*time.Minute, now)
+	require.NoError(t, err)
+	require.Empty(t, late)
+
+	report, err = c.GetKitchenReport(KitchenReportParams{From: today, To: today, Target: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Len(t, report.Stations, 1, "the recalled ticket isn't done yet")
+	require.Equal(t, StationEspresso, report.Stations[0].Station)
+}
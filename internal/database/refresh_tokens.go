@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,28 +11,49 @@ import (
 
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ReplacedBy *string    `json:"-"` // token issued when this one was rotated
 }
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token      string    `json:"token"`
+	UserID     uuid.UUID `json:"user_id"`
+	FamilyID   uuid.UUID `json:"family_id"` // all tokens rotated from the same login share a family
+	ExpiresAt  time.Time `json:"expires_at"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
 }
 
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
 func (c *Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
+			last_used_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			family_id,
+			expires_at,
+			device_name,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt.Format(TIME_LAYOUT))
+	_, err := c.db.Exec(query,
+		params.Token,
+		params.UserID.String(),
+		params.FamilyID.String(),
+		params.ExpiresAt.UTC().Format(TIME_LAYOUT),
+		params.DeviceName,
+		params.UserAgent,
+		params.IPAddress,
+	)
 	if err != nil {
 		return RefreshToken{}, fmt.Errorf("couldn't create refresh token: %w", err)
 	}
@@ -39,6 +61,50 @@ func (c *Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshTok
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes oldToken and issues next in the same family. The old token
+// must still be active; if it was already used, ErrRefreshTokenReused is returned.
+func (c *Client) RotateRefreshToken(oldToken string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND family_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`, next.Token, oldToken, next.FamilyID.String())
+	if err != nil {
+		tx.Rollback()
+		return RefreshToken{}, fmt.Errorf("couldn't revoke refresh token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return RefreshToken{}, err
+	}
+	if n != 1 {
+		tx.Rollback()
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token, created_at, updated_at, last_used_at, user_id, family_id, expires_at, device_name, user_agent, ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`, next.Token, next.UserID.String(), next.FamilyID.String(), next.ExpiresAt.UTC().Format(TIME_LAYOUT), next.DeviceName, next.UserAgent, next.IPAddress)
+	if err != nil {
+		tx.Rollback()
+		return RefreshToken{}, fmt.Errorf("couldn't create refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(next.Token)
+}
+
 func (c *Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
@@ -49,19 +115,32 @@ func (c *Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// RevokeRefreshTokenFamily revokes every active token in a family, ending that login session.
+func (c *Client) RevokeRefreshTokenFamily(familyID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, familyID.String())
+	return err
+}
+
 func (c *Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, family_id, expires_at, revoked_at, replaced_by,
+			last_used_at, COALESCE(device_name, ''), COALESCE(user_agent, ''), COALESCE(ip_address, '')
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
-	var userID string
+	var userID, familyID string
 	var created_at, updated_at, expires_at string
-	var revoked_at *string
+	var revoked_at, last_used_at *string
 
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &created_at, &updated_at, &userID, &expires_at, &revoked_at)
+		Scan(&rt.Token, &created_at, &updated_at, &userID, &familyID, &expires_at, &revoked_at, &rt.ReplacedBy,
+			&last_used_at, &rt.DeviceName, &rt.UserAgent, &rt.IPAddress)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -73,6 +152,10 @@ func (c *Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.FamilyID, err = uuid.Parse(familyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
 
 	rt.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at)
 	if err != nil {
@@ -93,6 +176,13 @@ func (c *Client) GetRefreshToken(token string) (RefreshToken, error) {
 		}
 		rt.RevokedAt = &t
 	}
+	if last_used_at != nil {
+		t, err := time.Parse(TIME_LAYOUT, *last_used_at)
+		if err != nil {
+			return rt, fmt.Errorf("couldn't parse last_used_at: %w", err)
+		}
+		rt.LastUsedAt = &t
+	}
 
 	return rt, nil
 }
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	user, err := c.CreateUser(CreateUserParams{
+		Email:    "rotate@test.com",
+		Password: "testpassword",
+		Role:     "user",
+	})
+	require.NoError(t, err, "Failed to create user")
+
+	familyID := uuid.New()
+	first, err := c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:     "first",
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err, "Failed to create refresh token")
+	require.Equal(t, familyID, first.FamilyID, "Family ID should match")
+
+	t.Run("Rotate active token", func(t *testing.T) {
+		second, err := c.RotateRefreshToken("first", CreateRefreshTokenParams{
+			Token:     "second",
+			UserID:    user.ID,
+			FamilyID:  familyID,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err, "Failed to rotate refresh token")
+		require.Equal(t, familyID, second.FamilyID, "Rotated token should stay in the family")
+
+		old, err := c.GetRefreshToken("first")
+		require.NoError(t, err)
+		require.NotNil(t, old.RevokedAt, "Rotated token should be revoked")
+		require.NotNil(t, old.ReplacedBy, "Rotated token should point to its replacement")
+		require.Equal(t, "second", *old.ReplacedBy)
+
+		u, err := c.GetUserByRefreshToken("first")
+		require.NoError(t, err)
+		require.Equal(t, uuid.Nil, u.ID, "Rotated token should not resolve to a user")
+	})
+
+	t.Run("Reuse of rotated token", func(t *testing.T) {
+		_, err := c.RotateRefreshToken("first", CreateRefreshTokenParams{
+			Token:     "third",
+			UserID:    user.ID,
+			FamilyID:  familyID,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+
+		err = c.RevokeRefreshTokenFamily(familyID)
+		require.NoError(t, err, "Failed to revoke family")
+
+		second, err := c.GetRefreshToken("second")
+		require.NoError(t, err)
+		require.NotNil(t, second.RevokedAt, "Family revocation should revoke the latest token")
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		_, err := c.CreateRefreshToken(CreateRefreshTokenParams{
+			Token:     "expired",
+			UserID:    user.ID,
+			FamilyID:  uuid.New(),
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		require.NoError(t, err)
+
+		u, err := c.GetUserByRefreshToken("expired")
+		require.NoError(t, err)
+		require.Equal(t, uuid.Nil, u.ID, "Expired token should not resolve to a user")
+	})
+}
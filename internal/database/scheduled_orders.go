@@ -0,0 +1,110 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MAX_PICKUP_AHEAD is how far ahead orders can be placed for.
+const MAX_PICKUP_AHEAD = 14 * 24 * time.Hour
+
+// orderReleaseAt returns when an order for a future pickup time should go to the kitchen, PrepLeadTime
+// before pickup, or the zero time if it should go now. Pickup times are UTC like other timestamps.
+func orderReleaseAt(order CreateOrderParams, now time.Time) (time.Time, error) {
+	if order.PickupAt == "" {
+		return time.Time{}, nil
+	}
+	pickup, err := time.Parse(TIME_LAYOUT, order.PickupAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid pickup time", ErrInvalidOrderType)
+	}
+	if pickup.After(now.Add(MAX_PICKUP_AHEAD)) {
+		return time.Time{}, fmt.Errorf("%w: pickup time is more than %d days ahead", ErrInvalidOrderType, MAX_PICKUP_AHEAD/(24*time.Hour))
+	}
+
+	releaseAt := pickup.Add(-order.PrepLeadTime)
+	if !releaseAt.After(now) {
+		return time.Time{}, nil
+	}
+	return releaseAt, nil
+}
+
+// ReleaseScheduledOrders sends scheduled orders whose release time has passed to the kitchen and
+// returns their IDs. Orders that came due while the server was down are released on the next call,
+// and each order is released once even if several calls overlap. An order that can't be released is
+// skipped so it doesn't hold up the rest; the IDs released are returned along with the error.
+func (c *Client) ReleaseScheduledOrders(now time.Time) ([]int, error) {
+	rows, err := c.db.Query(`SELECT id FROM orders WHERE status = ? AND release_at <= ? ORDER BY release_at, id`,
+		OrderStatusScheduled, now.UTC().Format(TIME_LAYOUT))
+	if err != nil {
+		return nil, err
+	}
+	due := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	released := []int{}
+	var errs []error
+	for _, id := range due {
+		ok, err := c.releaseOrder(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't release order %d: %w", id, err))
+			continue
+		}
+		if ok {
+			released = append(released, id)
+		}
+	}
+	return released, errors.Join(errs...)
+}
+
+// releaseOrder sends a scheduled order to the kitchen with the next ticket number. Reports false if it
+// was no longer scheduled.
+func (c *Client) releaseOrder(id int) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec(`UPDATE orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		OrderStatusPending, id, OrderStatusScheduled)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := c.assignTicketNumber(tx, id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err := routeOrder(tx, id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// NextOrderRelease returns when the next scheduled order is due, or the zero time if none are waiting.
+func (c *Client) NextOrderRelease() (time.Time, error) {
+	var releaseAt *string
+	err := c.db.QueryRow(`SELECT MIN(release_at) FROM orders WHERE status = ?`, OrderStatusScheduled).Scan(&releaseAt)
+	if err != nil || releaseAt == nil {
+		return time.Time{}, err
+	}
+	return time.Parse(TIME_LAYOUT, *releaseAt)
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledOrders(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	now := time.Now().UTC()
+	pickup := func(d time.Duration) OrderTypeDetails {
+		return OrderTypeDetails{Type: OrderTypeTakeout, PickupAt: now.Add(d).Format(TIME_LAYOUT)}
+	}
+
+	later, err := c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "5.00", PrepLeadTime: 20 * time.Minute, OrderTypeDetails: pickup(2 * time.Hour)})
+	require.NoError(t, err)
+	soon, err := c.CreateOrder(CreateOrderParams{ForName: "Grace", Status: "pending", Total: "5.00", PrepLeadTime: 20 * time.Minute, OrderTypeDetails: pickup(10 * time.Minute)})
+	require.NoError(t, err)
+
+	order, err := c.GetOrder(later)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusScheduled, order.Status)
+	require.Equal(t, now.Add(100*time.Minute).Format(TIME_LAYOUT), order.ReleaseAt)
+	require.Zero(t, order.TicketNumber, "numbered when released")
+	require.Empty(t, order.BusinessDay)
+
+	order, err = c.GetOrder(soon)
+	require.NoError(t, err)
+	require.Equal(t, "pending", order.Status, "pickups within the lead time go straight to the kitchen")
+	require.Empty(t, order.ReleaseAt)
+	require.Equal(t, 1, order.TicketNumber)
+
+	_, err = c.CreateOrder(CreateOrderParams{ForName: "Linus", Status: "pending", Total: "5.00", OrderTypeDetails: pickup(15 * 24 * time.Hour)})
+	require.ErrorIs(t, err, ErrInvalidOrderType, "too far ahead")
+	require.ErrorIs(t, c.UpdateOrder(UpdateOrderParams{ID: soon, Status: OrderStatusScheduled}), ErrCantSchedule)
+	_, err = c.CreateOrder(CreateOrderParams{ForName: "Linus", Status: OrderStatusScheduled, Total: "5.00"})
+	require.ErrorIs(t, err, ErrCantSchedule, "no pickup time to release it at")
+
+	next, err := c.NextOrderRelease()
+	require.NoError(t, err)
+	require.Equal(t, now.Add(100*time.Minute).Truncate(time.Second), next)
+
+	released, err := c.ReleaseScheduledOrders(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, released)
+
+	// e.g. the server was down when the order came due
+	released, err = c.ReleaseScheduledOrders(now.Add(3 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []int{later}, released)
+
+	order, err = c.GetOrder(later)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusPending, order.Status)
+	require.Equal(t, 2, order.TicketNumber)
+	require.Equal(t, c.Today(), order.BusinessDay)
+
+	released, err = c.ReleaseScheduledOrders(now.Add(3 * time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, released, "orders are released once")
+
+	next, err = c.NextOrderRelease()
+	require.NoError(t, err)
+	require.True(t, next.IsZero())
+}
+
+func TestReleaseScheduledOrdersSkipsFailures(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	now := time.Now().UTC()
+	var ids []int
+	for _, name := range []string{"Ada", "Grace"} {
+		id, err := c.CreateOrder(CreateOrderParams{ForName: name, Status: "pending", Total: "5.00", PrepLeadTime: 20 * time.Minute,
+			OrderTypeDetails: OrderTypeDetails{Type: OrderTypeTakeout, PickupAt: now.Add(time.Hour).Format(TIME_LAYOUT)}})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = c.db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_release BEFORE UPDATE OF status ON orders WHEN OLD.id = %d
+		BEGIN SELECT RAISE(ABORT, 'broken order'); END`, ids[0]))
+	require.NoError(t, err)
+
+	released, err := c.ReleaseScheduledOrders(now.Add(2 * time.Hour))
+	require.ErrorContains(t, err, fmt.Sprintf("couldn't release order %d", ids[0]))
+	require.Equal(t, []int{ids[1]}, released, "a broken order shouldn't hold up the rest")
+
+	next, err := c.NextOrderRelease()
+	require.NoError(t, err)
+	require.Equal(t, now.Add(40*time.Minute).Truncate(time.Second), next, "the broken order is still due")
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login on one device, represented by the active token of a refresh token family.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	UserEmail  string    `json:"user_email"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+const sessionsQuery = `
+	SELECT
+		rt.family_id,
+		rt.user_id,
+		u.email,
+		COALESCE(rt.device_name, ''),
+		COALESCE(rt.user_agent, ''),
+		COALESCE(rt.ip_address, ''),
+		(SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id),
+		COALESCE(rt.last_used_at, rt.created_at) AS last_seen,
+		rt.expires_at
+	FROM refresh_tokens rt
+	JOIN users u ON u.id = rt.user_id
+	WHERE rt.revoked_at IS NULL
+		AND rt.expires_at > CURRENT_TIMESTAMP
+`
+
+// GetSessions returns all active sessions, most recently used first.
+func (c *Client) GetSessions() ([]Session, error) {
+	return c.querySessions(sessionsQuery + ` ORDER BY last_seen DESC`)
+}
+
+// GetSessionsByUser returns the active sessions of a single user, most recently used first.
+func (c *Client) GetSessionsByUser(userID uuid.UUID) ([]Session, error) {
+	return c.querySessions(sessionsQuery+` AND rt.user_id = ? ORDER BY last_seen DESC`, userID.String())
+}
+
+// GetSession returns the active session with id. Returns an empty Session if none exists.
+func (c *Client) GetSession(id uuid.UUID) (Session, error) {
+	sessions, err := c.querySessions(sessionsQuery+` AND rt.family_id = ?`, id.String())
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, nil
+	}
+	return sessions[0], nil
+}
+
+// TouchSession records that a session's access token was just used. Writes are skipped if it was
+// already recorded in the last minute, since every request calls this.
+func (c *Client) TouchSession(id uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET last_used_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+			AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func (c *Client) querySessions(query string, args ...interface{}) ([]Session, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var id, userID string
+		var created_at, last_used_at, expires_at string
+		if err := rows.Scan(&id, &userID, &s.UserEmail, &s.DeviceName, &s.UserAgent, &s.IPAddress, &created_at, &last_used_at, &expires_at); err != nil {
+			return nil, err
+		}
+
+		if s.ID, err = uuid.Parse(id); err != nil {
+			return nil, err
+		}
+		if s.UserID, err = uuid.Parse(userID); err != nil {
+			return nil, err
+		}
+		if s.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, fmt.Errorf("couldn't parse created_at: %w", err)
+		}
+		if s.LastUsedAt, err = time.Parse(TIME_LAYOUT, last_used_at); err != nil {
+			return nil, fmt.Errorf("couldn't parse last_used_at: %w", err)
+		}
+		if s.ExpiresAt, err = time.Parse(TIME_LAYOUT, expires_at); err != nil {
+			return nil, fmt.Errorf("couldn't parse expires_at: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	alice, err := c.CreateUser(CreateUserParams{Email: "alice@test.com", Password: "pw", Role: "user"})
+	require.NoError(t, err, "Failed to create user")
+	bob, err := c.CreateUser(CreateUserParams{Email: "bob@test.com", Password: "pw", Role: "user"})
+	require.NoError(t, err, "Failed to create user")
+
+	tablet := uuid.New()
+	_, err = c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:      "alice-tablet",
+		UserID:     alice.ID,
+		FamilyID:   tablet,
+		ExpiresAt:  time.Now().Add(time.Hour),
+		DeviceName: "Front counter tablet",
+		UserAgent:  "test-agent",
+		IPAddress:  "10.0.0.2",
+	})
+	require.NoError(t, err)
+	_, err = c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:     "bob-laptop",
+		UserID:    bob.ID,
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	t.Run("List sessions", func(t *testing.T) {
+		all, err := c.GetSessions()
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+
+		mine, err := c.GetSessionsByUser(alice.ID)
+		require.NoError(t, err)
+		require.Len(t, mine, 1)
+		require.Equal(t, tablet, mine[0].ID)
+		require.Equal(t, "Front counter tablet", mine[0].DeviceName)
+		require.Equal(t, "10.0.0.2", mine[0].IPAddress)
+		require.Equal(t, "alice@test.com", mine[0].UserEmail)
+	})
+
+	t.Run("Rotation keeps a single session", func(t *testing.T) {
+		_, err := c.RotateRefreshToken("alice-tablet", CreateRefreshTokenParams{
+			Token:      "alice-tablet-2",
+			UserID:     alice.ID,
+			FamilyID:   tablet,
+			ExpiresAt:  time.Now().Add(time.Hour),
+			DeviceName: "Front counter tablet",
+		})
+		require.NoError(t, err)
+
+		mine, err := c.GetSessionsByUser(alice.ID)
+		require.NoError(t, err)
+		require.Len(t, mine, 1)
+		require.Equal(t, tablet, mine[0].ID)
+	})
+
+	t.Run("Touch session", func(t *testing.T) {
+		_, err := c.db.Exec(`UPDATE refresh_tokens SET last_used_at = '2000-01-01 00:00:00' WHERE family_id = ?`, tablet.String())
+		require.NoError(t, err)
+		require.NoError(t, c.TouchSession(tablet))
+
+		s, err := c.GetSession(tablet)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now(), s.LastUsedAt, time.Minute, "Using a session should update its last used time")
+	})
+
+	t.Run("Revoke session", func(t *testing.T) {
+		err := c.RevokeRefreshTokenFamily(tablet)
+		require.NoError(t, err)
+
+		s, err := c.GetSession(tablet)
+		require.NoError(t, err)
+		require.Equal(t, uuid.Nil, s.ID, "Revoked session should not be found")
+	})
+}
@@ -0,0 +1,668 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Table statuses. A table is seated once a tab is opened on it, ordered once the tab has an order,
+// and needs bussing after the guests leave until it is marked free again.
+const (
+	TableFree         = "free"
+	TableSeated       = "seated"
+	TableOrdered      = "ordered"
+	TablePaying       = "paying"
+	TableNeedsBussing = "needs_bussing"
+)
+
+// Tab statuses.
+const (
+	TabOpen   = "open"
+	TabClosed = "closed"
+	TabMerged = "merged" // moved onto another tab, see MergedInto
+)
+
+type Section struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateSectionParams
+}
+
+type CreateSectionParams struct {
+	Name      string `json:"name"`
+	SortOrder int    `json:"sort_order"`
+}
+
+type Table struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	Tab       *Tab      `json:"tab"` // open tab, if anyone is seated
+	CreateTableParams
+}
+
+type CreateTableParams struct {
+	SectionID *int    `json:"section_id"`
+	Name      string  `json:"name"`
+	Capacity  int     `json:"capacity"`
+	PosX      float64 `json:"pos_x"`
+	PosY      float64 `json:"pos_y"`
+}
+
+type UpdateTableParams struct {
+	ID int `json:"id"`
+	CreateTableParams
+}
+
+type Tab struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	TableID    *int       `json:"table_id"`
+	Name       string     `json:"name"`
+	Covers     int        `json:"covers"`
+	Status     string     `json:"status"`
+	ClosedAt   *time.Time `json:"closed_at"`
+	MergedInto *int       `json:"merged_into"`
+	Card       *TabCard   `json:"card"` // pre-authorized card, if any
+}
+
+var (
+	ErrTableNotFound      = errors.New("table not found")
+	ErrTableOccupied      = errors.New("table is not free")
+	ErrTableHasNoTab      = errors.New("table has no open tab")
+	ErrInvalidTableStatus = errors.New("invalid table status")
+	ErrInvalidTable       = errors.New("invalid table")
+	ErrTabNotFound        = errors.New("tab not found")
+	ErrTabClosed          = errors.New("tab is closed")
+)
+
+func (c *Client) CreateSection(params CreateSectionParams) (Section, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return Section{}, fmt.Errorf("%w: section needs a name", ErrInvalidTable)
+	}
+
+	var id int
+	err := c.db.QueryRow(`
+		INSERT INTO sections (created_at, name, sort_order)
+		VALUES (CURRENT_TIMESTAMP, ?, ?)
+		RETURNING id
+	`, params.Name, params.SortOrder).Scan(&id)
+	if err != nil {
+		return Section{}, fmt.Errorf("couldn't create section: %w", err)
+	}
+
+	sections, err := c.querySections(`WHERE id = ?`, id)
+	if err != nil || len(sections) == 0 {
+		return Section{}, err
+	}
+	return sections[0], nil
+}
+
+func (c *Client) GetSections() ([]Section, error) {
+	return c.querySections(``)
+}
+
+func (c *Client) querySections(where string, args ...interface{}) ([]Section, error) {
+	rows, err := c.db.Query(`SELECT id, created_at, name, sort_order FROM sections `+where+` ORDER BY sort_order, name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sections := []Section{}
+	for rows.Next() {
+		var section Section
+		var created_at string
+		if err := rows.Scan(&section.ID, &created_at, &section.Name, &section.SortOrder); err != nil {
+			return nil, err
+		}
+		if section.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		sections = append(sections, section)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// DeleteSection removes a section. Its tables are kept without a section.
+func (c *Client) DeleteSection(id int) error {
+	_, err := c.db.Exec(`DELETE FROM sections WHERE id = ?`, id)
+	return err
+}
+
+func (p *CreateTableParams) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("%w: table needs a name", ErrInvalidTable)
+	}
+	if p.Capacity < 1 {
+		return fmt.Errorf("%w: capacity must be at least 1", ErrInvalidTable)
+	}
+	return nil
+}
+
+func (c *Client) CreateTable(params CreateTableParams) (Table, error) {
+	if err := params.validate(); err != nil {
+		return Table{}, err
+	}
+
+	var id int
+	err := c.db.QueryRow(`
+		INSERT INTO tables (created_at, updated_at, section_id, name, capacity, pos_x, pos_y, status)
+		VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, params.SectionID, params.Name, params.Capacity, params.PosX, params.PosY, TableFree).Scan(&id)
+	if err != nil {
+		return Table{}, fmt.Errorf("couldn't create table: %w", err)
+	}
+
+	return c.GetTable(id)
+}
+
+// UpdateTable changes a table's name, section, capacity and position. Returns ErrTableNotFound if
+// there is no such table.
+func (c *Client) UpdateTable(params UpdateTableParams) (Table, error) {
+	if err := params.validate(); err != nil {
+		return Table{}, err
+	}
+
+	res, err := c.db.Exec(`
+		UPDATE tables
+		SET section_id = ?, name = ?, capacity = ?, pos_x = ?, pos_y = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, params.SectionID, params.Name, params.Capacity, params.PosX, params.PosY, params.ID)
+	if err != nil {
+		return Table{}, fmt.Errorf("couldn't update table: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Table{}, err
+	} else if n == 0 {
+		return Table{}, ErrTableNotFound
+	}
+
+	return c.GetTable(params.ID)
+}
+
+// DeleteTable removes a table. Tables with an open tab can't be removed.
+func (c *Client) DeleteTable(id int) error {
+	res, err := c.db.Exec(`
+		DELETE FROM tables
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM tabs WHERE table_id = tables.id AND status = ?)
+	`, id, TabOpen)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		table, err := c.GetTable(id)
+		if err != nil {
+			return err
+		}
+		if table.ID == 0 {
+			return ErrTableNotFound
+		}
+		return ErrTableOccupied
+	}
+	return nil
+}
+
+const tableColumns = `
+	t.id, t.created_at, t.updated_at, t.section_id, t.name, t.capacity, t.pos_x, t.pos_y, t.status,
+	tab.id, tab.created_at, tab.updated_at, tab.name, tab.covers
+`
+
+// GetTables returns the floor plan: every table with its open tab, by section.
+func (c *Client) GetTables() ([]Table, error) {
+	return c.queryTables(``)
+}
+
+// GetTable returns an empty Table if none exists.
+func (c *Client) GetTable(id int) (Table, error) {
+	tables, err := c.queryTables(`WHERE t.id = ?`, id)
+	if err != nil || len(tables) == 0 {
+		return Table{}, err
+	}
+	return tables[0], nil
+}
+
+func (c *Client) queryTables(where string, args ...interface{}) ([]Table, error) {
+	query := `SELECT ` + tableColumns + `
+		FROM tables t
+		LEFT JOIN sections s ON s.id = t.section_id
+		LEFT JOIN tabs tab ON tab.table_id = t.id AND tab.status = '` + TabOpen + `'
+		` + where + `
+		ORDER BY s.sort_order IS NULL, s.sort_order, t.name
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []Table{}
+	for rows.Next() {
+		var table Table
+		var created_at, updated_at string
+		var tabID, tabCovers *int
+		var tabCreatedAt, tabUpdatedAt, tabName *string
+		err := rows.Scan(&table.ID, &created_at, &updated_at, &table.SectionID, &table.Name, &table.Capacity,
+			&table.PosX, &table.PosY, &table.Status, &tabID, &tabCreatedAt, &tabUpdatedAt, &tabName, &tabCovers)
+		if err != nil {
+			return nil, err
+		}
+		if table.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+			return nil, err
+		}
+		if table.UpdatedAt, err = time.Parse(TIME_LAYOUT, updated_at); err != nil {
+			return nil, err
+		}
+
+		if tabID != nil {
+			tab := Tab{ID: *tabID, TableID: &table.ID, Name: *tabName, Covers: *tabCovers, Status: TabOpen}
+			if tab.CreatedAt, err = time.Parse(TIME_LAYOUT, *tabCreatedAt); err != nil {
+				return nil, err
+			}
+			if tab.UpdatedAt, err = time.Parse(TIME_LAYOUT, *tabUpdatedAt); err != nil {
+				return nil, err
+			}
+			table.Tab = &tab
+		}
+		tables = append(tables, table)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// GetTab returns an empty Tab if none exists.
+func (c *Client) GetTab(id int) (Tab, error) {
+	var tab Tab
+	var created_at, updated_at string
+	var closedAt, cardAuthID, cardLast4 *string
+	var cardAuthCents *int
+	err := c.db.QueryRow(`
+		SELECT id, created_at, updated_at, table_id, name, covers, status, closed_at, merged_into,
+			card_auth_id, card_auth_cents, card_last4
+		FROM tabs
+		WHERE id = ?
+	`, id).Scan(&tab.ID, &created_at, &updated_at, &tab.TableID, &tab.Name, &tab.Covers, &tab.Status, &closedAt, &tab.MergedInto,
+		&cardAuthID, &cardAuthCents, &cardLast4)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Tab{}, nil
+	}
+	if err != nil {
+		return Tab{}, err
+	}
+
+	if tab.CreatedAt, err = time.Parse(TIME_LAYOUT, created_at); err != nil {
+		return Tab{}, err
+	}
+	if tab.UpdatedAt, err = time.Parse(TIME_LAYOUT, updated_at); err != nil {
+		return Tab{}, err
+	}
+	if tab.ClosedAt, err = parseTimePtr(closedAt); err != nil {
+		return Tab{}, err
+	}
+	if cardAuthID != nil {
+		tab.Card = &TabCard{AuthID: *cardAuthID}
+		if cardAuthCents != nil {
+			tab.Card.AmountCents = *cardAuthCents
+		}
+		if cardLast4 != nil {
+			tab.Card.Last4 = *cardLast4
+		}
+	}
+	return tab, nil
+}
+
+// SeatTable opens a tab on a free table.
+func (c *Client) SeatTable(tableID int, name string, covers int) (Tab, error) {
+	if covers < 1 {
+		return Tab{}, fmt.Errorf("%w: covers must be at least 1", ErrInvalidTable)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Tab{}, err
+	}
+
+	status, err := tableStatus(tx, tableID)
+	if err != nil {
+		tx.Rollback()
+		return Tab{}, err
+	}
+	if status != TableFree {
+		tx.Rollback()
+		return Tab{}, ErrTableOccupied
+	}
+
+	var tabID int
+	err = tx.QueryRow(`
+		INSERT INTO tabs (created_at, updated_at, table_id, name, covers, status)
+		VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+		RETURNING id
+	`, tableID, strings.TrimSpace(name), covers, TabOpen).Scan(&tabID)
+	if err != nil {
+		tx.Rollback()
+		return Tab{}, fmt.Errorf("couldn't open tab: %w", err)
+	}
+
+	if err := setTableStatus(tx, tableID, TableSeated); err != nil {
+		tx.Rollback()
+		return Tab{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Tab{}, err
+	}
+	return c.GetTab(tabID)
+}
+
+// SetTableStatus changes a table's status by hand, e.g. when the bill is requested or the table has
+// been bussed. Tables with an open tab can only be seated, ordered or paying; tables without one can
+// only be free or need bussing.
+func (c *Client) SetTableStatus(tableID int, status string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tableStatus(tx, tableID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = openTableTab(tx, tableID)
+	hasTab := err == nil
+	if err != nil && !errors.Is(err, ErrTableHasNoTab) {
+		tx.Rollback()
+		return err
+	}
+
+	switch status {
+	case TableSeated, TableOrdered, TablePaying:
+		if !hasTab {
+			tx.Rollback()
+			return fmt.Errorf("%w: %s needs an open tab", ErrInvalidTableStatus, status)
+		}
+	case TableFree, TableNeedsBussing:
+		if hasTab {
+			tx.Rollback()
+			return fmt.Errorf("%w: close the tab first", ErrInvalidTableStatus)
+		}
+	default:
+		tx.Rollback()
+		return fmt.Errorf("%w: %q", ErrInvalidTableStatus, status)
+	}
+
+	if err := setTableStatus(tx, tableID, status); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveTab moves the open tab on one table, with its orders, to a free table. The table left behind
+// needs bussing.
+func (c *Client) MoveTab(fromTableID, toTableID int) error {
+	if fromTableID == toTableID {
+		return fmt.Errorf("%w: can't move a tab to the same table", ErrInvalidTable)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	fromStatus, err := tableStatus(tx, fromTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	toStatus, err := tableStatus(tx, toTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if toStatus != TableFree {
+		tx.Rollback()
+		return ErrTableOccupied
+	}
+
+	tabID, err := openTableTab(tx, fromTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE tabs SET table_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, toTableID, tabID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't move tab: %w", err)
+	}
+	if err := setTableStatus(tx, toTableID, fromStatus); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setTableStatus(tx, fromTableID, TableNeedsBussing); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeTabs moves the orders and covers of the open tab on one table onto the open tab of another,
+// e.g. when two parties join up. The merged tab is kept for history and its table needs bussing.
+func (c *Client) MergeTabs(fromTableID, intoTableID int) error {
+	if fromTableID == intoTableID {
+		return fmt.Errorf("%w: can't merge a tab into itself", ErrInvalidTable)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	fromStatus, err := tableStatus(tx, fromTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	intoStatus, err := tableStatus(tx, intoTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	fromTab, err := openTableTab(tx, fromTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	intoTab, err := openTableTab(tx, intoTableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := mergeTab(tx, fromTab, intoTab); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// the merged table keeps the furthest along of the two statuses
+	if tableStatusRank(fromStatus) > tableStatusRank(intoStatus) {
+		if err := setTableStatus(tx, intoTableID, fromStatus); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := setTableStatus(tx, fromTableID, TableNeedsBussing); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mergeTab moves the orders and covers of one tab onto another and marks it merged.
+func mergeTab(tx *sql.Tx, fromTabID, intoTabID int) error {
+	if _, err := tx.Exec(`UPDATE orders SET tab_id = ?, updated_at = CURRENT_TIMESTAMP WHERE tab_id = ?`, intoTabID, fromTabID); err != nil {
+		return fmt.Errorf("couldn't move orders: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		UPDATE tabs
+		SET covers = covers + (SELECT covers FROM tabs WHERE id = ?), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, fromTabID, intoTabID)
+	if err != nil {
+		return fmt.Errorf("couldn't merge tabs: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE tabs
+		SET status = ?, merged_into = ?, closed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, TabMerged, intoTabID, fromTabID)
+	if err != nil {
+		return fmt.Errorf("couldn't merge tabs: %w", err)
+	}
+	return nil
+}
+
+// ClearTable closes the open tab on a table once the guests have left. The table then needs bussing.
+// Tabs with anything left to pay return ErrTabHasBalance; they are closed out with CloseTab.
+func (c *Client) ClearTable(tableID int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tableStatus(tx, tableID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	tabID, err := openTableTab(tx, tableID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	dues, err := tabDues(tx, tabID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(dues) > 0 {
+		tx.Rollback()
+		return ErrTabHasBalance
+	}
+
+	_, err = tx.Exec(`
+		UPDATE tabs
+		SET status = ?, closed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, TabClosed, tabID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't close tab: %w", err)
+	}
+	if err := setTableStatus(tx, tableID, TableNeedsBussing); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// orderTab checks an order's tab is open and, for tabs on a table, fills in the order's dine in
+// details from the table. A seated table is marked ordered.
+func orderTab(tx *sql.Tx, order *CreateOrderParams) error {
+	var status, tableName string
+	var tableID *int
+	var covers int
+	err := tx.QueryRow(`
+		SELECT tab.status, tab.table_id, COALESCE(t.name, ''), tab.covers
+		FROM tabs tab
+		LEFT JOIN tables t ON t.id = tab.table_id
+		WHERE tab.id = ?
+	`, *order.TabID).Scan(&status, &tableID, &tableName, &covers)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTabNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != TabOpen {
+		return ErrTabClosed
+	}
+	if tableID == nil {
+		return nil
+	}
+
+	if order.Type == "" {
+		order.Type = OrderTypeDineIn
+	}
+	if order.Type == OrderTypeDineIn {
+		order.TableNumber = tableName
+		if order.Covers == 0 {
+			order.Covers = covers
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE tables SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		TableOrdered, *tableID, TableSeated)
+	if err != nil {
+		return fmt.Errorf("couldn't update table: %w", err)
+	}
+	return nil
+}
+
+func tableStatus(tx *sql.Tx, tableID int) (string, error) {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM tables WHERE id = ?`, tableID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrTableNotFound
+	}
+	return status, err
+}
+
+func setTableStatus(tx *sql.Tx, tableID int, status string) error {
+	_, err := tx.Exec(`UPDATE tables SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, status, tableID)
+	if err != nil {
+		return fmt.Errorf("couldn't update table: %w", err)
+	}
+	return nil
+}
+
+func openTableTab(tx *sql.Tx, tableID int) (int, error) {
+	var tabID int
+	err := tx.QueryRow(`SELECT id FROM tabs WHERE table_id = ? AND status = ?`, tableID, TabOpen).Scan(&tabID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrTableHasNoTab
+	}
+	return tabID, err
+}
+
+func tableStatusRank(status string) int {
+	switch status {
+	case TableSeated:
+		return 1
+	case TableOrdered:
+		return 2
+	case TablePaying:
+		return 3
+	}
+	return 0
+}
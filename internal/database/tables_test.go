@@ -0,0 +1,104 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTables(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	section, err := c.CreateSection(CreateSectionParams{Name: "Patio"})
+	require.NoError(t, err)
+	one, err := c.CreateTable(CreateTableParams{SectionID: &section.ID, Name: "P1", Capacity: 4})
+	require.NoError(t, err)
+	two, err := c.CreateTable(CreateTableParams{SectionID: &section.ID, Name: "P2", Capacity: 2})
+	require.NoError(t, err)
+	three, err := c.CreateTable(CreateTableParams{Name: "B1", Capacity: 2})
+	require.NoError(t, err)
+	require.Equal(t, TableFree, one.Status)
+
+	_, err = c.CreateTable(CreateTableParams{Name: "P3"})
+	require.ErrorIs(t, err, ErrInvalidTable)
+
+	t.Run("Seat and order", func(t *testing.T) {
+		tab, err := c.SeatTable(one.ID, "Ada", 3)
+		require.NoError(t, err)
+		_, err = c.SeatTable(one.ID, "Grace", 2)
+		require.ErrorIs(t, err, ErrTableOccupied)
+
+		orderID, err := c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "10.00", TabID: &tab.ID})
+		require.NoError(t, err)
+		order, err := c.GetOrder(orderID)
+		require.NoError(t, err)
+		require.Equal(t, OrderTypeDineIn, order.Type)
+		require.Equal(t, "P1", order.TableNumber)
+		require.Equal(t, 3, order.Covers)
+		require.Equal(t, tab.ID, *order.TabID)
+
+		table, err := c.GetTable(one.ID)
+		require.NoError(t, err)
+		require.Equal(t, TableOrdered, table.Status)
+		require.Equal(t, tab.ID, table.Tab.ID)
+
+		require.ErrorIs(t, c.SetTableStatus(one.ID, TableFree), ErrInvalidTableStatus)
+		require.ErrorIs(t, c.DeleteTable(one.ID), ErrTableOccupied)
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		require.NoError(t, c.MoveTab(one.ID, two.ID))
+
+		from, err := c.GetTable(one.ID)
+		require.NoError(t, err)
+		require.Equal(t, TableNeedsBussing, from.Status)
+		require.Nil(t, from.Tab)
+		to, err := c.GetTable(two.ID)
+		require.NoError(t, err)
+		require.Equal(t, TableOrdered, to.Status)
+		require.NotNil(t, to.Tab)
+
+		require.NoError(t, c.SetTableStatus(one.ID, TableFree))
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		_, err := c.SeatTable(three.ID, "Linus", 2)
+		require.NoError(t, err)
+		into, err := c.GetTable(two.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, c.MergeTabs(three.ID, two.ID))
+
+		merged, err := c.GetTable(two.ID)
+		require.NoError(t, err)
+		require.Equal(t, TableOrdered, merged.Status)
+		require.Equal(t, 5, merged.Tab.Covers)
+		from, err := c.GetTable(three.ID)
+		require.NoError(t, err)
+		require.Equal(t, TableNeedsBussing, from.Status)
+
+		tables, err := c.GetTables()
+		require.NoError(t, err)
+		require.Len(t, tables, 3)
+
+		require.ErrorIs(t, c.ClearTable(two.ID), ErrTabHasBalance)
+		summary, err := c.GetTabSummary(into.Tab.ID)
+		require.NoError(t, err)
+		require.Len(t, summary.Orders, 1)
+		require.NotNil(t, summary.Orders[0].Items, "Orders come with their items")
+		_, err = c.CreatePayment(CreatePaymentParams{OrderID: summary.Orders[0].ID, Tender: TenderCash, AmountCents: summary.BalanceCents})
+		require.NoError(t, err)
+
+		require.NoError(t, c.ClearTable(two.ID))
+		tab, err := c.GetTab(into.Tab.ID)
+		require.NoError(t, err)
+		require.Equal(t, TabClosed, tab.Status)
+
+		_, err = c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "1.00", TabID: &tab.ID})
+		require.ErrorIs(t, err, ErrTabClosed)
+	})
+}
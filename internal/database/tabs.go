@@ -0,0 +1,347 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TabCard is a hold placed on a customer's card when a tab is opened, captured when it is closed.
+type TabCard struct {
+	AuthID      string `json:"auth_id"` // the payments gateway's authorization
+	AmountCents int    `json:"amount_cents"`
+	Last4       string `json:"last4"`
+}
+
+// TabSummary is a tab with its orders and running balance. Voided and refunded orders don't count.
+type TabSummary struct {
+	Tab
+	BusinessDay  string  `json:"business_day"` // the day the tab was opened
+	Orders       []Order `json:"orders"`
+	TotalCents   int     `json:"total_cents"`
+	PaidCents    int     `json:"paid_cents"`
+	BalanceCents int     `json:"balance_cents"`
+}
+
+type OpenTabParams struct {
+	Name   string `json:"name"`
+	Covers int    `json:"covers"`
+}
+
+// CloseTabParams settles everything still due on a tab with a single payment. BalanceCents is the
+// balance the customer was charged, so the close fails if an order was added in the meantime.
+type CloseTabParams struct {
+	TabID        int
+	Tender       string
+	BalanceCents int
+	Reference    string // e.g. the gateway's capture reference
+	// Charge, if set, takes the balance once the tab is known to be closable, and returns the
+	// reference recorded on the payments. The tab stays open if it fails.
+	Charge func(balanceCents int) (string, error)
+}
+
+var (
+	ErrTabBalanceChanged = errors.New("tab balance has changed")
+	ErrTabHasBalance     = errors.New("tab still has a balance to pay")
+	ErrInvalidTab        = errors.New("invalid tab")
+)
+
+// OpenTab opens a tab that isn't on a table, e.g. at the bar. Tabs on tables are opened by SeatTable.
+func (c *Client) OpenTab(params OpenTabParams) (Tab, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return Tab{}, fmt.Errorf("%w: tab needs a name", ErrInvalidTab)
+	}
+	if params.Covers < 0 {
+		return Tab{}, fmt.Errorf("%w: covers can't be negative", ErrInvalidTab)
+	}
+
+	var id int
+	err := c.db.QueryRow(`
+		INSERT INTO tabs (created_at, updated_at, name, covers, status)
+		VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		RETURNING id
+	`, params.Name, params.Covers, TabOpen).Scan(&id)
+	if err != nil {
+		return Tab{}, fmt.Errorf("couldn't open tab: %w", err)
+	}
+	return c.GetTab(id)
+}
+
+// GetTabSummary returns an empty TabSummary if the tab doesn't exist.
+func (c *Client) GetTabSummary(id int) (TabSummary, error) {
+	tab, err := c.GetTab(id)
+	if err != nil || tab.ID == 0 {
+		return TabSummary{}, err
+	}
+	return c.summarizeTab(tab)
+}
+
+// GetOpenTabs returns every open tab with its balance, oldest first.
+func (c *Client) GetOpenTabs() ([]TabSummary, error) {
+	rows, err := c.db.Query(`SELECT id FROM tabs WHERE status = ? ORDER BY created_at, id`, TabOpen)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tabs := make([]TabSummary, 0, len(ids))
+	for _, id := range ids {
+		tab, err := c.GetTabSummary(id)
+		if err != nil {
+			return nil, err
+		}
+		if tab.ID != 0 {
+			tabs = append(tabs, tab)
+		}
+	}
+	return tabs, nil
+}
+
+func (c *Client) summarizeTab(tab Tab) (TabSummary, error) {
+	orders, err := c.queryOrders("o.tab_id = ?", "o.created_at, o.id", tab.ID)
+	if err != nil {
+		return TabSummary{}, err
+	}
+	if err := c.loadOrderItems(orders); err != nil {
+		return TabSummary{}, err
+	}
+	paid, err := tabPayments(c.db, tab.ID)
+	if err != nil {
+		return TabSummary{}, err
+	}
+
+	summary := TabSummary{Tab: tab, BusinessDay: c.businessDay.Of(tab.CreatedAt), Orders: orders}
+	for _, order := range orders {
+		if isReversedStatus(order.Status) {
+			continue
+		}
+		totalCents, err := totalToCents(order.Total.String())
+		if err != nil {
+			return TabSummary{}, err
+		}
+		summary.TotalCents += totalCents
+		summary.PaidCents += paid[order.ID]
+	}
+	summary.BalanceCents = max(summary.TotalCents-summary.PaidCents, 0)
+	return summary, nil
+}
+
+// SetTabCard keeps a pre-authorized card on an open tab. Returns the card it replaces, if any, so its
+// hold can be released.
+func (c *Client) SetTabCard(tabID int, card TabCard) (*TabCard, error) {
+	tab, err := c.GetTab(tabID)
+	if err != nil {
+		return nil, err
+	}
+	if tab.ID == 0 {
+		return nil, ErrTabNotFound
+	}
+	if tab.Status != TabOpen {
+		return nil, ErrTabClosed
+	}
+
+	res, err := c.db.Exec(`
+		UPDATE tabs
+		SET card_auth_id = ?, card_auth_cents = ?, card_last4 = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`, card.AuthID, card.AmountCents, card.Last4, tabID, TabOpen)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't save card: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrTabClosed
+	}
+	return tab.Card, nil
+}
+
+// ClearTabCard takes the card hold authID off a tab, e.g. once the gateway no longer knows it, so a
+// new card can be taken. A different card put on the tab since is left as it is.
+func (c *Client) ClearTabCard(tabID int, authID string) error {
+	_, err := c.db.Exec(`
+		UPDATE tabs
+		SET card_auth_id = NULL, card_auth_cents = NULL, card_last4 = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND card_auth_id = ?
+	`, tabID, authID)
+	if err != nil {
+		return fmt.Errorf("couldn't remove card: %w", err)
+	}
+	return nil
+}
+
+// CloseTab pays what is still due on each of the tab's orders from one payment and closes the tab.
+// A tab on a table leaves the table needing bussing.
+func (c *Client) CloseTab(params CloseTabParams) (TabSummary, error) {
+	if params.Tender != TenderCash && params.Tender != TenderCard {
+		return TabSummary{}, fmt.Errorf("%w: tabs are closed out with cash or card", ErrInvalidTender)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return TabSummary{}, err
+	}
+
+	var status string
+	var tableID *int
+	err = tx.QueryRow(`SELECT status, table_id FROM tabs WHERE id = ?`, params.TabID).Scan(&status, &tableID)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return TabSummary{}, ErrTabNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return TabSummary{}, err
+	}
+	if status != TabOpen {
+		tx.Rollback()
+		return TabSummary{}, ErrTabClosed
+	}
+
+	dues, err := tabDues(tx, params.TabID)
+	if err != nil {
+		tx.Rollback()
+		return TabSummary{}, err
+	}
+	balance := 0
+	for _, due := range dues {
+		balance += due.cents
+	}
+	if balance != params.BalanceCents {
+		tx.Rollback()
+		return TabSummary{}, fmt.Errorf("%w: balance is %d", ErrTabBalanceChanged, balance)
+	}
+	if params.Charge != nil && balance > 0 {
+		ref, err := params.Charge(balance)
+		if err != nil {
+			tx.Rollback()
+			return TabSummary{}, err
+		}
+		params.Reference = ref
+	}
+
+	for _, due := range dues {
+		_, err := tx.Exec(`
+			INSERT INTO payments (created_at, order_id, tender, amount_cents, reference)
+			VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?)
+		`, due.orderID, params.Tender, due.cents, params.Reference)
+		if err != nil {
+			tx.Rollback()
+			return TabSummary{}, fmt.Errorf("couldn't record payment: %w", err)
+		}
+		_, err = tx.Exec(`UPDATE orders SET paid_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, due.orderID)
+		if err != nil {
+			tx.Rollback()
+			return TabSummary{}, fmt.Errorf("couldn't mark order paid: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE tabs
+		SET status = ?, closed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, TabClosed, params.TabID)
+	if err != nil {
+		tx.Rollback()
+		return TabSummary{}, fmt.Errorf("couldn't close tab: %w", err)
+	}
+	if tableID != nil {
+		if err := setTableStatus(tx, *tableID, TableNeedsBussing); err != nil {
+			tx.Rollback()
+			return TabSummary{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TabSummary{}, err
+	}
+	return c.GetTabSummary(params.TabID)
+}
+
+type tabDue struct {
+	orderID int
+	cents   int
+}
+
+// tabDues returns what is still due on each of a tab's orders that hasn't been paid in full.
+func tabDues(tx *sql.Tx, tabID int) ([]tabDue, error) {
+	rows, err := tx.Query(`SELECT id, status, total FROM orders WHERE tab_id = ? ORDER BY id`, tabID)
+	if err != nil {
+		return nil, err
+	}
+	type tabOrder struct {
+		id            int
+		status, total string
+	}
+	orders := []tabOrder{}
+	for rows.Next() {
+		var o tabOrder
+		if err := rows.Scan(&o.id, &o.status, &o.total); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	paid, err := tabPayments(tx, tabID)
+	if err != nil {
+		return nil, err
+	}
+	dues := []tabDue{}
+	for _, o := range orders {
+		if isReversedStatus(o.status) {
+			continue
+		}
+		totalCents, err := totalToCents(o.total)
+		if err != nil {
+			return nil, err
+		}
+		if totalCents > paid[o.id] {
+			dues = append(dues, tabDue{orderID: o.id, cents: totalCents - paid[o.id]})
+		}
+	}
+	return dues, nil
+}
+
+// tabPayments returns what has been paid towards each of a tab's orders, by order ID.
+func tabPayments(q querier, tabID int) (map[int]int, error) {
+	rows, err := q.Query(`
+		SELECT p.order_id, SUM(p.amount_cents)
+		FROM payments p
+		JOIN orders o ON o.id = p.order_id
+		WHERE o.tab_id = ?
+		GROUP BY p.order_id
+	`, tabID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paid := map[int]int{}
+	for rows.Next() {
+		var orderID, cents int
+		if err := rows.Scan(&orderID, &cents); err != nil {
+			return nil, err
+		}
+		paid[orderID] = cents
+	}
+	return paid, rows.Err()
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTabs(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	_, err = c.OpenTab(OpenTabParams{})
+	require.ErrorIs(t, err, ErrInvalidTab)
+
+	tab, err := c.OpenTab(OpenTabParams{Name: "Ada"})
+	require.NoError(t, err)
+	require.Nil(t, tab.TableID)
+
+	first, err := c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "6.50", TabID: &tab.ID})
+	require.NoError(t, err)
+	_, err = c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "8.00", TabID: &tab.ID})
+	require.NoError(t, err)
+	voided, err := c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: OrderStatusVoided, Total: "100.00", TabID: &tab.ID})
+	require.NoError(t, err)
+	require.NotZero(t, voided)
+
+	// a round paid for on its own comes off the balance
+	_, err = c.CreatePayment(CreatePaymentParams{OrderID: first, Tender: TenderCash, AmountCents: 200})
+	require.NoError(t, err)
+
+	summary, err := c.GetTabSummary(tab.ID)
+	require.NoError(t, err)
+	require.Len(t, summary.Orders, 3)
+	require.Equal(t, 1450, summary.TotalCents)
+	require.Equal(t, 200, summary.PaidCents)
+	require.Equal(t, 1250, summary.BalanceCents)
+	require.Equal(t, c.Today(), summary.BusinessDay)
+
+	prev, err := c.SetTabCard(tab.ID, TabCard{AuthID: "auth_1", AmountCents: 5000, Last4: "4242"})
+	require.NoError(t, err)
+	require.Nil(t, prev)
+	prev, err = c.SetTabCard(tab.ID, TabCard{AuthID: "auth_2", AmountCents: 5000, Last4: "4242"})
+	require.NoError(t, err)
+	require.Equal(t, "auth_1", prev.AuthID)
+
+	open, err := c.GetOpenTabs()
+	require.NoError(t, err)
+	require.Len(t, open, 1)
+	require.Equal(t, "auth_2", open[0].Card.AuthID)
+
+	require.NoError(t, c.ClearTabCard(tab.ID, "auth_1"))
+	summary, err = c.GetTabSummary(tab.ID)
+	require.NoError(t, err)
+	require.Equal(t, "auth_2", summary.Card.AuthID, "Only the given hold should be cleared")
+	require.NoError(t, c.ClearTabCard(tab.ID, "auth_2"))
+	summary, err = c.GetTabSummary(tab.ID)
+	require.NoError(t, err)
+	require.Nil(t, summary.Card)
+	_, err = c.SetTabCard(tab.ID, TabCard{AuthID: "auth_2", AmountCents: 5000, Last4: "4242"})
+	require.NoError(t, err)
+
+	_, err = c.CloseTab(CloseTabParams{TabID: tab.ID, Tender: TenderGiftCard, BalanceCents: 1250})
+	require.ErrorIs(t, err, ErrInvalidTender)
+	_, err = c.CloseTab(CloseTabParams{TabID: tab.ID, Tender: TenderCard, BalanceCents: 1000})
+	require.ErrorIs(t, err, ErrTabBalanceChanged)
+
+	declined := errors.New("declined")
+	_, err = c.CloseTab(CloseTabParams{TabID: tab.ID, Tender: TenderCard, BalanceCents: 1250, Charge: func(int) (string, error) {
+		return "", declined
+	}})
+	require.ErrorIs(t, err, declined)
+	summary, err = c.GetTabSummary(tab.ID)
+	require.NoError(t, err)
+	require.Equal(t, TabOpen, summary.Status, "A failed charge leaves the tab open")
+
+	charged := 0
+	closed, err := c.CloseTab(CloseTabParams{TabID: tab.ID, Tender: TenderCard, BalanceCents: 1250, Charge: func(cents int) (string, error) {
+		charged = cents
+		return "capture_1", nil
+	}})
+	require.NoError(t, err)
+	require.Equal(t, 1250, charged)
+	require.Equal(t, TabClosed, closed.Status)
+	require.NotNil(t, closed.ClosedAt)
+	require.Zero(t, closed.BalanceCents)
+	for _, order := range closed.Orders {
+		if order.ID == voided {
+			continue
+		}
+		payments, err := c.GetPaymentSummary(order.ID)
+		require.NoError(t, err)
+		require.Zero(t, payments.DueCents)
+	}
+
+	_, err = c.CloseTab(CloseTabParams{TabID: tab.ID, Tender: TenderCash})
+	require.ErrorIs(t, err, ErrTabClosed)
+	open, err = c.GetOpenTabs()
+	require.NoError(t, err)
+	require.Empty(t, open)
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const BUSINESS_DAY_LAYOUT = "2006-01-02"
+
+// BusinessDay is the day a ticket number counts towards. Days start at cutoff after midnight in loc,
+// so orders after midnight on a late night still belong to the day before.
+type BusinessDay struct {
+	Location *time.Location
+	Cutoff   time.Duration
+}
+
+// Of returns the business day t falls on, as YYYY-MM-DD. The cutoff is compared to the wall clock,
+// so days still start at the cutoff when the clocks change overnight.
+func (b BusinessDay) Of(t time.Time) string {
+	loc := b.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	year, month, day := local.Date()
+	wallClock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	// whole days since the cutoff, rounded down, so times before it fall on the day before
+	const fullDay = 24 * time.Hour
+	since := wallClock - b.Cutoff
+	day += int(since / fullDay)
+	if since%fullDay < 0 {
+		day--
+	}
+	// noon exists on every day, unlike midnight in some zones
+	return time.Date(year, month, day, 12, 0, 0, 0, loc).Format(BUSINESS_DAY_LAYOUT)
+}
+
+// SetBusinessDay sets when ticket numbers start again from 1. Defaults to midnight UTC.
+func (c *Client) SetBusinessDay(day BusinessDay) {
+	c.businessDay = day
+}
+
+// Today returns the current business day, as YYYY-MM-DD.
+func (c *Client) Today() string {
+	return c.businessDay.Of(time.Now())
+}
+
+// nextTicketNumber hands out the next ticket number of the current business day. Called inside the
+// order's transaction, so numbers are neither skipped nor repeated: a rolled back order gives its
+// number back, and concurrent orders are serialized by the write to the counter.
+func (c *Client) nextTicketNumber(tx *sql.Tx) (day string, number int, err error) {
+	day = c.Today()
+	err = tx.QueryRow(`
+		INSERT INTO ticket_counters (business_day, last_number)
+		VALUES (?, 1)
+		ON CONFLICT (business_day) DO UPDATE SET last_number = last_number + 1
+		RETURNING last_number
+	`, day).Scan(&number)
+	if err != nil {
+		return "", 0, fmt.Errorf("couldn't assign ticket number: %w", err)
+	}
+	return day, number, nil
+}
+
+// assignTicketNumber numbers a scheduled order on the business day it goes to the kitchen.
+func (c *Client) assignTicketNumber(tx *sql.Tx, orderID int) error {
+	day, number, err := c.nextTicketNumber(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE orders SET business_day = ?, ticket_number = ? WHERE id = ?`, day, number, orderID)
+	if err != nil {
+		return fmt.Errorf("couldn't assign ticket number: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusinessDay(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	require.NoError(t, err)
+	day := BusinessDay{Location: la, Cutoff: 4 * time.Hour}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"Afternoon", time.Date(2025, 3, 1, 15, 0, 0, 0, la), "2025-03-01"},
+		{"After midnight belongs to the day before", time.Date(2025, 3, 2, 1, 30, 0, 0, la), "2025-03-01"},
+		{"At the cutoff", time.Date(2025, 3, 2, 4, 0, 0, 0, la), "2025-03-02"},
+		{"Converted from UTC", time.Date(2025, 3, 2, 10, 0, 0, 0, time.UTC), "2025-03-01"},
+		{"Clocks went forward", time.Date(2025, 3, 9, 4, 30, 0, 0, la), "2025-03-09"},
+		{"Clocks went back", time.Date(2025, 11, 2, 3, 30, 0, 0, la), "2025-11-01"},
+		{"Month boundary", time.Date(2025, 4, 1, 1, 0, 0, 0, la), "2025-03-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, day.Of(tt.at))
+		})
+	}
+
+	require.Equal(t, "2025-03-02", BusinessDay{}.Of(time.Date(2025, 3, 2, 1, 30, 0, 0, time.UTC)), "Defaults to midnight UTC")
+}
+
+func TestTicketNumbers(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	newOrder := func(customerID *uuid.UUID) (int, error) {
+		return c.CreateOrder(CreateOrderParams{ForName: "Ada", Status: "pending", Total: "1.00", CustomerID: customerID})
+	}
+	ticket := func(id int) Order {
+		order, err := c.GetOrder(id)
+		require.NoError(t, err)
+		return order
+	}
+
+	first, err := newOrder(nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, ticket(first).TicketNumber)
+	require.Equal(t, BusinessDay{}.Of(time.Now()), ticket(first).BusinessDay)
+
+	missing := uuid.New()
+	_, err = newOrder(&missing)
+	require.ErrorIs(t, err, ErrCustomerNotFound)
+
+	second, err := newOrder(nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, ticket(second).TicketNumber, "Failed order shouldn't use up a number")
+
+	// Moving the cutoff puts now on another business day, which starts again from 1
+	c.SetBusinessDay(BusinessDay{Cutoff: -48 * time.Hour})
+	third, err := newOrder(nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, ticket(third).TicketNumber)
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// CreateUserTokenParams describes a single-use token emailed to a user. Only the hash of the token is stored.
+type CreateUserTokenParams struct {
+	UserID    uuid.UUID
+	TokenHash string
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+var ErrUserTokenInvalid = errors.New("token is invalid or expired")
+
+// CreateUserToken stores a new token, invalidating any unused token the user has for the same purpose.
+func (c *Client) CreateUserToken(params CreateUserTokenParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, params.UserID.String(), params.Purpose)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't invalidate previous tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_tokens (created_at, user_id, token_hash, purpose, expires_at)
+		VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`, params.UserID.String(), params.TokenHash, params.Purpose, params.ExpiresAt.UTC().Format(TIME_LAYOUT))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't create user token: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword consumes a password reset token, sets the new password hash and revokes all of the
+// user's refresh tokens. It returns the user whose password changed and the sessions that were ended.
+func (c *Client) ResetPassword(tokenHash, passwordHash string) (uuid.UUID, []uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	userID, err := consumeUserToken(tx, tokenHash, TokenPurposePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		return uuid.Nil, nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, passwordHash, userID.String())
+	if err != nil {
+		tx.Rollback()
+		return uuid.Nil, nil, fmt.Errorf("couldn't update password: %w", err)
+	}
+
+	rows, err := tx.Query(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+		RETURNING family_id
+	`, userID.String())
+	if err != nil {
+		tx.Rollback()
+		return uuid.Nil, nil, fmt.Errorf("couldn't revoke refresh tokens: %w", err)
+	}
+	sessions := []uuid.UUID{}
+	for rows.Next() {
+		var familyID string
+		if err := rows.Scan(&familyID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return uuid.Nil, nil, err
+		}
+		if id, err := uuid.Parse(familyID); err == nil {
+			sessions = append(sessions, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return uuid.Nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	return userID, sessions, nil
+}
+
+// VerifyEmail consumes an email verification token and marks the user's email as verified.
+func (c *Client) VerifyEmail(tokenHash string) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	userID, err := consumeUserToken(tx, tokenHash, TokenPurposeEmailVerification)
+	if err != nil {
+		tx.Rollback()
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, userID.String())
+	if err != nil {
+		tx.Rollback()
+		return uuid.Nil, fmt.Errorf("couldn't verify email: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
+
+// consumeUserToken marks an unused, unexpired token as used and returns its user.
+func consumeUserToken(tx *sql.Tx, tokenHash, purpose string) (uuid.UUID, error) {
+	var userID string
+	err := tx.QueryRow(`
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id
+	`, tokenHash, purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrUserTokenInvalid
+		}
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(userID)
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserTokens(t *testing.T) {
+	c, err := CreateTestClient(t)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer c.db.Close()
+
+	user, err := c.CreateUser(CreateUserParams{Email: "reset@test.com", Password: "old-hash", Role: "user"})
+	require.NoError(t, err, "Failed to create user")
+	require.Nil(t, user.EmailVerifiedAt, "New users should not be verified")
+
+	_, err = c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:     "session",
+		UserID:    user.ID,
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	t.Run("Reset password", func(t *testing.T) {
+		err := c.CreateUserToken(CreateUserTokenParams{
+			UserID: user.ID, TokenHash: "first", Purpose: TokenPurposePasswordReset, ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+		err = c.CreateUserToken(CreateUserTokenParams{
+			UserID: user.ID, TokenHash: "second", Purpose: TokenPurposePasswordReset, ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		_, _, err = c.ResetPassword("first", "new-hash")
+		require.ErrorIs(t, err, ErrUserTokenInvalid, "Older token should be invalidated by a newer one")
+
+		id, revoked, err := c.ResetPassword("second", "new-hash")
+		require.NoError(t, err)
+		require.Equal(t, user.ID, id)
+		require.Len(t, revoked, 1, "Reset should report the ended session")
+
+		updated, err := c.GetUserById(user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "new-hash", updated.Password)
+
+		sessions, err := c.GetSessionsByUser(user.ID)
+		require.NoError(t, err)
+		require.Empty(t, sessions, "Reset should sign out every session")
+
+		_, _, err = c.ResetPassword("second", "another-hash")
+		require.ErrorIs(t, err, ErrUserTokenInvalid, "Token should be single use")
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		err := c.CreateUserToken(CreateUserTokenParams{
+			UserID: user.ID, TokenHash: "expired", Purpose: TokenPurposePasswordReset, ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		require.NoError(t, err)
+
+		_, _, err = c.ResetPassword("expired", "new-hash")
+		require.ErrorIs(t, err, ErrUserTokenInvalid)
+	})
+
+	t.Run("Verify email", func(t *testing.T) {
+		err := c.CreateUserToken(CreateUserTokenParams{
+			UserID: user.ID, TokenHash: "verify", Purpose: TokenPurposeEmailVerification, ExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		_, _, err = c.ResetPassword("verify", "new-hash")
+		require.ErrorIs(t, err, ErrUserTokenInvalid, "Verification token can't reset a password")
+
+		_, err = c.VerifyEmail("verify")
+		require.NoError(t, err)
+
+		verified, err := c.GetUserByEmail("reset@test.com")
+		require.NoError(t, err)
+		require.NotNil(t, verified.EmailVerifiedAt)
+	})
+}
@@ -10,9 +10,10 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 	CreateUserParams
 }
 
@@ -56,15 +57,16 @@ func (c *Client) CreateUser(params CreateUserParams) (User, error) {
 
 func (c *Client) GetUserById(id uuid.UUID) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password_hash, role, first_name, last_name
+		SELECT id, created_at, updated_at, email_verified_at, email, password_hash, role, COALESCE(first_name, ''), COALESCE(last_name, '')
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
 	var created_at, updated_at string
+	var email_verified_at *string
 
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &created_at, &updated_at, &user.Email, &user.Password, &user.Role, &user.FirstName, &user.LastName)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &created_at, &updated_at, &email_verified_at, &user.Email, &user.Password, &user.Role, &user.FirstName, &user.LastName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -80,6 +82,13 @@ func (c *Client) GetUserById(id uuid.UUID) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
+	if email_verified_at != nil {
+		t, err := time.Parse(TIME_LAYOUT, *email_verified_at)
+		if err != nil {
+			return User{}, fmt.Errorf("couldn't parse email_verified_at: %w", err)
+		}
+		user.EmailVerifiedAt = &t
+	}
 
 	user.ID, err = uuid.Parse(idStr)
 	if err != nil {
@@ -88,21 +97,30 @@ func (c *Client) GetUserById(id uuid.UUID) (User, error) {
 	return user, nil
 }
 
+// GetUserByEmail matches email case-insensitively, like NormalizeEmail.
 func (c *Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, email, password_hash, role, first_name, last_name
+		SELECT id, email_verified_at, email, password_hash, role, first_name, last_name
 		FROM users
-		WHERE email = ?
+		WHERE LOWER(email) = LOWER(?)
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.Email, &user.Password, &user.Role, &user.FirstName, &user.LastName)
+	var email_verified_at *string
+	err := c.db.QueryRow(query, email).Scan(&id, &email_verified_at, &user.Email, &user.Password, &user.Role, &user.FirstName, &user.LastName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
+	if email_verified_at != nil {
+		t, err := time.Parse(TIME_LAYOUT, *email_verified_at)
+		if err != nil {
+			return User{}, fmt.Errorf("couldn't parse email_verified_at: %w", err)
+		}
+		user.EmailVerifiedAt = &t
+	}
 	user.ID, err = uuid.Parse(id)
 	if err != nil {
 		return User{}, err
@@ -116,6 +134,8 @@ func (c *Client) GetUserByRefreshToken(token string) (User, error) {
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
+			AND rt.revoked_at IS NULL
+			AND rt.expires_at > CURRENT_TIMESTAMP
 	`
 
 	var user User
@@ -145,6 +165,12 @@ func (c *Client) UpdateUser(params UpdateUserParams) (User, error) {
 	return c.GetUserById(params.ID)
 }
 
+func (c *Client) UpdateUserRole(id uuid.UUID, role string) error {
+	query := `UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
 func (c *Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
@@ -30,6 +30,9 @@ func TestUser(t *testing.T) {
 
 		_, err = c.GetUserByEmail("test@test.com")
 		require.NoError(t, err, "Failed to get user by email")
+		byEmail, err := c.GetUserByEmail("Test@Test.com")
+		require.NoError(t, err, "Failed to get user by email")
+		require.Equal(t, user.ID, byEmail.ID, "Email lookup should ignore case")
 
 		token, err := c.CreateRefreshToken(CreateRefreshTokenParams{
 			Token:     "testtoken",
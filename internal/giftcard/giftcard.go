@@ -0,0 +1,69 @@
+package giftcard
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// NUMBER_LENGTH is the number of digits on a card, including the trailing check digit.
+const NUMBER_LENGTH = 16
+
+var ErrInvalidNumber = errors.New("invalid gift card number")
+
+// GenerateNumber returns a random card number ending in a Luhn check digit.
+func GenerateNumber() (string, error) {
+	var b strings.Builder
+	for i := 0; i < NUMBER_LENGTH-1; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte('0' + n.Int64()))
+	}
+	payload := b.String()
+	return payload + string(checkDigit(payload)), nil
+}
+
+// Normalize strips the spaces and dashes cards are printed with and checks the check digit, so
+// typos are caught before the number is looked up.
+func Normalize(number string) (string, error) {
+	number = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, number)
+
+	if len(number) != NUMBER_LENGTH {
+		return "", ErrInvalidNumber
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidNumber
+		}
+	}
+	if checkDigit(number[:len(number)-1]) != number[len(number)-1] {
+		return "", ErrInvalidNumber
+	}
+	return number, nil
+}
+
+// checkDigit computes the Luhn check digit for payload.
+func checkDigit(payload string) byte {
+	sum := 0
+	double := true // the check digit will be appended, so the rightmost payload digit is doubled
+	for i := len(payload) - 1; i >= 0; i-- {
+		d := int(payload[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
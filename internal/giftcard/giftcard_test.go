@@ -0,0 +1,49 @@
+package giftcard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDigit(t *testing.T) {
+	// well-known Luhn test numbers
+	assert.Equal(t, byte('3'), checkDigit("7992739871"))
+	assert.Equal(t, byte('1'), checkDigit("411111111111111"))
+}
+
+func TestGenerateNumber(t *testing.T) {
+	number, err := GenerateNumber()
+	require.NoError(t, err)
+	require.Len(t, number, NUMBER_LENGTH)
+
+	normalized, err := Normalize(number)
+	require.NoError(t, err)
+	assert.Equal(t, number, normalized)
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "4111 1111 1111 1111", want: "4111111111111111"},
+		{input: "4111-1111-1111-1111", want: "4111111111111111"},
+		{input: "4111111111111112", wantErr: true},
+		{input: "411111111111111", wantErr: true},
+		{input: "41111111111111a1", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.input)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrInvalidNumber, tt.input)
+			continue
+		}
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got)
+	}
+}
@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir, "pos@test.com")
+	require.NoError(t, err, "Failed to create file mailer")
+
+	err = m.Send(Message{
+		To:      "user@test.com",
+		Subject: "Reset\r\nBcc: evil@test.com",
+		Body:    "Reset link",
+	})
+	require.NoError(t, err, "Failed to send mail")
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.True(t, strings.HasSuffix(files[0].Name(), "user@test.com.eml"))
+
+	dat, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(dat), "To: user@test.com\r\n")
+	require.Contains(t, string(dat), "Subject: ResetBcc: evil@test.com\r\n", "Header values should not contain newlines")
+	require.True(t, strings.HasSuffix(string(dat), "\r\n\r\nReset link"))
+}
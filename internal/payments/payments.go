@@ -0,0 +1,103 @@
+package payments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrDeclined        = errors.New("card declined")
+	ErrUnknownAuth     = errors.New("unknown or already settled authorization")
+	ErrOverAuthorized  = errors.New("amount is more than was authorized")
+	ErrInvalidAmount   = errors.New("amount must be positive")
+	ErrMissingCardData = errors.New("missing card token")
+)
+
+// Authorization is a hold placed on a customer's card, e.g. when a bar tab is opened. It is later
+// captured for the final amount or voided.
+type Authorization struct {
+	ID          string `json:"id"`
+	AmountCents int    `json:"amount_cents"`
+}
+
+// Gateway places holds on cards and settles them through a card processor. Card details never reach
+// the POS: the terminal tokenizes the card and only the token is passed on.
+type Gateway interface {
+	// Authorize places a hold of amountCents on the tokenized card.
+	Authorize(token string, amountCents int) (Authorization, error)
+	// Capture charges amountCents, up to the authorized amount, and returns the processor's reference.
+	Capture(authID string, amountCents int) (string, error)
+	// Void releases the hold without charging the card.
+	Void(authID string) error
+}
+
+// OfflineGateway approves every authorization and keeps it in memory instead of contacting a
+// processor. Use it for local development or where cards are run on a standalone terminal.
+type OfflineGateway struct {
+	mu    sync.Mutex
+	auths map[string]Authorization
+}
+
+func NewOfflineGateway() *OfflineGateway {
+	return &OfflineGateway{auths: map[string]Authorization{}}
+}
+
+func (g *OfflineGateway) Authorize(token string, amountCents int) (Authorization, error) {
+	if token == "" {
+		return Authorization{}, ErrMissingCardData
+	}
+	if amountCents <= 0 {
+		return Authorization{}, ErrInvalidAmount
+	}
+
+	id, err := randomID("auth")
+	if err != nil {
+		return Authorization{}, err
+	}
+	auth := Authorization{ID: id, AmountCents: amountCents}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.auths[id] = auth
+	return auth, nil
+}
+
+func (g *OfflineGateway) Capture(authID string, amountCents int) (string, error) {
+	if amountCents <= 0 {
+		return "", ErrInvalidAmount
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	auth, ok := g.auths[authID]
+	if !ok {
+		return "", ErrUnknownAuth
+	}
+	if amountCents > auth.AmountCents {
+		return "", fmt.Errorf("%w: %d > %d", ErrOverAuthorized, amountCents, auth.AmountCents)
+	}
+	delete(g.auths, authID)
+
+	return randomID("capture")
+}
+
+func (g *OfflineGateway) Void(authID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.auths[authID]; !ok {
+		return ErrUnknownAuth
+	}
+	delete(g.auths, authID)
+	return nil
+}
+
+func randomID(prefix string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + "_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,33 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineGateway(t *testing.T) {
+	g := NewOfflineGateway()
+
+	_, err := g.Authorize("", 5000)
+	require.ErrorIs(t, err, ErrMissingCardData)
+
+	auth, err := g.Authorize("tok_visa", 5000)
+	require.NoError(t, err)
+	require.Equal(t, 5000, auth.AmountCents)
+
+	_, err = g.Capture(auth.ID, 5001)
+	require.ErrorIs(t, err, ErrOverAuthorized)
+
+	ref, err := g.Capture(auth.ID, 4200)
+	require.NoError(t, err)
+	require.NotEmpty(t, ref)
+
+	_, err = g.Capture(auth.ID, 4200)
+	require.ErrorIs(t, err, ErrUnknownAuth, "a hold can only be captured once")
+	require.ErrorIs(t, g.Void(auth.ID), ErrUnknownAuth)
+
+	auth, err = g.Authorize("tok_visa", 5000)
+	require.NoError(t, err)
+	require.NoError(t, g.Void(auth.ID))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -8,7 +9,10 @@ import (
 	"time"
 
 	"github.com/chaeanthony/go-pos/api"
+	"github.com/chaeanthony/go-pos/internal/auth"
 	"github.com/chaeanthony/go-pos/internal/database"
+	"github.com/chaeanthony/go-pos/internal/mailer"
+	"github.com/chaeanthony/go-pos/internal/payments"
 	"github.com/charmbracelet/log"
 	"github.com/joho/godotenv"
 )
@@ -29,9 +33,9 @@ func main() {
 		log.Fatal("PORT must be set")
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET must be set")
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys: ", err)
 	}
 
 	db, err := database.NewClient(pathToDB)
@@ -57,48 +61,273 @@ func main() {
 		Formatter:       log.TextFormatter,
 	})
 
+	mfaRequiredRoles := map[string]bool{}
+	roles := os.Getenv("MFA_REQUIRED_ROLES")
+	if roles == "" {
+		roles = auth.RoleStore + "," + auth.RoleManager
+	}
+	for _, role := range splitList(roles) {
+		mfaRequiredRoles[role] = true
+	}
+
+	orderEditLockedStatuses := os.Getenv("ORDER_EDIT_LOCKED_STATUSES")
+	if orderEditLockedStatuses == "" {
+		orderEditLockedStatuses = database.OrderStatusCompleted
+	}
+
+	businessDay, err := loadBusinessDay()
+	if err != nil {
+		log.Fatal("Failed to load business day settings: ", err)
+	}
+	db.SetBusinessDay(businessDay)
+
+	prepLeadTime := 20 * time.Minute
+	if v := os.Getenv("ORDER_PREP_LEAD_TIME"); v != "" {
+		prepLeadTime, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid ORDER_PREP_LEAD_TIME %q: %v", v, err)
+		}
+	}
+
+	kitchenTargetPrepTime := 5 * time.Minute
+	if v := os.Getenv("KITCHEN_TARGET_PREP_TIME"); v != "" {
+		kitchenTargetPrepTime, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid KITCHEN_TARGET_PREP_TIME %q: %v", v, err)
+		}
+	}
+
+	paymentsGateway, err := loadPaymentsGateway()
+	if err != nil {
+		log.Fatal("Failed to load payments gateway: ", err)
+	}
+
 	hub := api.NewHub()
 
+	var mail mailer.Mailer
+	mailFrom := os.Getenv("MAIL_FROM")
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		mail = mailer.NewSMTPMailer(smtpHost, os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	} else {
+		// no SMTP in development, write emails to the mail folder instead
+		mail, err = mailer.NewFileMailer("mail", mailFrom)
+		if err != nil {
+			log.Fatal("Failed to create file mailer: ", err)
+		}
+	}
+
 	cfg := api.APIConfig{
-		DB:             db,
-		Port:           port,
-		JWTSecret:      jwtSecret,
-		Logger:         logger,
-		Hub:            hub,
-		CookieSecure:   strings.HasPrefix(frontend_origin, "https"),
-		CookieSameSite: http.SameSiteNoneMode,
+		DB:                      db,
+		Port:                    port,
+		JWTKeys:                 jwtKeys,
+		Logger:                  logger,
+		Hub:                     hub,
+		Denylist:                auth.NewDenylist(),
+		Mailer:                  mail,
+		Payments:                paymentsGateway,
+		FrontendOrigin:          frontend_origin,
+		MFARequiredRoles:        mfaRequiredRoles,
+		OrderEditLockedStatuses: splitList(orderEditLockedStatuses),
+		PrepLeadTime:            prepLeadTime,
+		KitchenTargetPrepTime:   kitchenTargetPrepTime,
+		CookieSecure:            strings.HasPrefix(frontend_origin, "https"),
+		CookieSameSite:          http.SameSiteNoneMode,
 	}
 
+	cfg.Scheduler = api.NewScheduler(&cfg)
+	go cfg.Scheduler.Run(context.Background())
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/healthz", cfg.HandlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.HandlerJWKS)
 
 	// mux.HandleFunc("POST /api/signup", cfg.HandlerUsersCreate)
 	mux.HandleFunc("POST /api/login", cfg.HandlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", cfg.HandlerLoginMFA)
+	mux.HandleFunc("POST /api/login/mfa/enroll", cfg.HandlerLoginMFAEnroll)
 	mux.HandleFunc("POST /api/refresh", cfg.HandlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.HandlerRevoke)
 	mux.HandleFunc("GET /api/session", cfg.HandlerSession)
+	mux.HandleFunc("GET /api/csrf", cfg.HandlerCSRF)
+	mux.HandleFunc("POST /api/password/forgot", cfg.HandlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.HandlerPasswordReset)
+	mux.HandleFunc("POST /api/email/verify", cfg.HandlerEmailVerify)
+	mux.Handle("POST /api/email/verify/resend", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerEmailVerifyResend)))
+	mux.Handle("GET /api/sessions", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerSessionsGet)))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerSessionsDelete)))
+	mux.Handle("POST /api/mfa/enroll", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerMFAEnroll)))
+	mux.Handle("POST /api/mfa/confirm", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerMFAConfirm)))
+	mux.Handle("DELETE /api/mfa", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerMFADisable)))
+	mux.Handle("GET /api/api-keys", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerAPIKeysGet)))
+	mux.Handle("POST /api/api-keys", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerAPIKeysCreate)))
+	mux.Handle("DELETE /api/api-keys/{keyID}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerAPIKeysDelete)))
+	mux.Handle("GET /api/login-attempts", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerLoginAttemptsGet)))
+	mux.Handle("PUT /api/users/{userID}/role", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerUsersRoleUpdate)))
+	mux.Handle("GET /api/audit", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerAuditGet)))
+	mux.Handle("GET /api/audit/verify", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerAuditVerify)))
 
 	mux.HandleFunc("GET /api/items", cfg.HandlerItemsGet)
 	mux.HandleFunc("GET /api/items/{itemID}", cfg.HandlerItemGetByID)
-	mux.Handle("POST /api/items", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsCreate)))
-	mux.Handle("PUT /api/items", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsUpdate)))
-	mux.Handle("DELETE /api/items/{itemID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsDelete)))
+	mux.Handle("POST /api/items", cfg.RequireScope(auth.ScopeItemsWrite, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsCreate))))
+	mux.Handle("PUT /api/items", cfg.RequireScope(auth.ScopeItemsWrite, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsUpdate))))
+	mux.Handle("DELETE /api/items/{itemID}", cfg.RequireScope(auth.ScopeItemsWrite, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerItemsDelete))))
+
+	mux.Handle("GET /api/orders", cfg.RequireScope(auth.ScopeOrdersRead, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrdersGet))))
+	mux.Handle("GET /api/orders/{orderID}", cfg.RequireScope(auth.ScopeOrdersRead, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderGet))))
+	mux.Handle("POST /api/orders", cfg.IdempotencyMiddleware(http.HandlerFunc(cfg.HandlerOrdersCreate)))
+	mux.Handle("PUT /api/orders", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerOrdersUpdate)))
+	mux.Handle("PUT /api/orders/{orderID}/notes", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderNotesUpdate)))
+	mux.Handle("POST /api/orders/{orderID}/items", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderItemsCreate)))
+	mux.Handle("PUT /api/orders/{orderID}/items/{lineID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderItemsUpdate)))
+	mux.Handle("DELETE /api/orders/{orderID}/items/{lineID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderItemsDelete)))
+	mux.Handle("GET /api/orders/{orderID}/ticket", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderTicket)))
+	mux.Handle("GET /api/me/orders", cfg.AuthMiddleware(http.HandlerFunc(cfg.HandlerMeOrders)))
+
+	mux.Handle("GET /api/customers", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerCustomersGet)))
+	mux.Handle("GET /api/customers/{customerID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerCustomerGet)))
+	mux.Handle("POST /api/customers", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerCustomersCreate)))
+
+	mux.Handle("GET /api/loyalty", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerLoyaltyLookup)))
+	mux.Handle("GET /api/loyalty/rules", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerLoyaltyRulesGet)))
+	mux.Handle("POST /api/loyalty/rules", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerLoyaltyRulesCreate)))
+	mux.Handle("DELETE /api/loyalty/rules/{ruleID}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerLoyaltyRulesDelete)))
+
+	mux.Handle("POST /api/gift-cards", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerGiftCardsCreate)))
+	mux.Handle("POST /api/gift-cards/balance", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerGiftCardBalance)))
+	mux.Handle("POST /api/gift-cards/reload", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerGiftCardReload)))
+	mux.Handle("POST /api/gift-cards/unlock", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerGiftCardUnlock)))
+	mux.Handle("GET /api/payments", cfg.RequireScope(auth.ScopePaymentsRead, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerPaymentsGet))))
+	mux.Handle("GET /api/orders/{orderID}/payments", cfg.RequireScope(auth.ScopePaymentsRead, cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerOrderPaymentsGet))))
+	mux.Handle("POST /api/orders/{orderID}/payments", cfg.StoreAuthMiddleware(cfg.IdempotencyMiddleware(http.HandlerFunc(cfg.HandlerOrderPaymentsCreate))))
+	mux.Handle("POST /api/orders/{orderID}/refund", cfg.ManagerAuthMiddleware(cfg.IdempotencyMiddleware(http.HandlerFunc(cfg.HandlerOrdersRefund))))
+
+	mux.Handle("GET /api/sections", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerSectionsGet)))
+	mux.Handle("POST /api/sections", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerSectionsCreate)))
+	mux.Handle("DELETE /api/sections/{sectionID}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerSectionsDelete)))
+	mux.Handle("GET /api/tables", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTablesGet)))
+	mux.Handle("GET /api/tables/{tableID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableGet)))
+	mux.Handle("POST /api/tables", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerTablesCreate)))
+	mux.Handle("PUT /api/tables/{tableID}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerTablesUpdate)))
+	mux.Handle("DELETE /api/tables/{tableID}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerTablesDelete)))
+	mux.Handle("POST /api/tables/{tableID}/seat", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableSeat)))
+	mux.Handle("PUT /api/tables/{tableID}/status", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableStatusUpdate)))
+	mux.Handle("POST /api/tables/{tableID}/move", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableMove)))
+	mux.Handle("POST /api/tables/{tableID}/merge", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableMerge)))
+	mux.Handle("POST /api/tables/{tableID}/clear", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTableClear)))
+
+	mux.Handle("GET /api/tabs", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTabsGet)))
+	mux.Handle("POST /api/tabs", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTabsCreate)))
+	mux.Handle("GET /api/tabs/{tabID}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTabGet)))
+	mux.Handle("POST /api/tabs/{tabID}/card", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerTabCard)))
+	mux.Handle("POST /api/tabs/{tabID}/close", cfg.StoreAuthMiddleware(cfg.IdempotencyMiddleware(http.HandlerFunc(cfg.HandlerTabClose))))
+	mux.Handle("GET /api/reports/open-tabs", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerOpenTabsReport)))
+	mux.Handle("GET /api/reports/kitchen", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerKitchenReport)))
 
-	mux.HandleFunc("GET /api/orders", cfg.HandlerOrdersGet)
-	mux.HandleFunc("POST /api/orders", cfg.HandlerOrdersCreate)
-	mux.Handle("PUT /api/orders", http.HandlerFunc(cfg.HandlerOrdersUpdate))
+	mux.Handle("GET /api/kds/routes", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerStationRoutesGet)))
+	mux.Handle("PUT /api/kds/routes", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerStationRoutesUpdate)))
+	mux.Handle("DELETE /api/kds/routes/{category}", cfg.ManagerAuthMiddleware(http.HandlerFunc(cfg.HandlerStationRoutesDelete)))
+	mux.Handle("GET /api/kds/{station}", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerKDSGet)))
+	mux.Handle("POST /api/kds/tickets/{ticketID}/start", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerKDSStart)))
+	mux.Handle("POST /api/kds/tickets/{ticketID}/bump", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerKDSBump)))
+	mux.Handle("POST /api/kds/tickets/{ticketID}/recall", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerKDSRecall)))
+
+	mux.Handle("POST /api/drawer/open", cfg.StoreAuthMiddleware(http.HandlerFunc(cfg.HandlerDrawerOpen)))
 
 	mux.Handle("/ws", http.HandlerFunc(cfg.WsHandler))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: enableCORS(mux, frontend_origin),
+		Handler: enableCORS(cfg.CSRFMiddleware(mux), frontend_origin),
 	}
 	logger.Infof("Serving on: %s:%s/", domain, port)
 	log.Printf("Serving on: %s:%s/. Logging to: %s", domain, port, f.Name())
 	log.Fatal(srv.ListenAndServe())
 }
 
+// loadJWTKeys signs with the private key in JWT_SIGNING_KEY_FILE, or the JWT_SECRET HMAC secret if no
+// key file is set. Keys in JWT_VERIFICATION_KEY_FILES and secrets in JWT_PREVIOUS_SECRETS are still
+// accepted for verification while tokens signed with them expire.
+func loadJWTKeys() (*auth.KeySet, error) {
+	var signing auth.Key
+	var verification []auth.Key
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		key, err := auth.LoadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signing = key
+		if jwtSecret != "" {
+			verification = append(verification, auth.NewHMACKey(jwtSecret))
+		}
+	} else if jwtSecret != "" {
+		signing = auth.NewHMACKey(jwtSecret)
+	} else {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE or JWT_SECRET must be set")
+	}
+
+	for _, path := range splitList(os.Getenv("JWT_VERIFICATION_KEY_FILES")) {
+		key, err := auth.LoadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, key)
+	}
+	for _, secret := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		verification = append(verification, auth.NewHMACKey(secret))
+	}
+
+	return auth.NewKeySet(signing, verification...)
+}
+
+// loadBusinessDay reads the store's timezone from STORE_TIMEZONE and the time ticket numbers start
+// again from BUSINESS_DAY_CUTOFF (HH:MM, 04:00 by default).
+func loadBusinessDay() (database.BusinessDay, error) {
+	loc, err := time.LoadLocation(os.Getenv("STORE_TIMEZONE")) // empty is UTC
+	if err != nil {
+		return database.BusinessDay{}, err
+	}
+
+	cutoff := os.Getenv("BUSINESS_DAY_CUTOFF")
+	if cutoff == "" {
+		cutoff = "04:00"
+	}
+	t, err := time.Parse("15:04", cutoff)
+	if err != nil {
+		return database.BusinessDay{}, fmt.Errorf("invalid BUSINESS_DAY_CUTOFF %q: %w", cutoff, err)
+	}
+
+	return database.BusinessDay{
+		Location: loc,
+		Cutoff:   time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute,
+	}, nil
+}
+
+// loadPaymentsGateway returns the gateway named by PAYMENTS_GATEWAY. The offline gateway approves
+// every card and forgets its holds on restart, so it is only used when asked for by name.
+func loadPaymentsGateway() (payments.Gateway, error) {
+	switch name := os.Getenv("PAYMENTS_GATEWAY"); name {
+	case "offline":
+		return payments.NewOfflineGateway(), nil
+	case "":
+		return nil, fmt.Errorf("PAYMENTS_GATEWAY must be set")
+	default:
+		return nil, fmt.Errorf("unknown PAYMENTS_GATEWAY %q", name)
+	}
+}
+
+// splitList splits a comma separated env value, dropping empty entries.
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func enableCORS(next http.Handler, origin string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if origin != "" {
@@ -106,7 +335,8 @@ func enableCORS(next http.Handler, origin string) http.Handler {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+auth.CSRFHeader+", "+api.IDEMPOTENCY_KEY_HEADER)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, Idempotent-Replayed")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)